@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Frame-change prefilter. Before running the detector on a picture,
+// ProcessImage compares its FrameHash with that of the camera's last analysed
+// picture. When they differ by at most frame_change_threshold bits (default
+// 4 of 64), the picture is taken to show the same scene and the last
+// detections are reused instead, counted by RecordImageSkipped("unchanged").
+// Everything after detection (zones, tracking, alerts, occupancy) still runs.
+// A camera is analysed afresh at least every frame_change_max_age seconds
+// (default 300); frame_change_filter false turns the prefilter off.
+
+const (
+	defaultFrameChangeThreshold = 4
+	defaultFrameChangeMaxAge    = 300
+)
+
+type analysedFrame struct {
+	hash       uint64
+	at         time.Time
+	detections []TritonDetection
+}
+
+var (
+	analysedMu     sync.Mutex
+	analysedFrames = make(map[string]analysedFrame) // pic topic -> last analysed frame
+)
+
+func frameFilterEnabled() bool {
+	return !Config.IsSet("frame_change_filter") || Config.GetBool("frame_change_filter")
+}
+
+// frameHash hashes a picture for the prefilter, reporting false when the
+// prefilter is off or the picture cannot be hashed.
+func frameHash(item MQTT_Item) (uint64, bool) {
+	if !frameFilterEnabled() {
+		return 0, false
+	}
+	hash, err := FrameHash(item.Data)
+	if err != nil {
+		Logger.Debug().Msgf("%s: not hashing frame: %v", item.Topic, err)
+		return 0, false
+	}
+	return hash, true
+}
+
+// unchangedFrame returns the detections of the camera's last analysed frame
+// when a frame with the given hash shows the same scene.
+func unchangedFrame(topic string, hash uint64, now time.Time) ([]TritonDetection, bool) {
+	threshold := defaultFrameChangeThreshold
+	if Config.IsSet("frame_change_threshold") {
+		threshold = Config.GetInt("frame_change_threshold")
+	}
+	maxAge := int64(defaultFrameChangeMaxAge)
+	if n := Config.GetInt64("frame_change_max_age"); n > 0 {
+		maxAge = n
+	}
+	analysedMu.Lock()
+	defer analysedMu.Unlock()
+	prev, ok := analysedFrames[topic]
+	if !ok || now.Sub(prev.at) > time.Duration(maxAge)*time.Second || HashDistance(prev.hash, hash) > threshold {
+		return nil, false
+	}
+	return prev.detections, true
+}
+
+// rememberFrame records the detections of a frame the detector analysed.
+func rememberFrame(topic string, hash uint64, dets []TritonDetection, now time.Time) {
+	analysedMu.Lock()
+	analysedFrames[topic] = analysedFrame{hash: hash, at: now, detections: dets}
+	analysedMu.Unlock()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// countingDetector counts Detect calls and sees a person in every frame.
+type countingDetector struct{ calls *atomic.Int32 }
+
+func (c countingDetector) Name() string { return "counting" }
+
+func (c countingDetector) Detect(_ context.Context, _ []byte) ([]TritonDetection, error) {
+	c.calls.Add(1)
+	return []TritonDetection{{Label: "person", Confidence: 0.9, XMin: 10, YMin: 10, XMax: 40, YMax: 90}}, nil
+}
+
+func TestProcessImageUnchangedFrames(t *testing.T) {
+	previous := CurrentDetector()
+	defer SetDetector(previous)
+	var calls atomic.Int32
+	SetDetector(countingDetector{&calls})
+	results_channel = make(chan MQTT_Item, 10)
+	defer func() {
+		analysedMu.Lock()
+		analysedFrames = make(map[string]analysedFrame)
+		analysedMu.Unlock()
+	}()
+
+	frame := func(x int) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 160, 120))
+		for py := range 120 {
+			for px := range 160 {
+				v := uint8(50 + px)
+				if px >= x && px < x+30 && py >= 30 {
+					v = 0
+				}
+				img.Set(px, py, color.RGBA{v, v, v, 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	process := func(data []byte) int {
+		t.Helper()
+		ProcessImage(MQTT_Item{Room: "frame_room", Topic: "frame/topic", Type: PIC, Data: data})
+		return (<-results_channel).Analysis_result
+	}
+
+	still := frame(20)
+	if process(still) != OCCUPIED || process(still) != OCCUPIED {
+		t.Error("unchanged frame lost the person")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("detector ran %d times for two identical frames", n)
+	}
+	if ci, ok := CacheGet("frame/topic"); !ok || len(ci.results.Predictions) != 1 {
+		t.Errorf("cache holds %+v", ci.results)
+	}
+
+	process(frame(110))
+	if n := calls.Load(); n != 2 {
+		t.Errorf("detector ran %d times; a changed frame should be analysed", n)
+	}
+
+	Config.Set("frame_change_filter", false)
+	defer Config.Set("frame_change_filter", nil)
+	if _, hashed := frameHash(MQTT_Item{Topic: "frame/topic", Data: still}); hashed {
+		t.Error("hashed a frame with the filter off")
+	}
+	process(frame(110))
+	if n := calls.Load(); n != 3 {
+		t.Errorf("detector ran %d times with the filter off", n)
+	}
+}
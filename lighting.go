@@ -0,0 +1,249 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elijahnyp/home_controller/state"
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Occupancy-driven lighting: rooms with lighting enabled turn their lights on
+// when they become occupied (optionally only when it is dark) and off once they
+// have been vacant for the off delay. Any light change we did not command
+// within lightingCommandWindow is treated as someone using the switch, and
+// suspends automation for that room for the override period; a room left
+// vacant meanwhile has its lights turned off once the override ends.
+
+const (
+	lightingCommandWindow         = 10 // seconds
+	defaultLightingOffDelay       = 60
+	defaultLightingOverridePeriod = 1800
+)
+
+type pendingCommand struct {
+	on bool
+	at int64
+}
+
+type roomLighting struct {
+	offTimer      *time.Timer
+	pending       map[string]pendingCommand
+	overrideUntil int64
+}
+
+type lightingController struct {
+	mu    sync.Mutex
+	rooms map[string]*roomLighting
+}
+
+var lighting = newLightingController()
+
+func newLightingController() *lightingController {
+	return &lightingController{rooms: make(map[string]*roomLighting)}
+}
+
+// room returns the per-room automation state. Callers must hold c.mu.
+func (c *lightingController) room(name string) *roomLighting {
+	rl, ok := c.rooms[name]
+	if !ok {
+		rl = &roomLighting{pending: make(map[string]pendingCommand)}
+		c.rooms[name] = rl
+	}
+	return rl
+}
+
+// OnOccupancy reacts to a room occupancy transition.
+func (c *lightingController) OnOccupancy(room string, occupied bool) {
+	cfg, ok := CurrentModel().FindRoom(room)
+	if !ok || !cfg.Lighting.Enabled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rl := c.room(room)
+	if rl.offTimer != nil {
+		rl.offTimer.Stop()
+		rl.offTimer = nil
+	}
+	now := time.Now().Unix()
+	if occupied {
+		if rl.overrideUntil > now {
+			Logger.Debug().Msgf("%s lighting overridden; ignoring occupancy", room)
+			return
+		}
+		if !lightingConditionsMet(cfg) {
+			Logger.Debug().Msgf("%s occupied but lighting conditions not met", room)
+			return
+		}
+		c.setLights(room, cfg.Lighting, rl, true)
+		return
+	}
+	c.armOffTimer(room, rl, max(lightingOffDelay(cfg), rl.overrideUntil-now))
+}
+
+func lightingOffDelay(cfg Room) int64 {
+	delay := cfg.Lighting.Off_delay
+	if delay <= 0 {
+		delay = Config.GetInt64("lighting_off_delay")
+	}
+	if delay <= 0 {
+		delay = defaultLightingOffDelay
+	}
+	return delay
+}
+
+// armOffTimer schedules vacancyExpired for the room, replacing any pending
+// timer. Callers must hold c.mu.
+func (c *lightingController) armOffTimer(room string, rl *roomLighting, seconds int64) {
+	if rl.offTimer != nil {
+		rl.offTimer.Stop()
+	}
+	rl.offTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() { c.vacancyExpired(room) })
+}
+
+// vacancyExpired turns a room's lights off once the off delay has passed
+// without the room becoming occupied again, waiting out any override.
+func (c *lightingController) vacancyExpired(room string) {
+	if occupied, _ := GetOccupancyState(room); occupied {
+		return
+	}
+	cfg, ok := CurrentModel().FindRoom(room)
+	if !ok || !cfg.Lighting.Enabled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rl := c.room(room)
+	rl.offTimer = nil
+	if remaining := rl.overrideUntil - time.Now().Unix(); remaining > 0 {
+		c.armOffTimer(room, rl, remaining)
+		return
+	}
+	c.setLights(room, cfg.Lighting, rl, false)
+}
+
+// lightingConditionsMet reports whether it is dark enough to turn the room's
+// lights on. With no lux sensor and no night restriction it always is.
+func lightingConditionsMet(cfg Room) bool {
+	l := cfg.Lighting
+	if l.Lux_sensor == "" && !l.Night_only {
+		return true
+	}
+	if l.Night_only && CurrentModel().Location.IsNight(time.Now()) {
+		return true
+	}
+	if l.Lux_sensor != "" {
+		if devRoom, ok := CurrentDevices().Room(cfg.Name); ok {
+			for _, s := range devRoom.Sensors() {
+				if s.Name() != l.Lux_sensor {
+					continue
+				}
+				if v, ok := s.Value(); ok && v < l.Lux_threshold {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// setLights commands the room's selected lights. Callers must hold c.mu.
+func (c *lightingController) setLights(room string, cfg LightingConfig, rl *roomLighting, on bool) {
+	devRoom, ok := CurrentDevices().Room(room)
+	if !ok {
+		return
+	}
+	now := time.Now().Unix()
+	for _, l := range devRoom.Lights() {
+		if !lightSelected(cfg.Lights, l.Name()) {
+			continue
+		}
+		if l.LastUpdate() > 0 && l.IsOn() == on {
+			continue
+		}
+		var st uint
+		if on {
+			st = 1
+		}
+		if err := l.State(st); err != nil {
+			Logger.Warn().Msgf("%s unable to switch light %s: %v", room, l.Name(), err)
+			continue
+		}
+		Logger.Info().Msgf("%s lighting: %s -> %v", room, l.Name(), on)
+		rl.pending[l.Name()] = pendingCommand{on: on, at: now}
+	}
+}
+
+func lightSelected(selected []string, name string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, s := range selected {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// onLightChange is the state change hook. A light change that does not match
+// a command we issued recently is a manual override.
+func (c *lightingController) onLightChange(d state.Device, previous string) {
+	light, ok := d.(*state.MQTTLight)
+	if !ok || previous == "" {
+		return
+	}
+	cfg, ok := CurrentModel().FindRoom(light.Location())
+	if !ok || !cfg.Lighting.Enabled || !lightSelected(cfg.Lighting.Lights, light.Name()) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rl := c.room(cfg.Name)
+	now := time.Now().Unix()
+	on := light.IsOn()
+	if p, ok := rl.pending[light.Name()]; ok {
+		delete(rl.pending, light.Name())
+		if p.on == on && now-p.at <= lightingCommandWindow {
+			return
+		}
+	}
+	period := cfg.Lighting.Override_period
+	if period <= 0 {
+		period = Config.GetInt64("lighting_override_period")
+	}
+	if period <= 0 {
+		period = defaultLightingOverridePeriod
+	}
+	rl.overrideUntil = now + period
+	Logger.Info().Msgf("%s light %s switched manually; automation suspended for %ds", cfg.Name, light.Name(), period)
+}
+
+// OverrideUntil returns the unix time until which automation is suspended for
+// the room, or 0 if it is not overridden.
+func (c *lightingController) OverrideUntil(room string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rl, ok := c.rooms[room]; ok && rl.overrideUntil > time.Now().Unix() {
+		return rl.overrideUntil
+	}
+	return 0
+}
+
+// SetOverride suspends lighting automation for the room for the given number
+// of seconds; zero or less clears an existing override, and a vacant room's
+// lights go off after the off delay.
+func (c *lightingController) SetOverride(room string, seconds int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rl := c.room(room)
+	if seconds <= 0 {
+		rl.overrideUntil = 0
+		if cfg, ok := CurrentModel().FindRoom(room); ok && rl.offTimer != nil {
+			c.armOffTimer(room, rl, lightingOffDelay(cfg))
+		}
+		return
+	}
+	rl.overrideUntil = time.Now().Unix() + seconds
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elijahnyp/home_controller/state"
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func setupLightingModel(lightingCfg LightingConfig) *lightingController {
+	m := &Model{
+		Rooms: []Room{{
+			Name: "office",
+			Lights: []DeviceConfig{{
+				Name:          "ceiling",
+				State_topic:   "z2m/ceiling",
+				State_field:   "state",
+				Command_topic: "z2m/ceiling/set",
+			}},
+			Sensors:  []DeviceConfig{{Name: "lux", State_topic: "z2m/lux"}},
+			Lighting: lightingCfg,
+		}},
+	}
+	SetModel(m)
+	SetDevices(state.NewManager(m, nil))
+	c := newLightingController()
+	state.RegisterChangeHook("lighting", c.onLightChange)
+	return c
+}
+
+func TestLightingTurnsOnAndDetectsOverride(t *testing.T) {
+	c := setupLightingModel(LightingConfig{Enabled: true, Off_delay: 3600})
+	defer state.RegisterChangeHook("lighting", nil)
+	devices := CurrentDevices()
+
+	// Initial retained state is not a manual change.
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"OFF"}`))
+
+	c.OnOccupancy("office", true)
+	c.mu.Lock()
+	p, ok := c.room("office").pending["ceiling"]
+	c.mu.Unlock()
+	if !ok || !p.on {
+		t.Fatalf("expected a pending ON command, got %+v (ok=%v)", p, ok)
+	}
+
+	// The light confirming our command is not an override.
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"ON"}`))
+	if c.OverrideUntil("office") != 0 {
+		t.Error("commanded change should not trigger an override")
+	}
+
+	// Someone switches it off by hand.
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"OFF"}`))
+	if c.OverrideUntil("office") == 0 {
+		t.Fatal("manual change should suspend automation")
+	}
+
+	// While overridden, occupancy does not turn the lights back on.
+	c.OnOccupancy("office", true)
+	c.mu.Lock()
+	_, ok = c.room("office").pending["ceiling"]
+	c.mu.Unlock()
+	if ok {
+		t.Error("overridden room should not command its lights")
+	}
+}
+
+func TestLightingOffAfterOverrideEnds(t *testing.T) {
+	c := setupLightingModel(LightingConfig{Enabled: true, Off_delay: 1, Override_period: 2})
+	defer state.RegisterChangeHook("lighting", nil)
+	devices := CurrentDevices()
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"OFF"}`))
+
+	// Occupied, then someone switches the light on by hand and leaves.
+	c.OnOccupancy("office", true)
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"ON"}`))
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"OFF"}`))
+	devices.HandleMessage("z2m/ceiling", []byte(`{"state":"ON"}`))
+	if c.OverrideUntil("office") == 0 {
+		t.Fatal("manual change should suspend automation")
+	}
+	c.OnOccupancy("office", false)
+
+	// The lights go off once the override has run out.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		p, ok := c.room("office").pending["ceiling"]
+		c.mu.Unlock()
+		if ok && !p.on {
+			if c.OverrideUntil("office") != 0 {
+				t.Error("lights turned off during the override")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lights left on after the override ended")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLightingVacancySchedulesOff(t *testing.T) {
+	c := setupLightingModel(LightingConfig{Enabled: true, Off_delay: 3600})
+	defer state.RegisterChangeHook("lighting", nil)
+
+	c.OnOccupancy("office", false)
+	c.mu.Lock()
+	timer := c.room("office").offTimer
+	c.mu.Unlock()
+	if timer == nil {
+		t.Fatal("vacancy should schedule lights off")
+	}
+
+	// Re-occupancy cancels the pending off.
+	c.OnOccupancy("office", true)
+	c.mu.Lock()
+	timer = c.room("office").offTimer
+	c.mu.Unlock()
+	if timer != nil {
+		t.Error("occupancy should cancel the off timer")
+	}
+}
+
+func TestLightingConditions(t *testing.T) {
+	setupLightingModel(LightingConfig{Enabled: true, Lux_sensor: "lux", Lux_threshold: 30})
+	defer state.RegisterChangeHook("lighting", nil)
+	cfg, _ := CurrentModel().FindRoom("office")
+
+	if lightingConditionsMet(cfg) {
+		t.Error("no lux reading yet: conditions should not be met")
+	}
+	CurrentDevices().HandleMessage("z2m/lux", []byte("250"))
+	if lightingConditionsMet(cfg) {
+		t.Error("bright room should not turn lights on")
+	}
+	CurrentDevices().HandleMessage("z2m/lux", []byte("12"))
+	if !lightingConditionsMet(cfg) {
+		t.Error("dark room should turn lights on")
+	}
+
+	cfg.Lighting = LightingConfig{Enabled: true}
+	if !lightingConditionsMet(cfg) {
+		t.Error("unconditional lighting should always be met")
+	}
+}
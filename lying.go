@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Lying-person alerts. Rooms with lying_alert set watch each of their cameras
+// for a person who looks to be lying down (see IsLying) outside the image
+// zones listed in lying_zones, such as a bed or sofa. Without pose keypoints
+// a detection only counts when lying_aspect is set and its box is clearly
+// wider than it is tall. Once a camera has seen someone lying in every
+// analysed frame for lying_alert seconds, the room raises an alert: "lying"
+// is published retained to <lying_alert_topic>/<room>, the activity feed and
+// websocket are told, and rules with a "lying" trigger fire. The alert
+// clears, publishing "clear", when that camera next sees nobody lying.
+
+// LyingAlert is the state published for a room's lying-person alert.
+type LyingAlert struct {
+	Room   string `json:"room"`
+	Camera string `json:"camera"`
+	State  string `json:"state"` // "lying" or "clear"
+	Since  int64  `json:"since"` // when the person was first seen lying
+}
+
+const (
+	lyingStateLying = "lying"
+	lyingStateClear = "clear"
+)
+
+type lyingWatch struct {
+	since   int64
+	alerted bool
+}
+
+var (
+	lyingMu      sync.Mutex
+	lyingCameras = make(map[string]*lyingWatch) // pic topic -> person seen lying
+)
+
+// checkLying updates the lying watch for a camera with the detections from
+// one of its frames.
+func checkLying(room, topic string, dets []TritonDetection, now time.Time) {
+	cfg, ok := CurrentModel().FindRoom(room)
+	if !ok || cfg.Lying_alert <= 0 {
+		return
+	}
+	lying := false
+	for _, d := range dets {
+		if d.Confidence >= minPersonConfidence() && IsLying(d, cfg.Lying_aspect) && !inAnyZone(d, cfg.Lying_zones) {
+			lying = true
+			break
+		}
+	}
+
+	lyingMu.Lock()
+	w := lyingCameras[topic]
+	var alert *LyingAlert
+	switch {
+	case lying && w == nil:
+		lyingCameras[topic] = &lyingWatch{since: now.Unix()}
+	case lying && !w.alerted && now.Unix()-w.since >= cfg.Lying_alert:
+		w.alerted = true
+		alert = &LyingAlert{Room: room, Camera: topic, State: lyingStateLying, Since: w.since}
+	case !lying && w != nil:
+		delete(lyingCameras, topic)
+		if w.alerted {
+			alert = &LyingAlert{Room: room, Camera: topic, State: lyingStateClear, Since: w.since}
+		}
+	}
+	lyingMu.Unlock()
+
+	if alert != nil {
+		raiseLyingAlert(*alert, now)
+	}
+}
+
+// inAnyZone reports whether d is in any of the named image zones.
+func inAnyZone(d TritonDetection, zones []string) bool {
+	return slices.ContainsFunc(d.ImageZones, func(z string) bool { return slices.Contains(zones, z) })
+}
+
+func raiseLyingAlert(a LyingAlert, now time.Time) {
+	msg := fmt.Sprintf("Person lying down in %s for %ds (%s)", a.Room, now.Unix()-a.Since, a.Camera)
+	if a.State == lyingStateClear {
+		msg = fmt.Sprintf("Nobody lying down in %s any more (%s)", a.Room, a.Camera)
+		Logger.Info().Msg(msg)
+	} else {
+		Logger.Warn().Msg(msg)
+	}
+	AddActivity("alert", a.Room, msg)
+	topic := Config.GetString("lying_alert_topic")
+	if topic == "" {
+		topic = "hab/alert/lying"
+	}
+	if payload, err := json.Marshal(a); err == nil {
+		PublishAsync(topic+"/"+a.Room, 0, true, payload)
+	}
+	if wsHub != nil {
+		wsHub.BroadcastUpdate("lying_alert", a)
+	}
+	ruleSet.Fire(RuleEvent{Kind: ruleTriggerLying, Room: a.Room, To: a.State, Time: now})
+}
+
+// minPersonConfidence is the min_confidence setting, defaulting to 0.5.
+func minPersonConfidence() float32 {
+	if c := float32(Config.GetFloat64("min_confidence")); c > 0 {
+		return c
+	}
+	return 0.5
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestCheckLying(t *testing.T) {
+	SetModel(&Model{Rooms: []Room{{Name: "bedroom", Lying_alert: 30, Lying_aspect: true, Lying_zones: []string{"bed"}}, {Name: "office"}}})
+	defer func() {
+		lyingMu.Lock()
+		lyingCameras = make(map[string]*lyingWatch)
+		lyingMu.Unlock()
+	}()
+	alerts := func() []string {
+		var out []string
+		for _, a := range RecentActivity() {
+			if a.Type == "alert" {
+				out = append(out, a.Message)
+			}
+		}
+		return out
+	}
+	before := len(alerts())
+
+	lying := []TritonDetection{{Label: "person", Confidence: 0.9, XMax: 100, YMax: 40}}
+	inBed := []TritonDetection{{Label: "person", Confidence: 0.9, XMax: 100, YMax: 40, ImageZones: []string{"bed"}}}
+	standing := []TritonDetection{{Label: "person", Confidence: 0.9, XMax: 40, YMax: 100}}
+	start := time.Unix(1000, 0)
+	const cam = "esp-cam/bedroom/image"
+
+	// Lying in bed is not watched.
+	checkLying("bedroom", cam, inBed, start.Add(-60*time.Second))
+	checkLying("bedroom", cam, inBed, start)
+	lyingMu.Lock()
+	watched := len(lyingCameras)
+	lyingMu.Unlock()
+	if watched != 0 {
+		t.Fatalf("watching %d cameras for someone in bed", watched)
+	}
+
+	checkLying("office", "esp-cam/office/image", lying, start)
+	checkLying("bedroom", cam, lying, start)
+	checkLying("bedroom", cam, lying, start.Add(20*time.Second))
+	if n := len(alerts()) - before; n != 0 {
+		t.Fatalf("%d alerts before lying_alert elapsed", n)
+	}
+	checkLying("bedroom", cam, lying, start.Add(30*time.Second))
+	checkLying("bedroom", cam, lying, start.Add(40*time.Second))
+	got := alerts()
+	if len(got)-before != 1 || got[0] != "Person lying down in bedroom for 30s ("+cam+")" {
+		t.Fatalf("expected one lying alert, got %q", got[:len(got)-before])
+	}
+
+	checkLying("bedroom", cam, standing, start.Add(50*time.Second))
+	got = alerts()
+	if len(got)-before != 2 || got[0] != "Nobody lying down in bedroom any more ("+cam+")" {
+		t.Errorf("expected the alert to clear, got %q", got[:len(got)-before])
+	}
+	lyingMu.Lock()
+	defer lyingMu.Unlock()
+	if len(lyingCameras) != 0 {
+		t.Errorf("cameras still watched: %v", lyingCameras)
+	}
+}
@@ -42,6 +42,8 @@ func MetricProviders() StateProviders {
 				"image":   len(image_channel),
 				"motion":  len(motion_channel),
 				"door":    len(door_channel),
+				"ble":     len(ble_channel),
+				"sensor":  len(sensor_channel),
 				"results": len(results_channel),
 			}
 		},
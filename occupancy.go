@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -15,6 +17,7 @@ import (
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/elijahnyp/home_controller/state"
 	. "github.com/elijahnyp/home_controller/util"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/inconsolata"
@@ -22,12 +25,17 @@ import (
 )
 
 type ai_result struct {
-	Label      string  `json:"label"`
-	Confidence float32 `json:"confidence"`
-	Y_min      int     `json:"y_min"`
-	X_min      int     `json:"x_min"`
-	X_max      int     `json:"x_max"`
-	Y_max      int     `json:"y_max"`
+	Label      string           `json:"label"`
+	Confidence float32          `json:"confidence"`
+	Y_min      int              `json:"y_min"`
+	X_min      int              `json:"x_min"`
+	X_max      int              `json:"x_max"`
+	Y_max      int              `json:"y_max"`
+	Keypoints  []Keypoint       `json:"keypoints,omitempty"`
+	Mask       *Mask            `json:"mask,omitempty"`
+	ImageZones []string         `json:"image_zones,omitempty"`
+	Track_id   int64            `json:"track_id,omitempty"`
+	Secondary  *SecondaryResult `json:"secondary,omitempty"`
 }
 
 type ai_results struct {
@@ -104,12 +112,54 @@ func ProcessImageRoutine() {
 }
 
 func ProcessImage(mimage MQTT_Item) {
-	detections, err := DetectObjects(mimage.Data)
+	detector := CurrentDetector()
+	night := CurrentModel().Location.IsNight(time.Now())
+	hash, hashed := frameHash(mimage)
+	var detections []TritonDetection
+	var took time.Duration
+	var err error
+	reused := false
+	if hashed {
+		detections, reused = unchangedFrame(mimage.Topic, hash, time.Now())
+	}
+	if reused {
+		Logger.Debug().Msgf("%s unchanged; reusing last detections", mimage.Topic)
+		RecordImageSkipped("unchanged")
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), DetectorTimeout())
+		ctx = WithCamera(ctx, mimage.Topic, night)
+		start := time.Now()
+		detections, err = detector.Detect(ctx, mimage.Data)
+		took = time.Since(start)
+		if err == nil {
+			detections = Cascade(ctx, mimage.Data, detections)
+		}
+		cancel()
+		if err == nil && hashed {
+			rememberFrame(mimage.Topic, hash, detections, time.Now())
+		}
+	}
 	if err != nil {
-		Logger.Warn().Msgf("Triton inference error for %s: %v", mimage.Topic, err)
+		if errors.Is(err, ErrDetectorUnavailable) {
+			Logger.Debug().Msgf("%s: skipping %s, detector unavailable", detector.Name(), mimage.Topic)
+			RecordImageSkipped("detector_unavailable")
+		} else {
+			Logger.Warn().Msgf("%s inference error for %s: %v", detector.Name(), mimage.Topic, err)
+		}
+		// Without a camera opinion the room falls back to motion, doors and
+		// the other sensors.
+		mimage.Analysis_result = CAM_UNKNOWN
+		results_channel <- mimage
 		return
 	}
 
+	// The shadow model is compared with everything the primary saw.
+	seen := detections
+	if cfg, ok := CurrentModel().FindRoom(mimage.Room); ok {
+		detections = PlaceInImageZones(cfg.Image_zones, mimage.Topic, detections)
+	}
+	detections = trackDetections(mimage.Room, mimage.Topic, detections, time.Now())
+
 	// Translate TritonDetection → ai_result so the rest of the codebase
 	// (cache, API, markup) continues to work without changes.
 	var results ai_results
@@ -121,21 +171,34 @@ func ProcessImage(mimage MQTT_Item) {
 			Y_min:      d.YMin,
 			X_max:      d.XMax,
 			Y_max:      d.YMax,
+			Keypoints:  d.Keypoints,
+			Mask:       d.Mask,
+			ImageZones: d.ImageZones,
+			Track_id:   d.TrackID,
+			Secondary:  d.Secondary,
 		})
 	}
 	results.Timestamp = time.Now().Unix()
 	results.Success = true
 
 	CacheSet(mimage.Topic, ImageCacheItem{mimage.Data, results})
+	if !reused && ShadowSample() {
+		go RunShadow(mimage.Topic, mimage.Room, night, mimage.Data, seen, took)
+	}
 
 	var person = false
 	var confidence float32
-	minConf := float32(Config.GetFloat64("min_confidence"))
-	if minConf <= 0 {
-		minConf = 0.5
-	}
+	minConf := minPersonConfidence()
+	recordRecognitions(mimage.Room, detections)
+	checkLying(mimage.Room, mimage.Topic, detections, time.Now())
+	events := make([]RuleEvent, 0, len(results.Predictions))
 	for _, r := range results.Predictions {
 		RecordObject(mimage.Room, r.Label, float64(r.Confidence))
+		ev := RuleEvent{Kind: ruleTriggerDetection, Room: mimage.Room, Label: r.Label, Confidence: float64(r.Confidence), ImageZones: r.ImageZones}
+		if r.Secondary != nil {
+			ev.Person = r.Secondary.Person
+		}
+		events = append(events, ev)
 		if r.Label == "person" {
 			person = true
 			if confidence < r.Confidence {
@@ -144,6 +207,8 @@ func ProcessImage(mimage MQTT_Item) {
 		}
 	}
 
+	ruleSet.FireFirst(events)
+
 	if person && confidence >= minConf {
 		Logger.Debug().Msgf("%s occupied: %.3f", mimage.Topic, confidence)
 		RecordPersonDetection(mimage.Room)
@@ -161,8 +226,10 @@ func OccupancyManagerRoutine() {
 	/*
 		remember, motion, cam, and door messages are separate, so only one type is
 		checked at a time.
-		occupancy on comes from motion, cam (person), or a door opening
-		occupancy off comes from expired cam period AND motion off
+		occupancy on comes from motion, cam (person), a door opening, BLE presence,
+		or an active threshold sensor
+		occupancy off comes from expired cam period AND motion off AND no BLE
+		presence AND no active threshold sensor AND nobody counted in
 
 		Basically, cameras are checked every x seconds and must not see a person in y seconds to say 'no person'
 		Motion (and a door opening) resets the period as does seeing a person.
@@ -187,6 +254,13 @@ func OccupancyManagerRoutine() {
 			} else {
 				cam_opinion = true
 			}
+		case CAM_UNKNOWN:
+			// The detector is down: the camera neither refreshes occupancy
+			// nor ends it, so the room is held by motion, doors, presence and
+			// sensors until the period runs out.
+			if room.GetLastOccupied() < now-CurrentModel().RoomOccupancyPeriod(item.Room) {
+				cam_opinion = false
+			}
 		case MOTION_START:
 			room.Occupied()
 			room.Motion(true)
@@ -196,17 +270,38 @@ func OccupancyManagerRoutine() {
 			// Intent: an opening door marks the room occupied and resets the
 			// timer, without asserting continuous motion.
 			room.Occupied()
+		case BLE_PRESENT:
+			room.Presence(true)
+		case BLE_ABSENT:
+			// The last known person left; like a camera miss, this only ends
+			// occupancy once the period has expired and motion is off.
+			room.Presence(false)
+			if room.GetLastOccupied() < now-CurrentModel().RoomOccupancyPeriod(item.Room) {
+				cam_opinion = false
+			}
+		case SENSOR_ON:
+			room.Sensor(true)
+		case SENSOR_OFF:
+			room.Sensor(false)
 		}
-		if !cam_opinion && !room.GetMotionState() {
+		if !cam_opinion && !room.GetMotionState() && !room.GetPresenceState() && !room.GetSensorState() && PeopleCount(item.Room) == 0 {
 			message = "false"
 		} else {
 			message = "true"
 		}
 
+		// A room script gets the last word on the decision.
+		if decision, ok := roomScripts.Decide(item, room, message == "true"); ok {
+			Logger.Debug().Msgf("%s script decided occupied=%v", item.Room, decision)
+			message = strconv.FormatBool(decision)
+		}
+
 		occupied := message == "true"
 
 		// Record occupancy transitions before updating the stored state.
-		if prev, existed := GetOccupancyState(item.Room); !existed || prev != occupied {
+		prev, existed := GetOccupancyState(item.Room)
+		transition := !existed || prev != occupied
+		if transition {
 			if occupied {
 				RecordOccupancyTransition(item.Room, "occupied")
 			} else {
@@ -217,6 +312,12 @@ func OccupancyManagerRoutine() {
 		// Update web-facing state.
 		SetOccupancyState(item.Room, occupied)
 
+		if transition {
+			AddActivity("occupancy", item.Room, fmt.Sprintf("%s %s", item.Room, occupancyWord(occupied)))
+			lighting.OnOccupancy(item.Room, occupied)
+			ruleSet.OnOccupancy(item.Room, occupied)
+		}
+
 		// Broadcast update via WebSocket if available
 		if wsHub != nil {
 			wsHub.BroadcastUpdate("room_status", map[string]interface{}{
@@ -224,9 +325,19 @@ func OccupancyManagerRoutine() {
 				"occupied":        occupied,
 				"motion":          room.GetMotionState(),
 				"person_detected": cam_opinion,
+				"people":          PeopleInRoom(item.Room),
+				"sensor_active":   room.GetSensorState(),
 			})
 		}
 
+		if devRoom, ok := CurrentDevices().Room(item.Room); ok {
+			st := devRoom.Status()
+			st.Occupied = occupied
+			if err := devRoom.SetState(st); err != nil {
+				Logger.Warn().Msgf("%s unable to update device room state: %v", item.Room, err)
+			}
+		}
+
 		CurrentModel().UpdateRoomStatus(item.Room, room)
 		PublishAsync(occupancy_topic, byte(0), false, []byte(message))
 	}
@@ -275,6 +386,7 @@ func MotionManagerRoutine() {
 			// occupancy trigger (see DoorManagerRoutine for dedicated door topics).
 			item.Analysis_result = DOOR_OPEN
 			results_channel <- item
+			ruleSet.Fire(RuleEvent{Kind: ruleTriggerDoor, Room: item.Room})
 		case "CLOSED":
 			// A closing door is not itself an occupancy signal; the cam/motion
 			// timers drive the room back to unoccupied.
@@ -296,6 +408,7 @@ func DoorManagerRoutine() {
 		case "OPEN", "ON", "1", "true":
 			item.Analysis_result = DOOR_OPEN
 			results_channel <- item
+			ruleSet.Fire(RuleEvent{Kind: ruleTriggerDoor, Room: item.Room})
 		case "CLOSED", "OFF", "0", "false":
 			Logger.Debug().Msgf("%s door closed (no-op)", item.Room)
 		default:
@@ -314,6 +427,8 @@ type MarkupSpec struct {
 	min        point
 	max        point
 	confidence float32
+	keypoints  []Keypoint
+	mask       *Mask
 }
 
 func MarkupImage(imgsource image.Image, specs []MarkupSpec) image.Image {
@@ -325,6 +440,9 @@ func MarkupImage(imgsource image.Image, specs []MarkupSpec) image.Image {
 			imgboxes.Set(x, y, imgsource.At(x, y))
 		}
 	}
+	for i, spec := range specs {
+		fillMask(imgboxes, spec.mask, maskColours[i%len(maskColours)])
+	}
 	for _, spec := range specs {
 		start := spec.min
 		end := spec.max
@@ -383,11 +501,71 @@ func MarkupImage(imgsource image.Image, specs []MarkupSpec) image.Image {
 		}
 
 		d.DrawString(fmt.Sprintf("%s - %.03f", label, spec.confidence))
+		drawSkeleton(imgboxes, spec.keypoints)
 	}
 
 	return imgboxes
 }
 
+// maskColours tell overlapping instances apart.
+var maskColours = []color.RGBA{
+	{30, 144, 255, 255}, {255, 165, 0, 255}, {148, 0, 211, 255}, {0, 206, 209, 255}, {255, 20, 147, 255},
+}
+
+// fillMask tints the pixels of a segmentation mask, leaving the picture
+// visible underneath.
+func fillMask(img *image.RGBA, m *Mask, c color.RGBA) {
+	const alpha = 0.45
+	m.Each(func(x, y int) {
+		if !(image.Point{x, y}.In(img.Rect)) {
+			return
+		}
+		p := img.RGBAAt(x, y)
+		blend := func(a, b uint8) uint8 { return uint8(float64(a)*(1-alpha) + float64(b)*alpha) }
+		img.SetRGBA(x, y, color.RGBA{blend(p.R, c.R), blend(p.G, c.G), blend(p.B, c.B), 255})
+	})
+}
+
+// drawSkeleton draws the visible keypoints of a pose and the limbs between
+// them in green.
+func drawSkeleton(img *image.RGBA, kpts []Keypoint) {
+	green := color.RGBA{0, 255, 0, 255}
+	visible := func(i int) bool { return i < len(kpts) && kpts[i].Confidence >= KeypointVisible }
+	for _, limb := range PoseSkeleton {
+		if !visible(limb[0]) || !visible(limb[1]) {
+			continue
+		}
+		a, b := kpts[limb[0]], kpts[limb[1]]
+		steps := max(abs(b.X-a.X), abs(b.Y-a.Y), 1)
+		for s := 0; s <= steps; s++ {
+			x := a.X + (b.X-a.X)*s/steps
+			y := a.Y + (b.Y-a.Y)*s/steps
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					img.Set(x+dx, y+dy, green)
+				}
+			}
+		}
+	}
+	for i, k := range kpts {
+		if !visible(i) {
+			continue
+		}
+		for dx := -3; dx <= 3; dx++ {
+			for dy := -3; dy <= 3; dy++ {
+				img.Set(k.X+dx, k.Y+dy, green)
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
 func HttpImage(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		if err := r.ParseForm(); err != nil {
@@ -399,7 +577,7 @@ func HttpImage(w http.ResponseWriter, r *http.Request) {
 		if cacheitem.im != nil {
 			var spec []MarkupSpec
 			for _, i := range cacheitem.results.Predictions {
-				s := MarkupSpec{i.Label, point{i.X_min, i.Y_min}, point{i.X_max, i.Y_max}, i.Confidence}
+				s := MarkupSpec{i.Label, point{i.X_min, i.Y_min}, point{i.X_max, i.Y_max}, i.Confidence, i.Keypoints, i.Mask}
 				spec = append(spec, s)
 			}
 			imgsource, err := jpeg.Decode(bytes.NewReader(cacheitem.im))
@@ -554,8 +732,9 @@ func ModelApi(w http.ResponseWriter, r *http.Request) {
 
 // init
 func Init() {
-	if err := InitTritonClient(); err != nil {
-		Logger.Fatal().Msgf("Failed to initialize Triton client: %v", err)
+	StartPublisher()
+	if err := InitDetector(); err != nil {
+		Logger.Fatal().Msgf("Failed to initialize detector: %v", err)
 	}
 	concurrency := Config.GetInt("inference_concurrency")
 	if concurrency <= 0 {
@@ -563,11 +742,13 @@ func Init() {
 	}
 	inferenceSem = make(chan struct{}, concurrency)
 	Logger.Info().Msgf("inference concurrency set to %d", concurrency)
-	StartPublisher()
+	state.RegisterChangeHook("lighting", lighting.onLightChange)
 	go ProcessImageRoutine()
 	go OccupancyManagerRoutine()
 	go MotionManagerRoutine()
 	go DoorManagerRoutine()
+	go PresenceManagerRoutine()
+	go SensorManagerRoutine()
 }
 
 // subscribedTopics tracks the topics we currently hold an MQTT subscription for,
@@ -628,6 +809,9 @@ func receiver(client MQTT.Client, message MQTT.Message) {
 	mitem.Data = message.Payload()
 	mitem.Topic = message.Topic()
 	mitem.Room = CurrentModel().FindRoomByTopic(message.Topic())
+	// Device state topics may double as evidence topics (e.g. a TV plug's power
+	// reading), so devices see every message before it is routed by type.
+	devHandled := CurrentDevices().HandleMessage(message.Topic(), message.Payload())
 	switch CurrentModel().FindTopicType(message.Topic()) {
 	case PIC:
 		mitem.Type = PIC
@@ -647,6 +831,27 @@ func receiver(client MQTT.Client, message MQTT.Message) {
 		RecordMessageReceived("door")
 		Logger.Debug().Msgf("door message received: queue len %v", len(door_channel))
 		door_channel <- mitem
+	case BLE:
+		mitem.Type = BLE
+		RecordMessageReceived("ble")
+		Logger.Debug().Msgf("ble message received: queue len %v", len(ble_channel))
+		ble_channel <- mitem
+	case SENSOR:
+		mitem.Type = SENSOR
+		RecordMessageReceived("sensor")
+		Logger.Debug().Msgf("sensor message received: queue len %v", len(sensor_channel))
+		sensor_channel <- mitem
+	case MODE:
+		mitem.Type = MODE
+		RecordMessageReceived("mode")
+		SetHouseMode(string(message.Payload()))
+		AddActivity("mode", "", "house mode "+string(message.Payload()))
+	case DEVICE:
+		mitem.Type = DEVICE
+		RecordMessageReceived("device")
+		if !devHandled {
+			Logger.Debug().Msgf("device topic %s not handled by any device", message.Topic())
+		}
 	default:
 		RecordMessageReceived("unknown")
 		Logger.Debug().Msgf("topic %s not found in model.  Fix subscription or add to model", message.Topic())
@@ -664,17 +869,24 @@ func main() {
 			return
 		}
 		SetModel(&m)
+		SetDevices(state.NewManager(&m, CurrentDevices()))
 		RecordConfigReload()
 	})
 	RegisterNewConfigListener(subscribeOccupancyTopics)
+	RegisterNewConfigListener(ruleSet.Load)
+	RegisterNewConfigListener(roomScripts.Load)
 	RegisterNewConfigListener(func() {
-		if err := InitTritonClient(); err != nil {
-			Logger.Error().Msgf("Failed to reinitialize Triton client on config change: %v", err)
+		if err := InitDetector(); err != nil {
+			Logger.Error().Msgf("Failed to reinitialize detector on config change: %v", err)
 		}
 	})
 	RegisterMQTTConnectHook("haadvertise", func(_ MQTT.Client) {
 		AdvertiseHA(CurrentModel().Rooms)
 	})
+	OnDetectorHealthChange(onDetectorHealth)
+	RegisterMQTTConnectHook("detector_status", func(_ MQTT.Client) {
+		publishDetectorHealth(CurrentDetectorHealth())
+	})
 	RegisterNewConfigListener(MqttInit)
 	if Config.GetBool("insecure_tls") {
 		Logger.Debug().Msg("disabling tls")
@@ -699,6 +911,9 @@ func main() {
 	monitor.AddHandler("/ws", ServeWebSocket)
 	monitor.AddHandler("/api/status", APISystemStatus)
 	monitor.AddHandler("/api/room", APIRoomDetail)
+	monitor.AddHandler("/api/presence", APIPresence)
+	monitor.AddHandler("/api/shadow", APIShadow)
+	monitor.AddHandler("/api/gallery", APIGallery)
 	monitor.AddHandler("/room_detail", RoomDetailHandler)
 
 	// Prometheus metrics endpoint
@@ -727,6 +942,36 @@ func OnlinePinger() {
 	}
 }
 
+// onDetectorHealth reports detector state changes on MQTT, in the activity
+// feed and to web clients.
+func onDetectorHealth(h DetectorHealth) {
+	publishDetectorHealth(h)
+	switch h.State {
+	case BreakerOpen:
+		AddActivity("detector", "", fmt.Sprintf("%s detector unavailable: %s", h.Backend, h.LastError))
+	case BreakerClosed:
+		AddActivity("detector", "", fmt.Sprintf("%s detector available", h.Backend))
+	}
+	if wsHub != nil {
+		wsHub.BroadcastUpdate("detector_status", h)
+	}
+}
+
+// publishDetectorHealth publishes the detector health, retained, to
+// `detector_status_topic`.
+func publishDetectorHealth(h DetectorHealth) {
+	topic := Config.GetString("detector_status_topic")
+	if topic == "" {
+		return
+	}
+	payload, err := json.Marshal(h)
+	if err != nil {
+		Logger.Error().Msgf("Error encoding detector status: %v", err)
+		return
+	}
+	PublishAsync(topic, 0, true, payload)
+}
+
 // HAAdvertiser - advertises Home Assistant discovery messages every 5 minutes
 func HAAdvertiser() {
 	ticker := time.NewTicker(5 * time.Minute)
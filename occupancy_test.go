@@ -20,11 +20,29 @@ import (
 	"google.golang.org/grpc"
 )
 
-// mockTritonServer implements just ModelInfer, returning a single person detection.
+// mockTritonServer serves a yolo11 model, returning a single person detection.
 type mockTritonServer struct {
 	tritonpb.UnimplementedGRPCInferenceServiceServer
 }
 
+func (s *mockTritonServer) ServerReady(context.Context, *tritonpb.ServerReadyRequest) (*tritonpb.ServerReadyResponse, error) {
+	return &tritonpb.ServerReadyResponse{Ready: true}, nil
+}
+
+func (s *mockTritonServer) ModelReady(context.Context, *tritonpb.ModelReadyRequest) (*tritonpb.ModelReadyResponse, error) {
+	return &tritonpb.ModelReadyResponse{Ready: true}, nil
+}
+
+func (s *mockTritonServer) ModelMetadata(context.Context, *tritonpb.ModelMetadataRequest) (*tritonpb.ModelMetadataResponse, error) {
+	return &tritonpb.ModelMetadataResponse{
+		Name:     "yolo11",
+		Versions: []string{"1"},
+		Platform: "onnxruntime_onnx",
+		Inputs:   []*tritonpb.ModelMetadataResponse_TensorMetadata{{Name: "images", Datatype: "FP32", Shape: []int64{1, 3, 640, 640}}},
+		Outputs:  []*tritonpb.ModelMetadataResponse_TensorMetadata{{Name: "output0", Datatype: "FP32", Shape: []int64{1, 84, 8400}}},
+	}, nil
+}
+
 func (s *mockTritonServer) ModelInfer(_ context.Context, _ *tritonpb.ModelInferRequest) (*tritonpb.ModelInferResponse, error) {
 	// Build a minimal YOLO11 output tensor [1, 84, 8400] with one person.
 	const numClasses = 80
@@ -92,8 +110,9 @@ func TestProcessImage(t *testing.T) {
 	Config.Set("triton_iou_threshold", 0.45)
 	Config.Set("frequency", 1)
 
-	if err := InitTritonClient(); err != nil {
-		t.Fatalf("InitTritonClient: %v", err)
+	Config.Set("detector_backend", DetectorTritonGRPC)
+	if err := InitDetector(); err != nil {
+		t.Fatalf("InitDetector: %v", err)
 	}
 
 	// Build a small test JPEG.
@@ -137,6 +156,68 @@ func TestProcessImage(t *testing.T) {
 	}
 }
 
+// fakeDetector returns canned detections without an inference server.
+type fakeDetector struct {
+	dets []TritonDetection
+	err  error
+}
+
+func (f fakeDetector) Name() string { return "fake" }
+
+func (f fakeDetector) Detect(_ context.Context, _ []byte) ([]TritonDetection, error) {
+	return f.dets, f.err
+}
+
+func TestProcessImageWithFakeDetector(t *testing.T) {
+	previous := CurrentDetector()
+	defer SetDetector(previous)
+	Config.Set("min_confidence", 0.5)
+	results_channel = make(chan MQTT_Item, 10)
+
+	tests := []struct {
+		name     string
+		dets     []TritonDetection
+		expected int
+	}{
+		{"person", []TritonDetection{{Label: "person", Confidence: 0.8, XMax: 10, YMax: 10}}, OCCUPIED},
+		{"low confidence person", []TritonDetection{{Label: "person", Confidence: 0.3}}, UNOCCUPIED},
+		{"cat", []TritonDetection{{Label: "cat", Confidence: 0.9}}, UNOCCUPIED},
+		{"nothing", nil, UNOCCUPIED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetDetector(fakeDetector{dets: tt.dets})
+			ProcessImage(MQTT_Item{Room: "fake_room", Topic: "fake/topic", Type: PIC, Data: []byte("jpeg")})
+			select {
+			case result := <-results_channel:
+				if result.Analysis_result != tt.expected {
+					t.Errorf("Analysis_result = %d, expected %d", result.Analysis_result, tt.expected)
+				}
+			default:
+				t.Fatal("no result produced")
+			}
+			ci, ok := CacheGet("fake/topic")
+			if !ok || len(ci.results.Predictions) != len(tt.dets) {
+				t.Errorf("cache holds %+v, expected %d predictions", ci.results, len(tt.dets))
+			}
+		})
+	}
+
+	// A failed or unavailable detector leaves the camera without an opinion.
+	for _, err := range []error{context.DeadlineExceeded, ErrDetectorUnavailable} {
+		SetDetector(fakeDetector{err: err})
+		ProcessImage(MQTT_Item{Room: "fake_room", Topic: "fake/topic", Type: PIC})
+		select {
+		case result := <-results_channel:
+			if result.Analysis_result != CAM_UNKNOWN {
+				t.Errorf("%v: Analysis_result = %d, expected CAM_UNKNOWN", err, result.Analysis_result)
+			}
+		default:
+			t.Errorf("%v: no result produced", err)
+		}
+	}
+}
+
 func TestMarkupImage(t *testing.T) {
 	// Create test image
 	img := image.NewRGBA(image.Rect(0, 0, 300, 300))
@@ -170,6 +251,34 @@ func TestMarkupImage(t *testing.T) {
 	if r < 50000 { // Should be red
 		t.Error("Expected red markup at position (50, 50)")
 	}
+
+	// Visible keypoints and the limbs between them are drawn in green.
+	kpts := make([]Keypoint, PoseKeypoints)
+	kpts[KeypointLeftShoulder] = Keypoint{X: 80, Y: 80, Confidence: 0.9}
+	kpts[KeypointLeftHip] = Keypoint{X: 80, Y: 120, Confidence: 0.9}
+	kpts[KeypointLeftKnee] = Keypoint{X: 120, Y: 120, Confidence: 0.1}
+	specs[0].keypoints = kpts
+	markedImg = MarkupImage(img, specs)
+	for _, p := range []image.Point{{80, 80}, {80, 100}, {80, 120}} {
+		if r, g, _, _ := markedImg.At(p.X, p.Y).RGBA(); g < 50000 || r > 10000 {
+			t.Errorf("expected green skeleton at %v", p)
+		}
+	}
+	if _, g, _, _ := markedImg.At(110, 120).RGBA(); g > 50000 {
+		t.Error("hidden knee should not be drawn")
+	}
+
+	// Masks are tinted, translucently.
+	specs = []MarkupSpec{{min: point{x: 200, y: 200}, max: point{x: 290, y: 290}, label: "person", confidence: 0.9}}
+	specs[0].mask = &Mask{X: 220, Y: 220, Width: 10, Height: 10, Runs: []int{0, 100}}
+	markedImg = MarkupImage(img, specs)
+	r, g, b, _ := markedImg.At(225, 225).RGBA()
+	if b>>8 <= 100 || r>>8 >= 100 || b>>8 == 255 {
+		t.Errorf("mask pixel (%d, %d, %d) not tinted", r>>8, g>>8, b>>8)
+	}
+	if r, g, b, _ := markedImg.At(235, 235).RGBA(); r>>8 != 100 || g>>8 != 100 || b>>8 != 100 {
+		t.Error("pixel outside the mask tinted")
+	}
 }
 
 func TestMotionManagerRoutine(t *testing.T) {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// People counting. Tracks crossing a room's count_lines (see
+// util/count_lines.go) move its running count up or down; the count never
+// drops below zero. The count is published retained to the room's count_topic
+// and goes out over the websocket as "people_count", and each crossing fires
+// "line" rules. A room with people counted in stays occupied. Because counts
+// drift when a crossing is missed, the count resets to zero once all of the
+// room's cameras have shown nobody for the room's occupancy period, so a
+// single missed detection does not wipe a correct count.
+
+// PeopleCountUpdate is the websocket update for a room's count.
+type PeopleCountUpdate struct {
+	Room  string `json:"room"`
+	Count int    `json:"count"`
+}
+
+var (
+	countMu      sync.Mutex
+	peopleCounts = make(map[string]int)             // room -> people counted in
+	roomCameras  = make(map[string]map[string]bool) // room -> pic topic -> latest frame showed a person
+	emptySince   = make(map[string]time.Time)       // room -> when its cameras last started showing nobody
+)
+
+// PeopleCount returns the number of people counted into room.
+func PeopleCount(room string) int {
+	countMu.Lock()
+	defer countMu.Unlock()
+	return peopleCounts[room]
+}
+
+// countCrossings applies a frame's track moves to the room's count lines.
+// person says whether the frame, taken at now, showed anyone.
+func countCrossings(room, topic string, moves []TrackMove, person bool, now time.Time) {
+	cfg, ok := CurrentModel().FindRoom(room)
+	if !ok || len(cfg.Count_lines) == 0 {
+		return
+	}
+	countMu.Lock()
+	if roomCameras[room] == nil {
+		roomCameras[room] = make(map[string]bool)
+	}
+	roomCameras[room][topic] = person
+	count := peopleCounts[room]
+	countMu.Unlock()
+
+	for _, m := range moves {
+		for _, l := range cfg.Count_lines {
+			if !l.AppliesTo(topic) {
+				continue
+			}
+			dir := l.Crossing(m)
+			if dir == "" {
+				continue
+			}
+			countMu.Lock()
+			if dir == CrossIn {
+				peopleCounts[room]++
+			} else if peopleCounts[room] > 0 {
+				peopleCounts[room]--
+			}
+			count = peopleCounts[room]
+			countMu.Unlock()
+			verb := "came into"
+			if dir == CrossOut {
+				verb = "left"
+			}
+			AddActivity("count", room, fmt.Sprintf("%s %d %s %s through %s (%d now)", m.Label, m.ID, verb, room, l.Name, count))
+			ruleSet.Fire(RuleEvent{Kind: ruleTriggerLine, Room: room, To: dir, Label: m.Label, Line: l.Name})
+			publishPeopleCount(cfg, count)
+		}
+	}
+
+	period := time.Duration(CurrentModel().RoomOccupancyPeriod(room)) * time.Second
+	countMu.Lock()
+	empty := peopleCounts[room] > 0
+	for _, seen := range roomCameras[room] {
+		empty = empty && !seen
+	}
+	reset := false
+	switch {
+	case !empty:
+		delete(emptySince, room)
+	case emptySince[room].IsZero():
+		emptySince[room] = now
+	case now.Sub(emptySince[room]) >= period:
+		peopleCounts[room] = 0
+		delete(emptySince, room)
+		reset = true
+	}
+	countMu.Unlock()
+	if reset {
+		Logger.Info().Msgf("%s: cameras have seen nobody for %v; people count reset", room, period)
+		AddActivity("count", room, fmt.Sprintf("nobody in %s; count reset", room))
+		publishPeopleCount(cfg, 0)
+	}
+}
+
+func publishPeopleCount(cfg Room, count int) {
+	topic := cfg.Count_topic
+	if topic == "" && cfg.Occupancy_topic != "" {
+		topic = cfg.Occupancy_topic + "/count"
+	}
+	if topic != "" {
+		PublishAsync(topic, 0, true, []byte(strconv.Itoa(count)))
+	}
+	if wsHub != nil {
+		wsHub.BroadcastUpdate("people_count", PeopleCountUpdate{Room: cfg.Name, Count: count})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestCountCrossings(t *testing.T) {
+	SetModel(&Model{Rooms: []Room{{
+		Name:             "kitchen",
+		Occupancy_period: 60,
+		Count_lines:      []CountLine{{Name: "doorway", Topics: []string{"cams/kitchen"}, From: []int{0, 100}, To: []int{200, 100}}},
+	}}})
+	defer func() {
+		countMu.Lock()
+		peopleCounts = make(map[string]int)
+		roomCameras = make(map[string]map[string]bool)
+		emptySince = make(map[string]time.Time)
+		countMu.Unlock()
+	}()
+	in := TrackMove{Track: Track{ID: 7, Label: "person"}, From: image.Pt(50, 150), To: image.Pt(50, 50)}
+	out := TrackMove{Track: Track{ID: 8, Label: "person"}, From: image.Pt(50, 50), To: image.Pt(50, 150)}
+	now := time.Unix(1000, 0)
+
+	countCrossings("kitchen", "cams/kitchen", []TrackMove{in, in}, true, now)
+	countCrossings("kitchen", "cams/other", []TrackMove{in}, true, now)
+	if n := PeopleCount("kitchen"); n != 2 {
+		t.Fatalf("count %d after two in, expected 2", n)
+	}
+	if a := RecentActivity()[0]; a.Type != "count" || a.Message != "person 7 came into kitchen through doorway (2 now)" {
+		t.Errorf("activity %+v", a)
+	}
+	countCrossings("kitchen", "cams/kitchen", []TrackMove{out, out, out}, true, now)
+	if n := PeopleCount("kitchen"); n != 0 {
+		t.Fatalf("count %d after three out, expected 0", n)
+	}
+
+	// Nobody in view of every camera for the occupancy period resets a
+	// drifted count; a missed detection or two does not.
+	countCrossings("kitchen", "cams/kitchen", []TrackMove{in}, true, now)
+	countCrossings("kitchen", "cams/kitchen", nil, false, now)
+	countCrossings("kitchen", "cams/other", nil, true, now)
+	if n := PeopleCount("kitchen"); n != 1 {
+		t.Fatalf("count %d, expected 1 while cams/other still sees someone", n)
+	}
+	countCrossings("kitchen", "cams/other", nil, false, now.Add(10*time.Second))
+	countCrossings("kitchen", "cams/other", nil, true, now.Add(20*time.Second))
+	countCrossings("kitchen", "cams/other", nil, false, now.Add(30*time.Second))
+	countCrossings("kitchen", "cams/other", nil, false, now.Add(80*time.Second))
+	if n := PeopleCount("kitchen"); n != 1 {
+		t.Fatalf("count %d after a missed detection, expected 1", n)
+	}
+	countCrossings("kitchen", "cams/kitchen", nil, false, now.Add(90*time.Second))
+	if n := PeopleCount("kitchen"); n != 0 {
+		t.Errorf("count %d once the room has looked empty for its occupancy period", n)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// BLE presence: ESPresense nodes publish one report per beacon they can hear on
+// espresense/devices/<beacon>/<node>. Rooms list the filters for their nodes in
+// ble_topics and people list their beacon ids. Each person is placed in the room
+// whose node reported the shortest distance within ble_presence_timeout; rooms
+// that gain or lose their last person get BLE_PRESENT/BLE_ABSENT evidence.
+
+var ble_channel = make(chan MQTT_Item, 10)
+
+type blePayload struct {
+	ID       string  `json:"id"`
+	Distance float64 `json:"distance"`
+}
+
+type bleReading struct {
+	distance float64
+	seen     int64
+}
+
+// PersonLocation is the current BLE-derived location of a person.
+type PersonLocation struct {
+	Room     string  `json:"room"`
+	Distance float64 `json:"distance"`
+	LastSeen int64   `json:"last_seen"`
+}
+
+// presenceTracker keeps the latest reading per (person, room) and derives each
+// person's room. It is owned by PresenceManagerRoutine; the published snapshot
+// for HTTP readers lives behind presenceMu.
+type presenceTracker struct {
+	readings map[string]map[string]bleReading // person -> room -> reading
+	location map[string]string                // person -> room ("" when away)
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		readings: make(map[string]map[string]bleReading),
+		location: make(map[string]string),
+	}
+}
+
+// observe records a reading and returns the people whose room changed.
+func (p *presenceTracker) observe(person, room string, distance float64, now int64) []string {
+	if p.readings[person] == nil {
+		p.readings[person] = make(map[string]bleReading)
+	}
+	p.readings[person][room] = bleReading{distance: distance, seen: now}
+	return p.resolve(now, []string{person})
+}
+
+// expire drops stale readings for everyone and returns the people whose room
+// changed as a result.
+func (p *presenceTracker) expire(now int64) []string {
+	people := make([]string, 0, len(p.readings))
+	for person := range p.readings {
+		people = append(people, person)
+	}
+	sort.Strings(people)
+	return p.resolve(now, people)
+}
+
+func (p *presenceTracker) resolve(now int64, people []string) []string {
+	timeout := Config.GetInt64("ble_presence_timeout")
+	var changed []string
+	for _, person := range people {
+		best := ""
+		bestDist := 0.0
+		for room, r := range p.readings[person] {
+			if timeout > 0 && r.seen < now-timeout {
+				delete(p.readings[person], room)
+				continue
+			}
+			if best == "" || r.distance < bestDist || (r.distance == bestDist && room < best) {
+				best = room
+				bestDist = r.distance
+			}
+		}
+		if p.location[person] != best {
+			p.location[person] = best
+			changed = append(changed, person)
+		}
+	}
+	return changed
+}
+
+// occupants returns the people currently placed in room.
+func (p *presenceTracker) occupants(room string) []string {
+	var out []string
+	for person, r := range p.location {
+		if r == room {
+			out = append(out, person)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// snapshot returns the current location of every tracked person.
+func (p *presenceTracker) snapshot() map[string]PersonLocation {
+	out := make(map[string]PersonLocation)
+	for person, room := range p.location {
+		loc := PersonLocation{Room: room}
+		if r, ok := p.readings[person][room]; ok {
+			loc.Distance = r.distance
+			loc.LastSeen = r.seen
+		}
+		out[person] = loc
+	}
+	return out
+}
+
+var (
+	presenceMu       sync.RWMutex
+	presenceSnapshot = make(map[string]PersonLocation)
+)
+
+// GetPresence returns a copy of the current person -> location map.
+func GetPresence() map[string]PersonLocation {
+	presenceMu.RLock()
+	defer presenceMu.RUnlock()
+	out := make(map[string]PersonLocation, len(presenceSnapshot))
+	for k, v := range presenceSnapshot {
+		out[k] = v
+	}
+	return out
+}
+
+// PeopleInRoom returns the people currently placed in room by BLE presence
+// or camera recognition.
+func PeopleInRoom(room string) []string {
+	presenceMu.RLock()
+	var out []string
+	for person, loc := range presenceSnapshot {
+		if loc.Room == room {
+			out = append(out, person)
+		}
+	}
+	presenceMu.RUnlock()
+	for _, person := range RecognizedInRoom(room) {
+		if !slices.Contains(out, person) {
+			out = append(out, person)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parseBLEReport extracts the beacon id and distance from an ESPresense device
+// report. The id falls back to the <beacon> segment of the topic.
+func parseBLEReport(topic string, data []byte) (string, float64, bool) {
+	var payload blePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return "", 0, false
+	}
+	id := payload.ID
+	if id == "" {
+		parts := strings.Split(topic, "/")
+		if len(parts) < 2 {
+			return "", 0, false
+		}
+		id = parts[len(parts)-2]
+	}
+	return id, payload.Distance, id != ""
+}
+
+func PresenceManagerRoutine() {
+	tracker := newPresenceTracker()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		var changed []string
+		previous := make(map[string]string)
+		for k, v := range tracker.location {
+			previous[k] = v
+		}
+		select {
+		case item := <-ble_channel:
+			id, distance, ok := parseBLEReport(item.Topic, item.Data)
+			if !ok {
+				Logger.Debug().Msgf("%s unrecognized ble payload: %s", item.Room, string(item.Data))
+				continue
+			}
+			person := CurrentModel().FindPersonByBeacon(id)
+			if person == "" {
+				Logger.Trace().Msgf("ble beacon %s not mapped to a person", id)
+				continue
+			}
+			changed = tracker.observe(person, item.Room, distance, time.Now().Unix())
+		case <-ticker.C:
+			changed = tracker.expire(time.Now().Unix())
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		presenceMu.Lock()
+		presenceSnapshot = tracker.snapshot()
+		presenceMu.Unlock()
+
+		for _, person := range changed {
+			from, to := previous[person], tracker.location[person]
+			Logger.Debug().Msgf("%s moved from %q to %q", person, from, to)
+			payload := to
+			if payload == "" {
+				payload = "not_home"
+			}
+			PublishAsync(CurrentModel().PersonRoomTopic(person), byte(0), true, []byte(payload))
+			if from != "" && len(tracker.occupants(from)) == 0 {
+				results_channel <- MQTT_Item{Room: from, Type: BLE, Analysis_result: BLE_ABSENT}
+			}
+			if to != "" {
+				results_channel <- MQTT_Item{Room: to, Type: BLE, Analysis_result: BLE_PRESENT}
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestParseBLEReport(t *testing.T) {
+	id, dist, ok := parseBLEReport("espresense/devices/irk:abc/office", []byte(`{"id":"irk:abc","distance":1.5}`))
+	if !ok || id != "irk:abc" || dist != 1.5 {
+		t.Errorf("parseBLEReport = %s, %v, %v", id, dist, ok)
+	}
+
+	// The beacon id falls back to the topic segment.
+	id, _, ok = parseBLEReport("espresense/devices/phone:1/office", []byte(`{"distance":2}`))
+	if !ok || id != "phone:1" {
+		t.Errorf("parseBLEReport fallback id = %s, %v", id, ok)
+	}
+
+	if _, _, ok = parseBLEReport("espresense/devices/x/office", []byte("not json")); ok {
+		t.Error("parseBLEReport should reject non-JSON payloads")
+	}
+}
+
+func TestPresenceTracker(t *testing.T) {
+	Config.Set("ble_presence_timeout", 30)
+	tracker := newPresenceTracker()
+
+	if changed := tracker.observe("alice", "office", 3.0, 100); len(changed) != 1 {
+		t.Fatalf("expected alice to be placed, got %v", changed)
+	}
+	if got := tracker.occupants("office"); len(got) != 1 || got[0] != "alice" {
+		t.Errorf("office occupants = %v, expected [alice]", got)
+	}
+
+	// A farther node does not move her; a nearer one does.
+	if changed := tracker.observe("alice", "kitchen", 4.0, 101); len(changed) != 0 {
+		t.Errorf("farther reading should not move alice, got %v", changed)
+	}
+	if changed := tracker.observe("alice", "kitchen", 1.0, 102); len(changed) != 1 {
+		t.Errorf("nearer reading should move alice, got %v", changed)
+	}
+	if tracker.location["alice"] != "kitchen" {
+		t.Errorf("alice in %s, expected kitchen", tracker.location["alice"])
+	}
+
+	// Stale readings expire and leave her away.
+	if changed := tracker.expire(200); len(changed) != 1 || tracker.location["alice"] != "" {
+		t.Errorf("expected alice to expire, changed=%v location=%q", changed, tracker.location["alice"])
+	}
+}
+
+func TestPresenceManagerRoutine(t *testing.T) {
+	Config.Set("ble_presence_timeout", 30)
+	SetModel(&Model{
+		Rooms:  []Room{{Name: "office", Ble_topics: []string{"espresense/devices/+/office"}}},
+		People: []Person{{Name: "alice", Beacon_ids: []string{"irk:abc"}}},
+	})
+	ble_channel = make(chan MQTT_Item, 10)
+	results_channel = make(chan MQTT_Item, 10)
+
+	go PresenceManagerRoutine()
+
+	ble_channel <- MQTT_Item{
+		Room:  "office",
+		Topic: "espresense/devices/irk:abc/office",
+		Data:  []byte(`{"id":"irk:abc","distance":1.2}`),
+		Type:  BLE,
+	}
+
+	select {
+	case result := <-results_channel:
+		if result.Analysis_result != BLE_PRESENT || result.Room != "office" {
+			t.Errorf("expected BLE_PRESENT for office, got %d for %s", result.Analysis_result, result.Room)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for presence result")
+	}
+
+	if people := PeopleInRoom("office"); len(people) != 1 || people[0] != "alice" {
+		t.Errorf("PeopleInRoom(office) = %v, expected [alice]", people)
+	}
+
+	// Unmapped beacons are ignored.
+	ble_channel <- MQTT_Item{Room: "office", Topic: "espresense/devices/other/office", Data: []byte(`{"distance":1}`), Type: BLE}
+	select {
+	case result := <-results_channel:
+		t.Errorf("expected no result for unmapped beacon, got %d", result.Analysis_result)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
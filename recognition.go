@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Camera recognition: person detections that the gallery matched to an
+// enrolled person (see util/gallery.go). A recognition places the person in
+// the camera's room for `recognition_timeout` seconds. The activity feed says
+// "Alice in office" when someone is recognised in a room they were not
+// already recognised in.
+
+const defaultRecognitionTimeout = 120
+
+type recognition struct {
+	room string
+	seen int64
+}
+
+var (
+	recognitionMu sync.RWMutex
+	recognitions  = make(map[string]recognition) // person -> latest sighting
+)
+
+func recognitionTimeout() int64 {
+	if t := Config.GetInt64("recognition_timeout"); t > 0 {
+		return t
+	}
+	return defaultRecognitionTimeout
+}
+
+// recordRecognitions notes the people recognised among a frame's detections.
+func recordRecognitions(room string, dets []TritonDetection) {
+	now := time.Now().Unix()
+	for _, d := range dets {
+		if d.Secondary == nil || d.Secondary.Person == "" {
+			continue
+		}
+		person := d.Secondary.Person
+		recognitionMu.Lock()
+		prev, seen := recognitions[person]
+		recognitions[person] = recognition{room: room, seen: now}
+		recognitionMu.Unlock()
+		if seen && prev.room == room && prev.seen >= now-recognitionTimeout() {
+			continue
+		}
+		Logger.Info().Msgf("recognised %s in %s (%.2f)", person, room, d.Secondary.Similarity)
+		AddActivity("recognition", room, fmt.Sprintf("%s in %s", person, room))
+	}
+}
+
+// RecognizedInRoom returns the people a camera recognised in room within
+// recognition_timeout.
+func RecognizedInRoom(room string) []string {
+	cutoff := time.Now().Unix() - recognitionTimeout()
+	recognitionMu.RLock()
+	defer recognitionMu.RUnlock()
+	var out []string
+	for person, r := range recognitions {
+		if r.room == room && r.seen >= cutoff {
+			out = append(out, person)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
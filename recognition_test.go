@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestRecordRecognitions(t *testing.T) {
+	defer func() {
+		recognitionMu.Lock()
+		recognitions = make(map[string]recognition)
+		recognitionMu.Unlock()
+	}()
+	before := len(RecentActivity())
+
+	dets := []TritonDetection{
+		{Label: "person", Secondary: &SecondaryResult{Person: "alice", Similarity: 0.9}},
+		{Label: "person", Secondary: &SecondaryResult{}},
+		{Label: "person"},
+	}
+	recordRecognitions("office", dets)
+	recordRecognitions("office", dets)
+	if got := RecognizedInRoom("office"); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("RecognizedInRoom = %v", got)
+	}
+	if !reflect.DeepEqual(PeopleInRoom("office"), []string{"alice"}) {
+		t.Errorf("PeopleInRoom = %v", PeopleInRoom("office"))
+	}
+	activity := RecentActivity()
+	if len(activity)-before != 1 || activity[0].Message != "alice in office" {
+		t.Errorf("expected one announcement, got %+v", activity[:len(activity)-before])
+	}
+
+	// Moving rooms is announced again.
+	recordRecognitions("kitchen", dets[:1])
+	if len(RecognizedInRoom("office")) != 0 || len(RecentActivity())-before != 2 {
+		t.Error("move to kitchen not recorded")
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Rule engine: declarative rules from the `rules` config key. Each rule has a
+// trigger (room or zone occupancy transition, detection label, door opening,
+// or schedule), optional conditions (house mode, time window, state of other
+// rooms) and a list of actions (publish an MQTT payload, call a webhook, or
+// override a room's lighting automation). Rules reload with the config, and
+// with `rules_dry_run` (or a rule's own dry_run) they are evaluated and logged
+// to the activity feed without acting.
+
+const (
+	ruleTriggerOccupancy = "occupancy"
+	ruleTriggerZone      = "zone"
+	ruleTriggerDetection = "detection"
+	ruleTriggerDoor      = "door"
+	ruleTriggerSchedule  = "schedule"
+	ruleTriggerLying     = "lying"
+	ruleTriggerLine      = "line"
+
+	ruleActionPublish  = "publish"
+	ruleActionWebhook  = "webhook"
+	ruleActionOverride = "override"
+
+	ruleScheduleInterval = 15 * time.Second
+	ruleWebhookTimeout   = 10 * time.Second
+)
+
+type Rule struct {
+	Name       string         `mapstructure:"name"`
+	Trigger    RuleTrigger    `mapstructure:"trigger"`
+	Conditions RuleConditions `mapstructure:"conditions"`
+	Actions    []RuleAction   `mapstructure:"actions"`
+	Cooldown   int64          `mapstructure:"cooldown"`
+	Dry_run    bool           `mapstructure:"dry_run"`
+}
+
+// RuleTrigger selects the events a rule reacts to. Room/Zone/Label/
+// Image_zone/Line narrow the match when set; To is "occupied" or "vacant" for
+// occupancy and zone triggers, "lying" or "clear" for lying triggers and "in"
+// or "out" for line triggers. Detection triggers need Min_confidence,
+// defaulting to min_confidence, and Skip_known ignores detections recognised
+// as an enrolled person. Schedules fire daily At "HH:MM" or Every n seconds.
+type RuleTrigger struct {
+	Type           string  `mapstructure:"type"`
+	Room           string  `mapstructure:"room"`
+	Zone           string  `mapstructure:"zone"`
+	To             string  `mapstructure:"to"`
+	Label          string  `mapstructure:"label"`
+	At             string  `mapstructure:"at"`
+	Min_confidence float64 `mapstructure:"min_confidence"`
+	Every          int64   `mapstructure:"every"`
+	Skip_known     bool    `mapstructure:"skip_known"`
+	Image_zone     string  `mapstructure:"image_zone"`
+	Line           string  `mapstructure:"line"`
+}
+
+// RuleConditions must all hold for a triggered rule to act. After/Before are
+// "HH:MM" local times (the window may wrap midnight) and Rooms maps room names
+// to "occupied" or "vacant".
+type RuleConditions struct {
+	Modes  []string          `mapstructure:"modes"`
+	After  string            `mapstructure:"after"`
+	Before string            `mapstructure:"before"`
+	Rooms  map[string]string `mapstructure:"rooms"`
+}
+
+type RuleAction struct {
+	Type     string `mapstructure:"type"`
+	Topic    string `mapstructure:"topic"`
+	Payload  string `mapstructure:"payload"`
+	Url      string `mapstructure:"url"`
+	Method   string `mapstructure:"method"`
+	Body     string `mapstructure:"body"`
+	Room     string `mapstructure:"room"`
+	Duration int64  `mapstructure:"duration"`
+	Retain   bool   `mapstructure:"retain"`
+}
+
+// RuleEvent is something that happened which rules may trigger on.
+type RuleEvent struct {
+	Time       time.Time
+	Kind       string
+	Room       string
+	Zone       string
+	To         string
+	Label      string
+	Confidence float64
+	Person     string   // the enrolled person a detection was recognised as
+	ImageZones []string // the room's image_zones a detection is in
+	Line       string   // the count line crossed
+}
+
+func (ev RuleEvent) String() string {
+	switch ev.Kind {
+	case ruleTriggerOccupancy:
+		return fmt.Sprintf("%s %s", ev.Room, ev.To)
+	case ruleTriggerZone:
+		return fmt.Sprintf("zone %s %s", ev.Zone, ev.To)
+	case ruleTriggerDetection:
+		if ev.Person != "" {
+			return fmt.Sprintf("%s (%s) seen in %s (%.2f)", ev.Label, ev.Person, ev.Room, ev.Confidence)
+		}
+		return fmt.Sprintf("%s seen in %s (%.2f)", ev.Label, ev.Room, ev.Confidence)
+	case ruleTriggerDoor:
+		return fmt.Sprintf("door opened in %s", ev.Room)
+	case ruleTriggerLying:
+		if ev.To == "clear" {
+			return fmt.Sprintf("nobody lying down in %s", ev.Room)
+		}
+		return fmt.Sprintf("person lying down in %s", ev.Room)
+	case ruleTriggerLine:
+		return fmt.Sprintf("%s went %s of %s through %s", ev.Label, ev.To, ev.Room, ev.Line)
+	default:
+		return ev.Kind
+	}
+}
+
+type ruleEngine struct {
+	mu        sync.Mutex
+	rules     []Rule
+	lastFired map[string]time.Time
+	zones     map[string]bool
+	stop      chan struct{}
+}
+
+var ruleSet = newRuleEngine()
+
+func newRuleEngine() *ruleEngine {
+	return &ruleEngine{
+		lastFired: make(map[string]time.Time),
+		zones:     make(map[string]bool),
+	}
+}
+
+// validateRule checks a rule's trigger and action types.
+func validateRule(r Rule) error {
+	switch r.Trigger.Type {
+	case ruleTriggerOccupancy, ruleTriggerZone, ruleTriggerDetection, ruleTriggerDoor, ruleTriggerLying, ruleTriggerLine:
+	case ruleTriggerSchedule:
+		if r.Trigger.At == "" && r.Trigger.Every <= 0 {
+			return fmt.Errorf("schedule trigger needs at or every")
+		}
+		if r.Trigger.At != "" {
+			if _, err := parseClock(r.Trigger.At); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown trigger type %q", r.Trigger.Type)
+	}
+	for _, a := range r.Actions {
+		switch a.Type {
+		case ruleActionPublish:
+			if a.Topic == "" {
+				return fmt.Errorf("publish action needs a topic")
+			}
+		case ruleActionWebhook:
+			if a.Url == "" {
+				return fmt.Errorf("webhook action needs a url")
+			}
+		case ruleActionOverride:
+		default:
+			return fmt.Errorf("unknown action type %q", a.Type)
+		}
+	}
+	return nil
+}
+
+// Load (re)reads the rules from config and restarts the scheduler. Invalid
+// rules are logged and skipped.
+func (e *ruleEngine) Load() {
+	var loaded []Rule
+	if err := Config.UnmarshalKey("rules", &loaded); err != nil {
+		Logger.Error().Msgf("Error loading rules: %v", err)
+		return
+	}
+	var valid []Rule
+	for i, r := range loaded {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule_%d", i)
+		}
+		if err := validateRule(r); err != nil {
+			Logger.Error().Msgf("rule %s ignored: %v", r.Name, err)
+			continue
+		}
+		valid = append(valid, r)
+	}
+	e.mu.Lock()
+	e.rules = valid
+	if e.stop != nil {
+		close(e.stop)
+	}
+	e.stop = make(chan struct{})
+	stop := e.stop
+	e.mu.Unlock()
+	go e.scheduler(stop)
+	Logger.Info().Msgf("loaded %d rule(s)", len(valid))
+}
+
+func (e *ruleEngine) scheduler(stop chan struct{}) {
+	ticker := time.NewTicker(ruleScheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			e.Fire(RuleEvent{Kind: ruleTriggerSchedule, Time: now})
+		}
+	}
+}
+
+// OnOccupancy fires occupancy rules for a room transition, plus zone rules for
+// any zone whose aggregate state changed as a result.
+func (e *ruleEngine) OnOccupancy(room string, occupied bool) {
+	e.Fire(RuleEvent{Kind: ruleTriggerOccupancy, Room: room, To: occupancyWord(occupied)})
+	for _, zone := range CurrentModel().ZonesForRoom(room) {
+		zoneOccupied := false
+		for _, z := range CurrentModel().Zones {
+			if z.Name != zone {
+				continue
+			}
+			for _, r := range z.Rooms {
+				if v, _ := GetOccupancyState(r); v {
+					zoneOccupied = true
+				}
+			}
+		}
+		e.mu.Lock()
+		prev, known := e.zones[zone]
+		e.zones[zone] = zoneOccupied
+		e.mu.Unlock()
+		if !known || prev != zoneOccupied {
+			e.Fire(RuleEvent{Kind: ruleTriggerZone, Zone: zone, To: occupancyWord(zoneOccupied)})
+		}
+	}
+}
+
+func occupancyWord(occupied bool) string {
+	if occupied {
+		return "occupied"
+	}
+	return "vacant"
+}
+
+// Fire evaluates every rule against the event and runs the actions of those
+// that match.
+func (e *ruleEngine) Fire(ev RuleEvent) {
+	e.FireFirst([]RuleEvent{ev})
+}
+
+// FireFirst evaluates every rule against the events, such as the detections
+// of one frame, and runs each matching rule once, for the first event it
+// matches.
+func (e *ruleEngine) FireFirst(evs []RuleEvent) {
+	now := time.Now()
+	type firing struct {
+		rule Rule
+		ev   RuleEvent
+	}
+	e.mu.Lock()
+	var matched []firing
+	for _, r := range e.rules {
+		for _, ev := range evs {
+			if ev.Time.IsZero() {
+				ev.Time = now
+			}
+			if !ruleMatches(r.Trigger, ev, e.lastFired[r.Name]) || !conditionsHold(r.Conditions, ev.Time) {
+				continue
+			}
+			if r.Cooldown > 0 && ev.Time.Sub(e.lastFired[r.Name]) < time.Duration(r.Cooldown)*time.Second {
+				break
+			}
+			e.lastFired[r.Name] = ev.Time
+			matched = append(matched, firing{r, ev})
+			break
+		}
+	}
+	e.mu.Unlock()
+
+	for _, f := range matched {
+		r, ev := f.rule, f.ev
+		dryRun := r.Dry_run || Config.GetBool("rules_dry_run")
+		msg := fmt.Sprintf("rule %s fired: %s", r.Name, ev)
+		if dryRun {
+			msg = fmt.Sprintf("rule %s would fire (dry run): %s", r.Name, ev)
+		}
+		Logger.Info().Msg(msg)
+		AddActivity("rule", ev.Room, msg)
+		if dryRun {
+			continue
+		}
+		for _, a := range r.Actions {
+			runRuleAction(r.Name, a)
+		}
+	}
+}
+
+func ruleMatches(t RuleTrigger, ev RuleEvent, lastFired time.Time) bool {
+	if t.Type != ev.Kind {
+		return false
+	}
+	switch ev.Kind {
+	case ruleTriggerOccupancy:
+		return (t.Room == "" || t.Room == ev.Room) && (t.To == "" || t.To == ev.To)
+	case ruleTriggerZone:
+		return (t.Zone == "" || t.Zone == ev.Zone) && (t.To == "" || t.To == ev.To)
+	case ruleTriggerDetection:
+		return (t.Room == "" || t.Room == ev.Room) &&
+			(t.Label == "" || t.Label == ev.Label) &&
+			ev.Confidence >= ruleMinConfidence(t) &&
+			(!t.Skip_known || ev.Person == "") &&
+			(t.Image_zone == "" || slices.Contains(ev.ImageZones, t.Image_zone))
+	case ruleTriggerDoor:
+		return t.Room == "" || t.Room == ev.Room
+	case ruleTriggerLying:
+		return (t.Room == "" || t.Room == ev.Room) && (t.To == "" || t.To == ev.To)
+	case ruleTriggerLine:
+		return (t.Room == "" || t.Room == ev.Room) && (t.Line == "" || t.Line == ev.Line) &&
+			(t.Label == "" || t.Label == ev.Label) && (t.To == "" || t.To == ev.To)
+	case ruleTriggerSchedule:
+		if t.Every > 0 {
+			return ev.Time.Sub(lastFired) >= time.Duration(t.Every)*time.Second
+		}
+		at, err := parseClock(t.At)
+		if err != nil {
+			return false
+		}
+		// Fire once per day: within one scheduler tick of the target time.
+		mins := ev.Time.Hour()*60 + ev.Time.Minute()
+		return mins == at && ev.Time.Sub(lastFired) > time.Minute
+	}
+	return false
+}
+
+// ruleMinConfidence is the confidence a detection trigger needs: its own
+// min_confidence, or the global one that occupancy uses.
+func ruleMinConfidence(t RuleTrigger) float64 {
+	if t.Min_confidence > 0 {
+		return t.Min_confidence
+	}
+	return float64(minPersonConfidence())
+}
+
+func conditionsHold(c RuleConditions, now time.Time) bool {
+	if len(c.Modes) > 0 {
+		mode := GetHouseMode()
+		found := false
+		for _, m := range c.Modes {
+			if strings.EqualFold(m, mode) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.After != "" || c.Before != "" {
+		if !inTimeWindow(c.After, c.Before, now) {
+			return false
+		}
+	}
+	for room, want := range c.Rooms {
+		occupied, _ := GetOccupancyState(room)
+		if occupancyWord(occupied) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inTimeWindow reports whether now is within [after, before). Either bound may
+// be empty, and a window with after > before wraps midnight.
+func inTimeWindow(after, before string, now time.Time) bool {
+	mins := now.Hour()*60 + now.Minute()
+	start, end := 0, 24*60
+	if after != "" {
+		v, err := parseClock(after)
+		if err != nil {
+			return false
+		}
+		start = v
+	}
+	if before != "" {
+		v, err := parseClock(before)
+		if err != nil {
+			return false
+		}
+		end = v
+	}
+	if start <= end {
+		return mins >= start && mins < end
+	}
+	return mins >= start || mins < end
+}
+
+func runRuleAction(rule string, a RuleAction) {
+	switch a.Type {
+	case ruleActionPublish:
+		PublishAsync(a.Topic, byte(0), a.Retain, []byte(a.Payload))
+	case ruleActionWebhook:
+		go callWebhook(rule, a)
+	case ruleActionOverride:
+		lighting.SetOverride(a.Room, a.Duration)
+	}
+}
+
+func callWebhook(rule string, a RuleAction) {
+	method := a.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ruleWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, a.Url, strings.NewReader(a.Body))
+	if err != nil {
+		Logger.Warn().Msgf("rule %s webhook request error: %v", rule, err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		Logger.Warn().Msgf("rule %s webhook error: %v", rule, err)
+		return
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			Logger.Error().Msgf("Error closing webhook response body: %v", closeErr)
+		}
+	}()
+	if resp.StatusCode > 299 || resp.StatusCode < 200 {
+		Logger.Warn().Msgf("rule %s webhook returned %d", rule, resp.StatusCode)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"Occupancy publish", Rule{Trigger: RuleTrigger{Type: "occupancy"}, Actions: []RuleAction{{Type: "publish", Topic: "a"}}}, false},
+		{"Unknown trigger", Rule{Trigger: RuleTrigger{Type: "telepathy"}}, true},
+		{"Schedule without time", Rule{Trigger: RuleTrigger{Type: "schedule"}}, true},
+		{"Schedule bad time", Rule{Trigger: RuleTrigger{Type: "schedule", At: "25:99"}}, true},
+		{"Publish without topic", Rule{Trigger: RuleTrigger{Type: "door"}, Actions: []RuleAction{{Type: "publish"}}}, true},
+		{"Unknown action", Rule{Trigger: RuleTrigger{Type: "door"}, Actions: []RuleAction{{Type: "explode"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRule(tt.rule); (err != nil) != tt.wantErr {
+				t.Errorf("validateRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInTimeWindow(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 1, h, m, 0, 0, time.Local) }
+	tests := []struct {
+		name          string
+		after, before string
+		now           time.Time
+		expected      bool
+	}{
+		{"Inside day window", "08:00", "17:00", at(12, 0), true},
+		{"Outside day window", "08:00", "17:00", at(18, 0), false},
+		{"Overnight late", "22:00", "06:00", at(23, 30), true},
+		{"Overnight early", "22:00", "06:00", at(5, 59), true},
+		{"Overnight midday", "22:00", "06:00", at(12, 0), false},
+		{"Only after", "20:00", "", at(21, 0), true},
+		{"Only before", "", "07:00", at(8, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := inTimeWindow(tt.after, tt.before, tt.now); result != tt.expected {
+				t.Errorf("inTimeWindow(%s, %s, %v) = %v, expected %v", tt.after, tt.before, tt.now, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRuleMatchesAndConditions(t *testing.T) {
+	ev := RuleEvent{Kind: ruleTriggerDetection, Room: "garage", Label: "person", Confidence: 0.8, Time: time.Now()}
+	if !ruleMatches(RuleTrigger{Type: "detection", Room: "garage", Label: "person", Min_confidence: 0.7}, ev, time.Time{}) {
+		t.Error("expected detection trigger to match")
+	}
+	if ruleMatches(RuleTrigger{Type: "detection", Label: "car"}, ev, time.Time{}) {
+		t.Error("label mismatch should not match")
+	}
+	if ruleMatches(RuleTrigger{Type: "detection", Min_confidence: 0.9}, ev, time.Time{}) {
+		t.Error("low confidence should not match")
+	}
+	faint := ev
+	faint.Confidence = 0.3
+	if ruleMatches(RuleTrigger{Type: "detection", Label: "person"}, faint, time.Time{}) {
+		t.Error("detection below min_confidence should not match by default")
+	}
+	if !ruleMatches(RuleTrigger{Type: "detection", Label: "person", Min_confidence: 0.2}, faint, time.Time{}) {
+		t.Error("a rule's own min_confidence should override the default")
+	}
+	known := ev
+	known.Person = "alice"
+	if !ruleMatches(RuleTrigger{Type: "detection", Skip_known: true}, ev, time.Time{}) ||
+		ruleMatches(RuleTrigger{Type: "detection", Skip_known: true}, known, time.Time{}) {
+		t.Error("skip_known should only skip recognised people")
+	}
+	onBed := ev
+	onBed.ImageZones = []string{"bed"}
+	if !ruleMatches(RuleTrigger{Type: "detection", Image_zone: "bed"}, onBed, time.Time{}) ||
+		ruleMatches(RuleTrigger{Type: "detection", Image_zone: "bed"}, ev, time.Time{}) {
+		t.Error("image_zone should only match detections in that zone")
+	}
+	crossed := RuleEvent{Kind: ruleTriggerLine, Room: "kitchen", Label: "person", Line: "doorway", To: "in"}
+	if !ruleMatches(RuleTrigger{Type: "line", Line: "doorway", To: "in"}, crossed, time.Time{}) ||
+		ruleMatches(RuleTrigger{Type: "line", To: "out"}, crossed, time.Time{}) {
+		t.Error("line trigger should match the line and direction")
+	}
+
+	SetHouseMode("away")
+	defer SetHouseMode("")
+	if !conditionsHold(RuleConditions{Modes: []string{"away"}}, time.Now()) {
+		t.Error("mode condition should hold")
+	}
+	if conditionsHold(RuleConditions{Modes: []string{"home"}}, time.Now()) {
+		t.Error("mode condition should not hold")
+	}
+
+	SetOccupancyState("kitchen", true)
+	if !conditionsHold(RuleConditions{Rooms: map[string]string{"kitchen": "occupied"}}, time.Now()) {
+		t.Error("room condition should hold")
+	}
+	if conditionsHold(RuleConditions{Rooms: map[string]string{"kitchen": "vacant"}}, time.Now()) {
+		t.Error("room condition should not hold")
+	}
+}
+
+func TestRuleEngineFire(t *testing.T) {
+	hits := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck // test server
+		hits <- r.Method + " " + string(body)
+	}))
+	defer srv.Close()
+
+	SetModel(&Model{
+		Rooms: []Room{{Name: "kitchen"}, {Name: "family_room"}},
+		Zones: []Zone{{Name: "downstairs", Rooms: []string{"kitchen", "family_room"}}},
+	})
+	Config.Set("rules_dry_run", false)
+	Config.Set("rules", []map[string]interface{}{
+		{
+			"name":    "notify",
+			"trigger": map[string]interface{}{"type": "zone", "zone": "downstairs", "to": "occupied"},
+			"actions": []map[string]interface{}{{"type": "webhook", "url": srv.URL, "body": "hello"}},
+		},
+		{
+			"name":    "movie",
+			"trigger": map[string]interface{}{"type": "occupancy", "room": "family_room", "to": "occupied"},
+			"actions": []map[string]interface{}{{"type": "override", "room": "family_room", "duration": 600}},
+		},
+		{
+			"name":    "dry",
+			"dry_run": true,
+			"trigger": map[string]interface{}{"type": "occupancy", "room": "kitchen"},
+			"actions": []map[string]interface{}{{"type": "override", "room": "kitchen", "duration": 600}},
+		},
+		{"name": "broken", "trigger": map[string]interface{}{"type": "nope"}},
+	})
+	defer Config.Set("rules", nil)
+
+	engine := newRuleEngine()
+	engine.Load()
+	if len(engine.rules) != 3 {
+		t.Fatalf("loaded %d rules, expected 3 (invalid rule skipped)", len(engine.rules))
+	}
+
+	SetOccupancyState("kitchen", false)
+	SetOccupancyState("family_room", true)
+	engine.OnOccupancy("family_room", true)
+
+	select {
+	case hit := <-hits:
+		if hit != "POST hello" {
+			t.Errorf("webhook received %q, expected POST hello", hit)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for zone webhook")
+	}
+	if lighting.OverrideUntil("family_room") == 0 {
+		t.Error("override action should suspend family_room lighting")
+	}
+
+	// The zone is still occupied: no second zone event.
+	SetOccupancyState("kitchen", true)
+	engine.OnOccupancy("kitchen", true)
+	select {
+	case hit := <-hits:
+		t.Errorf("unexpected webhook %q", hit)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Dry-run rules are logged but do not act.
+	if lighting.OverrideUntil("kitchen") != 0 {
+		t.Error("dry-run rule should not act")
+	}
+	found := false
+	for _, a := range RecentActivity() {
+		if a.Type == "rule" && strings.Contains(a.Message, "dry run") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("dry-run firing should be logged to the activity feed")
+	}
+}
+
+func TestRuleEngineFireFirst(t *testing.T) {
+	Config.Set("rules", []map[string]interface{}{
+		{"name": "person_seen", "dry_run": true, "trigger": map[string]interface{}{"type": "detection", "label": "person"}},
+	})
+	defer Config.Set("rules", nil)
+	engine := newRuleEngine()
+	engine.Load()
+
+	fired := func() int {
+		n := 0
+		for _, a := range RecentActivity() {
+			if a.Type == "rule" && strings.Contains(a.Message, "rule person_seen") {
+				n++
+			}
+		}
+		return n
+	}
+	before := fired()
+	// Three people in one frame fire the rule once.
+	person := RuleEvent{Kind: ruleTriggerDetection, Room: "hall", Label: "person", Confidence: 0.9}
+	engine.FireFirst([]RuleEvent{{Kind: ruleTriggerDetection, Room: "hall", Label: "cat", Confidence: 0.9}, person, person, person})
+	if n := fired() - before; n != 1 {
+		t.Errorf("rule fired %d times for one frame, expected once", n)
+	}
+	engine.FireFirst([]RuleEvent{person})
+	if n := fired() - before; n != 2 {
+		t.Errorf("rule fired %d times for two frames, expected twice", n)
+	}
+}
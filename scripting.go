@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Room scripts: a room may name a Starlark file in `script`. The file must
+// define
+//
+//	def decide(item, status, detections): ...
+//
+// which is called for every event the occupancy manager handles for the room.
+// item describes the event (topic, type, result, data), status the room state
+// and the decision computed so far, and detections the latest camera results.
+// It returns None to keep the decision, a bool to replace it, or a dict with an
+// optional "occupied" bool and a "publish" list of {topic, payload, retain}.
+//
+// Scripts run without any host access, bounded by script_max_steps and
+// script_timeout_ms; a failing script is logged and ignored, and one that keeps
+// failing is disabled until the next config reload.
+
+const (
+	defaultScriptTimeout  = 100 * time.Millisecond
+	defaultScriptMaxSteps = 100000
+	scriptMaxFailures     = 5
+)
+
+type roomScript struct {
+	path     string
+	decide   starlark.Callable
+	failures int
+}
+
+type scriptManager struct {
+	mu      sync.Mutex
+	scripts map[string]*roomScript
+}
+
+var roomScripts = &scriptManager{scripts: make(map[string]*roomScript)}
+
+// Load compiles the scripts configured on the current model's rooms.
+func (s *scriptManager) Load() {
+	scripts := make(map[string]*roomScript)
+	for _, room := range CurrentModel().Rooms {
+		if room.Script == "" {
+			continue
+		}
+		decide, err := loadRoomScript(room.Script)
+		if err != nil {
+			Logger.Error().Msgf("%s script %s not loaded: %v", room.Name, room.Script, err)
+			continue
+		}
+		scripts[room.Name] = &roomScript{path: room.Script, decide: decide}
+		Logger.Info().Msgf("%s script %s loaded", room.Name, room.Script)
+	}
+	s.mu.Lock()
+	s.scripts = scripts
+	s.mu.Unlock()
+}
+
+func loadRoomScript(path string) (starlark.Callable, error) {
+	src, err := os.ReadFile(path) //nolint:gosec // path comes from the operator's config
+	if err != nil {
+		return nil, err
+	}
+	thread := newScriptThread(path)
+	v, err := execWithLimits(thread, func() (starlark.Value, error) {
+		g, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, path, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		g.Freeze()
+		if fn, ok := g["decide"].(starlark.Callable); ok {
+			return fn, nil
+		}
+		return nil, fmt.Errorf("script does not define decide()")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(starlark.Callable), nil
+}
+
+func newScriptThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			Logger.Debug().Msgf("script %s: %s", name, msg)
+		},
+	}
+	steps := Config.GetUint64("script_max_steps")
+	if steps == 0 {
+		steps = defaultScriptMaxSteps
+	}
+	thread.SetMaxExecutionSteps(steps)
+	return thread
+}
+
+// execWithLimits runs fn under the script timeout, converting panics into
+// errors so a misbehaving script cannot take down the caller.
+func execWithLimits(thread *starlark.Thread, fn func() (starlark.Value, error)) (v starlark.Value, err error) {
+	timeout := time.Duration(Config.GetInt64("script_timeout_ms")) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	timer := time.AfterFunc(timeout, func() { thread.Cancel("timeout") })
+	defer timer.Stop()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("script panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// scriptDecision is the outcome of a room script call.
+type scriptDecision struct {
+	Occupied *bool
+	Publish  []scriptPublish
+}
+
+type scriptPublish struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// Decide runs the room's script, if any, and returns the occupancy decision it
+// made. ok is false when there is no script, it failed, or it returned None.
+// Extra publishes requested by the script are sent before returning.
+func (s *scriptManager) Decide(item MQTT_Item, status RoomStatus, occupied bool) (decision bool, ok bool) {
+	s.mu.Lock()
+	rs, found := s.scripts[item.Room]
+	s.mu.Unlock()
+	if !found || rs.decide == nil {
+		return false, false
+	}
+
+	result, err := runRoomScript(rs, item, status, occupied)
+	s.mu.Lock()
+	if err != nil {
+		rs.failures++
+		if rs.failures >= scriptMaxFailures {
+			Logger.Error().Msgf("%s script %s failed %d times; disabled until config reload", item.Room, rs.path, rs.failures)
+			rs.decide = nil
+		}
+	} else {
+		rs.failures = 0
+	}
+	s.mu.Unlock()
+	if err != nil {
+		Logger.Warn().Msgf("%s script %s error: %v", item.Room, rs.path, err)
+		return false, false
+	}
+
+	for _, p := range result.Publish {
+		PublishAsync(p.topic, byte(0), p.retain, []byte(p.payload))
+	}
+	if result.Occupied == nil {
+		return false, false
+	}
+	return *result.Occupied, true
+}
+
+func runRoomScript(rs *roomScript, item MQTT_Item, status RoomStatus, occupied bool) (scriptDecision, error) {
+	thread := newScriptThread(rs.path)
+	args := starlark.Tuple{
+		scriptItem(item),
+		scriptStatus(item.Room, status, occupied),
+		scriptDetections(item.Room),
+	}
+	v, err := execWithLimits(thread, func() (starlark.Value, error) {
+		return starlark.Call(thread, rs.decide, args, nil)
+	})
+	if err != nil {
+		return scriptDecision{}, err
+	}
+	return parseScriptResult(v)
+}
+
+func parseScriptResult(v starlark.Value) (scriptDecision, error) {
+	var d scriptDecision
+	switch r := v.(type) {
+	case starlark.NoneType:
+		return d, nil
+	case starlark.Bool:
+		b := bool(r)
+		d.Occupied = &b
+		return d, nil
+	case *starlark.Dict:
+		if occ, found, _ := r.Get(starlark.String("occupied")); found && occ != starlark.None {
+			b, ok := occ.(starlark.Bool)
+			if !ok {
+				return d, fmt.Errorf("occupied must be a bool, got %s", occ.Type())
+			}
+			val := bool(b)
+			d.Occupied = &val
+		}
+		if pubs, found, _ := r.Get(starlark.String("publish")); found {
+			list, ok := pubs.(*starlark.List)
+			if !ok {
+				return d, fmt.Errorf("publish must be a list, got %s", pubs.Type())
+			}
+			for i := 0; i < list.Len(); i++ {
+				entry, ok := list.Index(i).(*starlark.Dict)
+				if !ok {
+					return d, fmt.Errorf("publish entries must be dicts")
+				}
+				var p scriptPublish
+				if t, found, _ := entry.Get(starlark.String("topic")); found {
+					p.topic, _ = starlark.AsString(t)
+				}
+				if pl, found, _ := entry.Get(starlark.String("payload")); found {
+					if str, ok := starlark.AsString(pl); ok {
+						p.payload = str
+					} else {
+						p.payload = pl.String()
+					}
+				}
+				if rt, found, _ := entry.Get(starlark.String("retain")); found {
+					p.retain = bool(rt.Truth())
+				}
+				if p.topic == "" {
+					return d, fmt.Errorf("publish entry without topic")
+				}
+				d.Publish = append(d.Publish, p)
+			}
+		}
+		return d, nil
+	default:
+		return d, fmt.Errorf("decide() must return None, a bool or a dict, got %s", v.Type())
+	}
+}
+
+var analysisResultNames = map[int]string{
+	OCCUPIED:     "occupied",
+	UNOCCUPIED:   "unoccupied",
+	MOTION_START: "motion_start",
+	MOTION_STOP:  "motion_stop",
+	DOOR_OPEN:    "door_open",
+	DOOR_CLOSED:  "door_closed",
+	BLE_PRESENT:  "ble_present",
+	BLE_ABSENT:   "ble_absent",
+	SENSOR_ON:    "sensor_on",
+	SENSOR_OFF:   "sensor_off",
+	CAM_UNKNOWN:  "cam_unknown",
+}
+
+var topicTypeNames = map[int]string{
+	PIC:       "pic",
+	MOTION:    "motion",
+	OCCUPANCY: "occupancy",
+	DOOR:      "door",
+	BLE:       "ble",
+	SENSOR:    "sensor",
+	DEVICE:    "device",
+	MODE:      "mode",
+}
+
+func scriptDict(entries map[string]starlark.Value) *starlark.Dict {
+	d := starlark.NewDict(len(entries))
+	for k, v := range entries {
+		_ = d.SetKey(starlark.String(k), v) //nolint:errcheck // a fresh dict with string keys cannot fail
+	}
+	return d
+}
+
+func scriptItem(item MQTT_Item) *starlark.Dict {
+	data := ""
+	if item.Type != PIC {
+		data = string(item.Data)
+	}
+	return scriptDict(map[string]starlark.Value{
+		"topic":  starlark.String(item.Topic),
+		"room":   starlark.String(item.Room),
+		"type":   starlark.String(topicTypeNames[item.Type]),
+		"result": starlark.String(analysisResultNames[item.Analysis_result]),
+		"data":   starlark.String(data),
+	})
+}
+
+func scriptStatus(room string, status RoomStatus, occupied bool) *starlark.Dict {
+	return scriptDict(map[string]starlark.Value{
+		"occupied":      starlark.Bool(occupied),
+		"motion":        starlark.Bool(status.GetMotionState()),
+		"presence":      starlark.Bool(status.GetPresenceState()),
+		"sensor":        starlark.Bool(status.GetSensorState()),
+		"last_occupied": starlark.MakeInt64(status.GetLastOccupied()),
+		"period":        starlark.MakeInt64(CurrentModel().RoomOccupancyPeriod(room)),
+		"mode":          starlark.String(GetHouseMode()),
+		"now":           starlark.MakeInt64(time.Now().Unix()),
+	})
+}
+
+func scriptDetections(room string) *starlark.List {
+	var out []starlark.Value
+	if cfg, ok := CurrentModel().FindRoom(room); ok {
+		for _, topic := range cfg.Pic_topics {
+			ci, ok := CacheGet(topic)
+			if !ok {
+				continue
+			}
+			for _, p := range ci.results.Predictions {
+				out = append(out, scriptDict(map[string]starlark.Value{
+					"topic":      starlark.String(topic),
+					"label":      starlark.String(p.Label),
+					"confidence": starlark.Float(p.Confidence),
+					"x_min":      starlark.MakeInt(p.X_min),
+					"y_min":      starlark.MakeInt(p.Y_min),
+					"x_max":      starlark.MakeInt(p.X_max),
+					"y_max":      starlark.MakeInt(p.Y_max),
+					"timestamp":  starlark.MakeInt64(ci.results.Timestamp),
+				}))
+			}
+		}
+	}
+	return starlark.NewList(out)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+	"go.starlark.net/starlark"
+)
+
+func writeScript(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "room.star")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadScripts(t *testing.T, scripts map[string]string) *scriptManager {
+	t.Helper()
+	var rooms []Room
+	for name, src := range scripts {
+		rooms = append(rooms, Room{Name: name, Script: writeScript(t, src)})
+	}
+	SetModel(&Model{Rooms: rooms})
+	s := &scriptManager{}
+	s.Load()
+	return s
+}
+
+func TestScriptDecide(t *testing.T) {
+	s := loadScripts(t, map[string]string{
+		"office": `
+def decide(item, status, detections):
+    if item["type"] == "motion" and item["result"] == "motion_stop":
+        return False
+    if status["occupied"]:
+        return None
+    return True
+`,
+		"broken":   "def decide(item, status, detections)\n",
+		"nodecide": "x = 1\n",
+	})
+	if len(s.scripts) != 1 {
+		t.Fatalf("loaded %d scripts, expected 1 (broken scripts skipped)", len(s.scripts))
+	}
+
+	var status RoomStatus
+	if d, ok := s.Decide(MQTT_Item{Room: "office", Type: MOTION, Analysis_result: MOTION_STOP}, status, true); !ok || d {
+		t.Errorf("motion_stop: got %v/%v, expected false/true", d, ok)
+	}
+	if _, ok := s.Decide(MQTT_Item{Room: "office", Type: MOTION, Analysis_result: MOTION_START}, status, true); ok {
+		t.Error("None result should not make a decision")
+	}
+	if d, ok := s.Decide(MQTT_Item{Room: "office", Type: PIC, Analysis_result: UNOCCUPIED}, status, false); !ok || !d {
+		t.Errorf("vacant: got %v/%v, expected true/true", d, ok)
+	}
+	if _, ok := s.Decide(MQTT_Item{Room: "kitchen"}, status, true); ok {
+		t.Error("room without a script should not make a decision")
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	Config.Set("script_max_steps", 1000)
+	defer Config.Set("script_max_steps", nil)
+	s := loadScripts(t, map[string]string{
+		"office": `
+def decide(item, status, detections):
+    n = 0
+    for i in range(1000000):
+        n += i
+    return True
+`,
+		"crash": `
+def decide(item, status, detections):
+    return 1 // 0
+`,
+	})
+
+	start := time.Now()
+	if _, ok := s.Decide(MQTT_Item{Room: "office"}, RoomStatus{}, true); ok {
+		t.Error("runaway script should not make a decision")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("runaway script was not stopped")
+	}
+
+	for i := 0; i < scriptMaxFailures; i++ {
+		if _, ok := s.Decide(MQTT_Item{Room: "crash"}, RoomStatus{}, true); ok {
+			t.Fatal("failing script should not make a decision")
+		}
+	}
+	if s.scripts["crash"].decide != nil {
+		t.Error("script should be disabled after repeated failures")
+	}
+}
+
+func TestParseScriptResult(t *testing.T) {
+	d := starlark.NewDict(2)
+	_ = d.SetKey(starlark.String("occupied"), starlark.True) //nolint:errcheck // test
+	pub := starlark.NewDict(3)
+	_ = pub.SetKey(starlark.String("topic"), starlark.String("a/b"))                  //nolint:errcheck // test
+	_ = pub.SetKey(starlark.String("payload"), starlark.MakeInt(3))                   //nolint:errcheck // test
+	_ = pub.SetKey(starlark.String("retain"), starlark.True)                          //nolint:errcheck // test
+	_ = d.SetKey(starlark.String("publish"), starlark.NewList([]starlark.Value{pub})) //nolint:errcheck // test
+
+	res, err := parseScriptResult(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Occupied == nil || !*res.Occupied {
+		t.Error("expected occupied=true")
+	}
+	if len(res.Publish) != 1 || res.Publish[0].topic != "a/b" || res.Publish[0].payload != "3" || !res.Publish[0].retain {
+		t.Errorf("unexpected publishes: %+v", res.Publish)
+	}
+
+	if _, err := parseScriptResult(starlark.String("yes")); err == nil {
+		t.Error("expected an error for a string result")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Threshold sensors: numeric readings (CO2, power draw, sound level...) become
+// occupancy evidence once they cross their configured threshold. A sensor
+// stays active until a reading falls back across the threshold, and for the
+// topic's hold time after that, so devices that only report on change keep a
+// room occupied for as long as they are on. Evidence is aggregated per room,
+// so the occupancy manager only hears SENSOR_ON when the first sensor in a
+// room becomes active and SENSOR_OFF when the last one lapses.
+
+var sensor_channel = make(chan MQTT_Item, 10)
+
+type sensorReading struct {
+	room        string
+	hold        int64
+	active      bool  // the last reading crossed the threshold
+	last_active int64 // when the sensor was last seen active
+}
+
+// sensorTracker holds the current state per topic and the aggregate
+// state reported for each room. It is owned by SensorManagerRoutine.
+type sensorTracker struct {
+	topics map[string]sensorReading
+	rooms  map[string]bool
+}
+
+func newSensorTracker() *sensorTracker {
+	return &sensorTracker{
+		topics: make(map[string]sensorReading),
+		rooms:  make(map[string]bool),
+	}
+}
+
+// observe records a reading for topic and returns the rooms whose aggregate
+// state changed.
+func (s *sensorTracker) observe(room string, cfg SensorTopic, value float64, now int64) map[string]bool {
+	active := value >= cfg.Threshold
+	if cfg.Below {
+		active = value < cfg.Threshold
+	}
+	r := s.topics[cfg.Topic]
+	r.room = room
+	r.hold = cfg.Hold
+	if active || r.active {
+		r.last_active = now
+	}
+	r.active = active
+	s.topics[cfg.Topic] = r
+	return s.evaluate(now)
+}
+
+// evaluate recomputes every room's aggregate state and returns those that
+// changed, mapped to their new state.
+func (s *sensorTracker) evaluate(now int64) map[string]bool {
+	current := make(map[string]bool)
+	for _, r := range s.topics {
+		if r.active || (r.last_active > 0 && now-r.last_active <= r.hold) {
+			current[r.room] = true
+		} else if !current[r.room] {
+			current[r.room] = false
+		}
+	}
+	changed := make(map[string]bool)
+	for room, active := range current {
+		if s.rooms[room] != active {
+			s.rooms[room] = active
+			changed[room] = active
+		}
+	}
+	return changed
+}
+
+func SensorManagerRoutine() {
+	tracker := newSensorTracker()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		var changed map[string]bool
+		select {
+		case item := <-sensor_channel:
+			cfg, ok := CurrentModel().FindSensorTopic(item.Topic)
+			if !ok {
+				continue
+			}
+			value, err := PayloadNumber(item.Data, cfg.Field)
+			if err != nil {
+				Logger.Debug().Msgf("%s unrecognized sensor payload on %s: %v", item.Room, item.Topic, err)
+				continue
+			}
+			Logger.Debug().Msgf("%s sensor %s reading %.2f (threshold %.2f)", item.Room, item.Topic, value, cfg.Threshold)
+			changed = tracker.observe(item.Room, cfg, value, time.Now().Unix())
+		case <-ticker.C:
+			changed = tracker.evaluate(time.Now().Unix())
+		}
+		for room, active := range changed {
+			result := SENSOR_OFF
+			if active {
+				result = SENSOR_ON
+			}
+			results_channel <- MQTT_Item{Room: room, Type: SENSOR, Analysis_result: result}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestSensorTrackerHold(t *testing.T) {
+	tracker := newSensorTracker()
+	tv := SensorTopic{Topic: "tv/power", Threshold: 40, Hold: 60}
+
+	// Below threshold from the start: no state change is reported.
+	if changed := tracker.observe("family_room", tv, 2, 100); len(changed) != 0 {
+		t.Errorf("expected no change, got %v", changed)
+	}
+
+	changed := tracker.observe("family_room", tv, 80, 110)
+	if active, ok := changed["family_room"]; !ok || !active {
+		t.Fatalf("expected family_room to become active, got %v", changed)
+	}
+
+	// Dropping below threshold starts the hold time.
+	if changed := tracker.observe("family_room", tv, 2, 150); len(changed) != 0 {
+		t.Errorf("expected hold to keep room active, got %v", changed)
+	}
+	if changed := tracker.evaluate(210); len(changed) != 0 {
+		t.Errorf("expected room active until the hold ends, got %v", changed)
+	}
+
+	changed = tracker.evaluate(211)
+	if active, ok := changed["family_room"]; !ok || active {
+		t.Errorf("expected family_room to lapse after hold, got %v", changed)
+	}
+}
+
+func TestSensorTrackerSilentWhileOn(t *testing.T) {
+	tracker := newSensorTracker()
+	tv := SensorTopic{Topic: "tv/power", Threshold: 40, Hold: 300}
+
+	// A plug reporting only on change stays on however long it is quiet.
+	if changed := tracker.observe("family_room", tv, 80, 100); !changed["family_room"] {
+		t.Fatalf("expected family_room to become active, got %v", changed)
+	}
+	if changed := tracker.evaluate(100 + 3600); len(changed) != 0 {
+		t.Errorf("expected silent sensor to stay active, got %v", changed)
+	}
+
+	tracker.observe("family_room", tv, 1, 5000)
+	if changed := tracker.evaluate(5300); len(changed) != 0 {
+		t.Errorf("expected hold after switching off, got %v", changed)
+	}
+	if changed := tracker.evaluate(5301); changed["family_room"] || len(changed) != 1 {
+		t.Errorf("expected family_room to lapse, got %v", changed)
+	}
+}
+
+func TestSensorTrackerBelowAndAggregate(t *testing.T) {
+	tracker := newSensorTracker()
+	lux := SensorTopic{Topic: "desk/lamp", Threshold: 5, Below: true}
+	co2 := SensorTopic{Topic: "office/co2", Threshold: 800, Hold: 300}
+
+	if changed := tracker.observe("office", lux, 1, 100); !changed["office"] {
+		t.Fatalf("below-threshold sensor should be active, got %v", changed)
+	}
+	// A second active sensor in the same room does not re-report the room.
+	if changed := tracker.observe("office", co2, 900, 100); len(changed) != 0 {
+		t.Errorf("expected no change for already-active room, got %v", changed)
+	}
+	// The room stays active while any sensor is active.
+	if changed := tracker.observe("office", lux, 10, 101); len(changed) != 0 {
+		t.Errorf("expected office to stay active via co2, got %v", changed)
+	}
+}
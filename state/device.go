@@ -1,5 +1,13 @@
 package state
 
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elijahnyp/home_controller/util"
+)
+
 type Device interface {
 	Status() uint
 	State(uint) error
@@ -7,3 +15,152 @@ type Device interface {
 	SetConfig(any) error
 	GetConfig() any
 }
+
+// changeHooks are notified when a device's mirrored state changes. Like the
+// MQTT connect hooks they are registered by name so re-registering replaces.
+var (
+	hooksMu     sync.RWMutex
+	changeHooks = make(map[string]func(d Device, previous string))
+)
+
+// RegisterChangeHook registers (or, with a nil hook, removes) a function called
+// after a device's state changes. previous is the prior state value, "" when
+// this is the first state seen for the device.
+func RegisterChangeHook(name string, hook func(d Device, previous string)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	if hook == nil {
+		delete(changeHooks, name)
+	} else {
+		changeHooks[name] = hook
+	}
+}
+
+func notifyChange(d Device, previous string) {
+	hooksMu.RLock()
+	hooks := make([]func(Device, string), 0, len(changeHooks))
+	for _, h := range changeHooks {
+		hooks = append(hooks, h)
+	}
+	hooksMu.RUnlock()
+	for _, h := range hooks {
+		h(d, previous)
+	}
+}
+
+// MQTTDevice is the shared part of the MQTT-backed lights and sensors: it
+// mirrors the payload last seen on the state topic, tracks availability, and
+// publishes on/off commands to the command topic.
+type MQTTDevice struct {
+	mu        sync.RWMutex
+	config    util.DeviceConfig
+	location  string
+	raw       string
+	updated   int64
+	available bool
+}
+
+func newMQTTDevice(location string, config util.DeviceConfig) MQTTDevice {
+	return MQTTDevice{
+		location:  location,
+		config:    config.WithDefaults(),
+		available: true,
+	}
+}
+
+func (d *MQTTDevice) Location() string {
+	return d.location
+}
+
+// Name returns the configured device name.
+func (d *MQTTDevice) Name() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.config.Name
+}
+
+// SetConfig replaces the device config. It accepts a util.DeviceConfig.
+func (d *MQTTDevice) SetConfig(config any) error {
+	c, ok := config.(util.DeviceConfig)
+	if !ok {
+		return fmt.Errorf("state: unsupported config type %T", config)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config = c.WithDefaults()
+	return nil
+}
+
+func (d *MQTTDevice) GetConfig() any {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.config
+}
+
+// Available reports the device availability. Devices without an availability
+// topic are always available.
+func (d *MQTTDevice) Available() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.available
+}
+
+// LastUpdate returns the unix time of the last state message, or 0 if none has
+// been received yet.
+func (d *MQTTDevice) LastUpdate() int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.updated
+}
+
+// Raw returns the last state value extracted from the state topic.
+func (d *MQTTDevice) Raw() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.raw
+}
+
+// handle applies a message for one of the device's topics. It reports whether
+// the device consumed the message and, if the state value changed, the
+// previous value.
+func (d *MQTTDevice) handle(topic string, payload []byte) (handled, changed bool, previous string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if topic == d.config.Availability_topic {
+		switch string(payload) {
+		case d.config.Payload_available:
+			d.available = true
+		case d.config.Payload_not_available:
+			d.available = false
+		}
+		handled = true
+	}
+	if topic == d.config.State_topic {
+		value, err := util.PayloadValue(payload, d.config.State_field)
+		if err != nil {
+			util.Logger.Debug().Msgf("state: %s unrecognized payload on %s: %v", d.config.Name, topic, err)
+			return true, false, ""
+		}
+		changed = d.raw != value
+		previous = d.raw
+		d.raw = value
+		d.updated = time.Now().Unix()
+		handled = true
+	}
+	return handled, changed, previous
+}
+
+// command publishes the on/off command for the device.
+func (d *MQTTDevice) command(on bool) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.config.Command_topic == "" {
+		return fmt.Errorf("state: %s has no command topic", d.config.Name)
+	}
+	payload := d.config.Command_off
+	if on {
+		payload = d.config.Command_on
+	}
+	util.PublishAsync(d.config.Command_topic, byte(0), false, []byte(payload))
+	return nil
+}
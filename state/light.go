@@ -1,5 +1,41 @@
 package state
 
+import (
+	"strings"
+
+	"github.com/elijahnyp/home_controller/util"
+)
+
 type Light interface {
 	Device
 }
+
+// MQTTLight is an on/off light. Status is 1 when the last state payload
+// matched payload_on and 0 otherwise; State publishes the matching command.
+type MQTTLight struct {
+	MQTTDevice
+}
+
+// NewMQTTLight creates a light located in room.
+func NewMQTTLight(room string, config util.DeviceConfig) *MQTTLight {
+	return &MQTTLight{MQTTDevice: newMQTTDevice(room, config)}
+}
+
+func (l *MQTTLight) Status() uint {
+	if l.IsOn() {
+		return 1
+	}
+	return 0
+}
+
+// State turns the light on for any non-zero state and off for zero.
+func (l *MQTTLight) State(newState uint) error {
+	return l.command(newState != 0)
+}
+
+// IsOn reports whether the light's last reported state was on.
+func (l *MQTTLight) IsOn() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return strings.EqualFold(l.raw, l.config.Payload_on)
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"github.com/elijahnyp/home_controller/util"
+)
+
+// Manager owns the MQTT-backed rooms built from the model config and routes
+// incoming state/availability messages to their devices. A new Manager is
+// built on every config reload; NewManager carries device state and room
+// occupancy over from the previous one so nothing is forgotten until the next
+// (usually retained) state message arrives.
+type Manager struct {
+	rooms  map[string]*MQTTRoom
+	topics map[string][]Device
+}
+
+// NewManager builds the rooms and devices for model, copying state for devices
+// and rooms with the same name from prev (which may be nil).
+func NewManager(model *util.Model, prev *Manager) *Manager {
+	m := &Manager{
+		rooms:  make(map[string]*MQTTRoom),
+		topics: make(map[string][]Device),
+	}
+	for _, cfg := range model.Rooms {
+		room := &MQTTRoom{name: cfg.Name}
+		for _, dc := range cfg.Lights {
+			l := NewMQTTLight(cfg.Name, dc)
+			if old := prev.findLight(cfg.Name, dc.Name); old != nil {
+				l.copyState(&old.MQTTDevice)
+			}
+			room.lights = append(room.lights, l)
+			m.register(l, l.config)
+		}
+		for _, dc := range cfg.Sensors {
+			s := NewMQTTSensor(cfg.Name, dc)
+			if old := prev.findSensor(cfg.Name, dc.Name); old != nil {
+				s.copyState(&old.MQTTDevice)
+			}
+			room.sensors = append(room.sensors, s)
+			m.register(s, s.config)
+		}
+		if old, ok := prev.Room(cfg.Name); ok {
+			room.occupied = old.Status().Occupied
+		}
+		m.rooms[cfg.Name] = room
+	}
+	return m
+}
+
+func (m *Manager) register(d Device, config util.DeviceConfig) {
+	for _, t := range config.Topics() {
+		m.topics[t] = append(m.topics[t], d)
+	}
+}
+
+// mqttDevice returns the shared MQTT state of a concrete device.
+func mqttDevice(d Device) *MQTTDevice {
+	switch v := d.(type) {
+	case *MQTTLight:
+		return &v.MQTTDevice
+	case *MQTTSensor:
+		return &v.MQTTDevice
+	}
+	return nil
+}
+
+// Room returns the named room.
+func (m *Manager) Room(name string) (*MQTTRoom, bool) {
+	if m == nil {
+		return nil, false
+	}
+	r, ok := m.rooms[name]
+	return r, ok
+}
+
+// Rooms returns every configured room keyed by name.
+func (m *Manager) Rooms() map[string]*MQTTRoom {
+	if m == nil {
+		return nil
+	}
+	return m.rooms
+}
+
+// HandleMessage applies an MQTT message to every device listening on topic,
+// notifies change hooks for devices whose state changed, and reports whether
+// any device consumed it.
+func (m *Manager) HandleMessage(topic string, payload []byte) bool {
+	if m == nil {
+		return false
+	}
+	handled := false
+	for _, d := range m.topics[topic] {
+		ok, changed, previous := mqttDevice(d).handle(topic, payload)
+		if ok {
+			handled = true
+		}
+		if changed {
+			notifyChange(d, previous)
+		}
+	}
+	return handled
+}
+
+// Light returns the named light in room.
+func (m *Manager) Light(room, name string) (*MQTTLight, bool) {
+	l := m.findLight(room, name)
+	return l, l != nil
+}
+
+func (m *Manager) findLight(room, name string) *MQTTLight {
+	r, ok := m.Room(room)
+	if !ok {
+		return nil
+	}
+	for _, l := range r.lights {
+		if l.Name() == name {
+			return l
+		}
+	}
+	return nil
+}
+
+func (m *Manager) findSensor(room, name string) *MQTTSensor {
+	r, ok := m.Room(room)
+	if !ok {
+		return nil
+	}
+	for _, s := range r.sensors {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// copyState carries the observed state of old over to d.
+func (d *MQTTDevice) copyState(old *MQTTDevice) {
+	old.mu.RLock()
+	defer old.mu.RUnlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.raw = old.raw
+	d.updated = old.updated
+	d.available = old.available
+}
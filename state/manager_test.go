@@ -0,0 +1,151 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/elijahnyp/home_controller/util"
+)
+
+func testModel() *util.Model {
+	return &util.Model{
+		Rooms: []util.Room{
+			{
+				Name: "office",
+				Lights: []util.DeviceConfig{{
+					Name:               "ceiling",
+					State_topic:        "z2m/ceiling",
+					State_field:        "state",
+					Command_topic:      "z2m/ceiling/set",
+					Availability_topic: "z2m/ceiling/availability",
+				}},
+				Sensors: []util.DeviceConfig{
+					{Name: "lux", State_topic: "z2m/motion", State_field: "illuminance_lux"},
+					{Name: "contact", State_topic: "z2m/door"},
+				},
+			},
+		},
+	}
+}
+
+func TestManager_HandleMessage(t *testing.T) {
+	m := NewManager(testModel(), nil)
+
+	room, ok := m.Room("office")
+	if !ok {
+		t.Fatal("expected office room")
+	}
+	light := room.Lights()[0]
+	lux := room.Sensors()[0]
+
+	if light.Status() != 0 {
+		t.Errorf("light Status() = %d before any message, expected 0", light.Status())
+	}
+	if !m.HandleMessage("z2m/ceiling", []byte(`{"state":"ON","brightness":254}`)) {
+		t.Error("expected light state message to be handled")
+	}
+	if light.Status() != 1 || !light.IsOn() {
+		t.Errorf("light Status() = %d after ON, expected 1", light.Status())
+	}
+
+	if !m.HandleMessage("z2m/ceiling/availability", []byte("offline")) {
+		t.Error("expected availability message to be handled")
+	}
+	if light.Available() {
+		t.Error("light should be unavailable after offline")
+	}
+
+	if _, ok := lux.Value(); ok {
+		t.Error("sensor should have no value before any message")
+	}
+	m.HandleMessage("z2m/motion", []byte(`{"illuminance_lux": 41.6, "occupancy": false}`))
+	if v, ok := lux.Value(); !ok || v != 41.6 {
+		t.Errorf("lux Value() = %v, %v; expected 41.6", v, ok)
+	}
+	if lux.Status() != 42 {
+		t.Errorf("lux Status() = %d, expected 42", lux.Status())
+	}
+
+	// Binary sensors map payload_on/payload_off to 1/0.
+	contact := room.Sensors()[1]
+	m.HandleMessage("z2m/door", []byte("ON"))
+	if contact.Status() != 1 {
+		t.Errorf("contact Status() = %d, expected 1", contact.Status())
+	}
+
+	if m.HandleMessage("z2m/unknown", []byte("x")) {
+		t.Error("unknown topic should not be handled")
+	}
+}
+
+func TestManager_CarriesStateAcrossReload(t *testing.T) {
+	prev := NewManager(testModel(), nil)
+	prev.HandleMessage("z2m/ceiling", []byte(`{"state":"ON"}`))
+	room, _ := prev.Room("office")
+	if err := room.SetState(RoomState{Occupied: true}); err != nil {
+		t.Fatalf("SetState: %v", err)
+	}
+
+	next := NewManager(testModel(), prev)
+	room, _ = next.Room("office")
+	st := room.Status()
+	if !st.Occupied {
+		t.Error("occupancy should carry over a reload")
+	}
+	if len(st.Lights) != 1 || st.Lights[0].Status() != 1 {
+		t.Error("light state should carry over a reload")
+	}
+	if len(st.Sensors) != 2 {
+		t.Errorf("room has %d sensors, expected 2", len(st.Sensors))
+	}
+}
+
+func TestMQTTDevice_Commands(t *testing.T) {
+	m := NewManager(testModel(), nil)
+	room, _ := m.Room("office")
+
+	if err := room.Lights()[0].State(1); err != nil {
+		t.Errorf("light State(1) returned error: %v", err)
+	}
+	if err := room.Sensors()[0].State(1); err == nil {
+		t.Error("sensor State() should fail: sensors are read-only")
+	}
+
+	noCommand := NewMQTTLight("office", util.DeviceConfig{Name: "lamp", State_topic: "lamp"})
+	if err := noCommand.State(1); err == nil {
+		t.Error("light without command topic should fail State()")
+	}
+
+	if err := noCommand.SetConfig("bogus"); err == nil {
+		t.Error("SetConfig should reject unsupported config types")
+	}
+	if err := noCommand.SetConfig(util.DeviceConfig{Name: "lamp", Payload_on: "1"}); err != nil {
+		t.Errorf("SetConfig returned error: %v", err)
+	}
+	if cfg, ok := noCommand.GetConfig().(util.DeviceConfig); !ok || cfg.Payload_off != "OFF" {
+		t.Errorf("GetConfig() = %v, expected defaults applied", noCommand.GetConfig())
+	}
+
+	// Concrete devices satisfy the package interfaces.
+	var _ Light = noCommand
+	var _ Sensor = room.Sensors()[0]
+	var _ Room = room
+}
+
+func TestRegisterChangeHook(t *testing.T) {
+	m := NewManager(testModel(), nil)
+	var calls []string
+	RegisterChangeHook("test", func(d Device, previous string) {
+		if l, ok := d.(*MQTTLight); ok {
+			calls = append(calls, previous+">"+l.Raw())
+		}
+	})
+	defer RegisterChangeHook("test", nil)
+
+	m.HandleMessage("z2m/ceiling", []byte(`{"state":"OFF"}`))
+	m.HandleMessage("z2m/ceiling", []byte(`{"state":"OFF"}`)) // unchanged: no call
+	m.HandleMessage("z2m/ceiling", []byte(`{"state":"ON"}`))
+
+	if len(calls) != 2 || calls[0] != ">OFF" || calls[1] != "OFF>ON" {
+		t.Errorf("change hook calls = %v, expected [>OFF OFF>ON]", calls)
+	}
+}
@@ -1,5 +1,9 @@
 package state
 
+import (
+	"sync"
+)
+
 type Room interface {
 	Status() RoomState
 	SetState(RoomState) error
@@ -10,3 +14,50 @@ type RoomState struct {
 	Sensors  []Sensor
 	Occupied bool
 }
+
+// MQTTRoom groups the MQTT-backed devices configured for a room together with
+// its current occupancy.
+type MQTTRoom struct {
+	mu       sync.RWMutex
+	name     string
+	lights   []*MQTTLight
+	sensors  []*MQTTSensor
+	occupied bool
+}
+
+// Name returns the room name.
+func (r *MQTTRoom) Name() string {
+	return r.name
+}
+
+// Lights returns the room's lights.
+func (r *MQTTRoom) Lights() []*MQTTLight {
+	return r.lights
+}
+
+// Sensors returns the room's sensors.
+func (r *MQTTRoom) Sensors() []*MQTTSensor {
+	return r.sensors
+}
+
+func (r *MQTTRoom) Status() RoomState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	st := RoomState{Occupied: r.occupied}
+	for _, l := range r.lights {
+		st.Lights = append(st.Lights, l)
+	}
+	for _, s := range r.sensors {
+		st.Sensors = append(st.Sensors, s)
+	}
+	return st
+}
+
+// SetState records the room's occupancy. Device membership comes from the
+// model config, so the Lights and Sensors in newState are ignored.
+func (r *MQTTRoom) SetState(newState RoomState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.occupied = newState.Occupied
+	return nil
+}
@@ -1,5 +1,58 @@
 package state
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/elijahnyp/home_controller/util"
+)
+
 type Sensor interface {
 	Device
 }
+
+// MQTTSensor is a read-only sensor. Numeric payloads are reported as-is;
+// payload_on/payload_off map to 1/0 so binary sensors work too.
+type MQTTSensor struct {
+	MQTTDevice
+}
+
+// NewMQTTSensor creates a sensor located in room.
+func NewMQTTSensor(room string, config util.DeviceConfig) *MQTTSensor {
+	return &MQTTSensor{MQTTDevice: newMQTTDevice(room, config)}
+}
+
+// Status returns the reading rounded to the nearest non-negative integer.
+func (s *MQTTSensor) Status() uint {
+	v, ok := s.Value()
+	if !ok || v <= 0 {
+		return 0
+	}
+	return uint(math.Round(v))
+}
+
+// State is not supported: sensors are read-only.
+func (s *MQTTSensor) State(uint) error {
+	return fmt.Errorf("state: sensor %s is read-only", s.Name())
+}
+
+// Value returns the last reading and whether one has been received.
+func (s *MQTTSensor) Value() (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.updated == 0 {
+		return 0, false
+	}
+	if v, err := strconv.ParseFloat(s.raw, 64); err == nil {
+		return v, true
+	}
+	switch {
+	case strings.EqualFold(s.raw, s.config.Payload_on):
+		return 1, true
+	case strings.EqualFold(s.raw, s.config.Payload_off):
+		return 0, true
+	}
+	return 0, false
+}
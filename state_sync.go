@@ -3,7 +3,9 @@ package main
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/elijahnyp/home_controller/state"
 	. "github.com/elijahnyp/home_controller/util"
 )
 
@@ -30,6 +32,21 @@ func SetModel(m *Model) {
 	modelPtr.Store(m)
 }
 
+// ---- MQTT-backed devices (rebuilt with the model on config reload) ---------
+
+var devicesPtr atomic.Pointer[state.Manager]
+
+// CurrentDevices returns the active device manager. It may be nil before the
+// first config load; state.Manager methods are nil-safe.
+func CurrentDevices() *state.Manager {
+	return devicesPtr.Load()
+}
+
+// SetDevices atomically publishes a freshly built device manager.
+func SetDevices(m *state.Manager) {
+	devicesPtr.Store(m)
+}
+
 // ---- image/detection cache -------------------------------------------------
 
 var (
@@ -82,3 +99,53 @@ func GetMotionState(topic string) bool {
 	defer webStateMu.RUnlock()
 	return last_motion_state[topic]
 }
+
+// ---- house mode ------------------------------------------------------------
+
+var houseMode atomic.Value
+
+// SetHouseMode records the current house mode (e.g. "home", "away", "night").
+func SetHouseMode(mode string) {
+	houseMode.Store(mode)
+}
+
+// GetHouseMode returns the current house mode, or "" if none has been received.
+func GetHouseMode() string {
+	mode, _ := houseMode.Load().(string)
+	return mode
+}
+
+// ---- activity feed ---------------------------------------------------------
+
+const activityLimit = 100
+
+var (
+	activityMu sync.RWMutex
+	activity   []ActivityItem
+)
+
+// AddActivity appends an entry to the activity feed (keeping the most recent
+// activityLimit entries) and pushes it to websocket clients.
+func AddActivity(kind, room, message string) {
+	item := ActivityItem{Type: kind, Room: room, Message: message, Timestamp: time.Now().Unix()}
+	activityMu.Lock()
+	activity = append(activity, item)
+	if len(activity) > activityLimit {
+		activity = activity[len(activity)-activityLimit:]
+	}
+	activityMu.Unlock()
+	if wsHub != nil {
+		wsHub.BroadcastUpdate("activity", item)
+	}
+}
+
+// RecentActivity returns the activity feed, newest first.
+func RecentActivity() []ActivityItem {
+	activityMu.RLock()
+	defer activityMu.RUnlock()
+	out := make([]ActivityItem, 0, len(activity))
+	for i := len(activity) - 1; i >= 0; i-- {
+		out = append(out, activity[i])
+	}
+	return out
+}
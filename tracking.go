@@ -0,0 +1,79 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+// Camera tracking: every pic topic has its own Tracker (see util/tracker.go),
+// so a person lingering in view keeps one track ID from frame to frame. After
+// each frame the camera's active tracks go out over the websocket as
+// "tracks" and their moves are checked against the room's count lines;
+// /api/room lists the tracks of the room's cameras.
+
+// CameraTracks is the websocket update for one camera's active tracks.
+type CameraTracks struct {
+	Room   string  `json:"room"`
+	Camera string  `json:"camera"`
+	Tracks []Track `json:"tracks"`
+}
+
+type cameraTracker struct {
+	room    string
+	tracker *Tracker
+}
+
+var (
+	trackersMu sync.Mutex
+	trackers   = make(map[string]*cameraTracker) // pic topic -> tracker
+)
+
+// trackDetections runs a frame's detections through the camera's tracker and
+// returns them with their track IDs.
+func trackDetections(room, topic string, dets []TritonDetection, now time.Time) []TritonDetection {
+	trackersMu.Lock()
+	ct := trackers[topic]
+	if ct == nil {
+		ct = &cameraTracker{room: room, tracker: NewTracker(topic)}
+		trackers[topic] = ct
+	}
+	ct.room = room
+	trackersMu.Unlock()
+
+	dets, moves, ended := ct.tracker.Update(dets, now)
+	person := slices.ContainsFunc(dets, func(d TritonDetection) bool {
+		return d.Label == "person" && d.Confidence >= minPersonConfidence()
+	})
+	countCrossings(room, topic, moves, person, now)
+	for _, t := range ended {
+		Logger.Debug().Msgf("%s: track %d (%s) ended after %ds", topic, t.ID, t.Label, t.Dwell)
+		RecordTrackDwell(room, t.Label, time.Duration(t.Dwell)*time.Second)
+	}
+	if wsHub != nil {
+		wsHub.BroadcastUpdate("tracks", CameraTracks{Room: room, Camera: topic, Tracks: ct.tracker.Tracks(now)})
+	}
+	return dets
+}
+
+// ActiveTracks returns the active tracks of all of a room's cameras.
+func ActiveTracks(room string) []Track {
+	now := time.Now()
+	trackersMu.Lock()
+	var cams []*Tracker
+	for _, ct := range trackers {
+		if ct.room == room {
+			cams = append(cams, ct.tracker)
+		}
+	}
+	trackersMu.Unlock()
+	out := []Track{}
+	for _, t := range cams {
+		out = append(out, t.Tracks(now)...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
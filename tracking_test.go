@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/elijahnyp/home_controller/util"
+)
+
+func TestTrackDetections(t *testing.T) {
+	defer func() {
+		trackersMu.Lock()
+		trackers = make(map[string]*cameraTracker)
+		trackersMu.Unlock()
+	}()
+	person := []TritonDetection{{Label: "person", Confidence: 0.9, XMin: 10, YMin: 10, XMax: 60, YMax: 120}}
+	now := time.Now()
+
+	trackDetections("hall", "cams/hall/1", person, now.Add(-20*time.Second))
+	dets := trackDetections("hall", "cams/hall/1", person, now.Add(-10*time.Second))
+	trackDetections("hall", "cams/hall/2", person, now)
+	if dets[0].TrackID == 0 {
+		t.Fatal("no track ID after two frames")
+	}
+	tracks := ActiveTracks("hall")
+	if len(tracks) != 1 || tracks[0].ID != dets[0].TrackID || tracks[0].Camera != "cams/hall/1" || tracks[0].Dwell != 10 {
+		t.Errorf("active tracks %+v", tracks)
+	}
+	if tracks := ActiveTracks("office"); tracks == nil || len(tracks) != 0 {
+		t.Errorf("office tracks %#v", tracks)
+	}
+}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Batched inference. With `inference_batch_size` above 1 the Triton gRPC
+// detector gathers concurrent detections (one per camera image, up to
+// inference_concurrency at a time) for up to `inference_batch_delay_ms`, or
+// until the batch is full, and sends them as one [N, 3, H, W] request. Each
+// image keeps its own letterbox, so boxes are mapped back to the image that
+// asked for them. The batch never exceeds what the model accepts; see
+// yoloParams.batchLimit.
+
+type batchRequest struct {
+	ctx    context.Context
+	tp     *tritonPool
+	p      yoloParams
+	info   *TritonModelInfo
+	input  []byte
+	lb     letterbox
+	queued time.Time
+	reply  chan batchReply
+}
+
+type batchReply struct {
+	dets []TritonDetection
+	err  error
+}
+
+// batchingDetector is the Triton gRPC detector with request batching.
+type batchingDetector struct {
+	size  int
+	delay time.Duration
+	reqs  chan *batchRequest
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newBatchingDetector(size int, delay time.Duration) *batchingDetector {
+	b := &batchingDetector{
+		size:  size,
+		delay: delay,
+		reqs:  make(chan *batchRequest),
+		done:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (*batchingDetector) Name() string { return DetectorTritonGRPC }
+
+func (b *batchingDetector) Detect(ctx context.Context, jpegData []byte) ([]TritonDetection, error) {
+	tp, p, info, err := tritonParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.batchLimit(info, b.size) <= 1 {
+		return tritonGRPCDetector{}.Detect(ctx, jpegData)
+	}
+	input, lb, err := prepareInput(jpegData, p)
+	if err != nil {
+		return nil, fmt.Errorf("triton: %w", err)
+	}
+	r := &batchRequest{
+		ctx: ctx, tp: tp, p: p, info: info,
+		input: input, lb: lb,
+		queued: time.Now(),
+		reply:  make(chan batchReply, 1),
+	}
+	select {
+	case b.reqs <- r:
+	case <-b.done:
+		// Replaced by a config reload; finish this image on its own.
+		dets, err := tp.infer(ctx, p, info, [][]byte{input}, []letterbox{lb})
+		releaseTensor(input)
+		if err != nil {
+			return nil, err
+		}
+		RecordInferenceBatch(p.model, "single", 1, 0)
+		return dets[0], nil
+	case <-ctx.Done():
+		releaseTensor(input)
+		return nil, ctx.Err()
+	}
+	// The batcher owns input from here on.
+	select {
+	case rep := <-r.reply:
+		return rep.dets, rep.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops collecting batches. Batches already collected still complete.
+func (b *batchingDetector) Close() {
+	b.once.Do(func() { close(b.done) })
+}
+
+func (b *batchingDetector) run() {
+	for {
+		var first *batchRequest
+		select {
+		case first = <-b.reqs:
+		case <-b.done:
+			return
+		}
+		batch := []*batchRequest{first}
+		limit := first.p.batchLimit(first.info, b.size)
+		timer := time.NewTimer(b.delay)
+	collect:
+		for len(batch) < limit {
+			select {
+			case r := <-b.reqs:
+				batch = append(batch, r)
+			case <-timer.C:
+				break collect
+			case <-b.done:
+				break collect
+			}
+		}
+		timer.Stop()
+		go flushBatch(batch)
+	}
+}
+
+// flushBatch sends a collected batch, split into groups that share a model and
+// input size in case the config changed while it was filling.
+func flushBatch(batch []*batchRequest) {
+	for len(batch) > 0 {
+		var group, rest []*batchRequest
+		for _, r := range batch {
+			if sameBatchModel(r, batch[0]) {
+				group = append(group, r)
+			} else {
+				rest = append(rest, r)
+			}
+		}
+		sendBatch(group)
+		batch = rest
+	}
+}
+
+func sameBatchModel(a, b *batchRequest) bool {
+	return a.tp == b.tp && a.p.model == b.p.model && a.p.version == b.p.version &&
+		a.p.width == b.p.width && a.p.height == b.p.height && a.p.inputName == b.p.inputName &&
+		a.p.datatype == b.p.datatype && a.p.jpeg == b.p.jpeg
+}
+
+func sendBatch(group []*batchRequest) {
+	defer func() {
+		for _, r := range group {
+			releaseTensor(r.input)
+		}
+	}()
+	// Skip images whose caller has already given up.
+	var live []*batchRequest
+	for _, r := range group {
+		if r.ctx.Err() == nil {
+			live = append(live, r)
+		}
+	}
+	if len(live) == 0 {
+		return
+	}
+	first := live[0]
+	wait := time.Since(first.queued)
+	inputs := make([][]byte, len(live))
+	lbs := make([]letterbox, len(live))
+	for i, r := range live {
+		inputs[i], lbs[i] = r.input, r.lb
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DetectorTimeout())
+	defer cancel()
+	dets, err := first.tp.infer(ctx, first.p, first.info, inputs, lbs)
+	if err == nil {
+		RecordInferenceBatch(first.p.model, "batched", len(live), wait)
+	}
+	for i, r := range live {
+		if err != nil {
+			r.reply <- batchReply{err: err}
+			continue
+		}
+		r.reply <- batchReply{dets: dets[i]}
+	}
+}
+
+// splitBatch splits an output tensor with a leading batch dimension of n into
+// n single-image tensors, each with a batch dimension of 1.
+func splitBatch(out outputTensor, n int) ([]outputTensor, error) {
+	if n == 1 {
+		return []outputTensor{out}, nil
+	}
+	if len(out.shape) == 0 || out.shape[0] != int64(n) || len(out.data)%n != 0 {
+		return nil, fmt.Errorf("output %s has shape %v for a batch of %d", out.name, out.shape, n)
+	}
+	shape := append([]int64{1}, out.shape[1:]...)
+	per := len(out.data) / n
+	split := make([]outputTensor, n)
+	for i := range split {
+		split[i] = outputTensor{name: out.name, shape: shape, data: out.data[i*per : (i+1)*per]}
+	}
+	return split, nil
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+func TestBatchingDetector(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	s := &metadataServer{meta: yoloMetadata("FP32", 64, 64)}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	b := newBatchingDetector(4, time.Second)
+	defer b.Close()
+
+	// Images of different sizes, so each needs its own letterbox.
+	sizes := []int{64, 128, 32, 96}
+	results := make([][]TritonDetection, len(sizes))
+	errs := make([]error, len(sizes))
+	var wg sync.WaitGroup
+	for i, size := range sizes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = b.Detect(context.Background(), testJPEG(t, size, size))
+		}()
+	}
+	wg.Wait()
+
+	if n := s.infers.Load(); n != 1 {
+		t.Errorf("expected one ModelInfer call for the batch, got %d", n)
+	}
+	if n := s.batch.Load(); n != 4 {
+		t.Errorf("expected a batch of 4, got %d", n)
+	}
+	for i, size := range sizes {
+		if errs[i] != nil || len(results[i]) != 1 {
+			t.Fatalf("image %d: %+v, %v", i, results[i], errs[i])
+		}
+		// yoloOutput's box spans x 22..42 of the 64×64 model input.
+		d := results[i][0]
+		if d.XMin != 22*size/64 || d.XMax != 42*size/64 {
+			t.Errorf("image %d (%dpx): box %+v not mapped back to the image", i, size, d)
+		}
+	}
+}
+
+func TestBatchingDetectorModelLimit(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	s := &metadataServer{meta: yoloMetadata("FP32", 64, 64), config: &tritonpb.ModelConfig{MaxBatchSize: 2}}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	b := newBatchingDetector(8, 200*time.Millisecond)
+	defer b.Close()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if dets, err := b.Detect(context.Background(), testJPEG(t, 64, 64)); err != nil || len(dets) != 1 {
+				t.Errorf("Detect = %+v, %v", dets, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.batch.Load(); n > 2 {
+		t.Errorf("batch of %d exceeds the model's max_batch_size 2", n)
+	}
+
+	// After Close, images are still detected one at a time.
+	b.Close()
+	if dets, err := b.Detect(context.Background(), testJPEG(t, 64, 64)); err != nil || len(dets) != 1 {
+		t.Errorf("Detect after Close = %+v, %v", dets, err)
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	out := outputTensor{name: "output0", shape: []int64{2, 2, 3}, data: []float32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}}
+	split, err := splitBatch(out, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(split) != 2 || split[1].data[0] != 7 || split[1].shape[0] != 1 || split[1].shape[2] != 3 {
+		t.Errorf("unexpected split %+v", split)
+	}
+	if _, err := splitBatch(out, 3); err == nil {
+		t.Error("expected an error for a mismatched batch size")
+	}
+}
+
+func TestBatchLimit(t *testing.T) {
+	var none *TritonModelInfo
+	tests := []struct {
+		info *TritonModelInfo
+		want int
+	}{
+		{none, 8},
+		{&TritonModelInfo{MaxBatchSize: 4}, 4},
+		{&TritonModelInfo{MaxBatchSize: 16}, 8},
+		{&TritonModelInfo{DynamicBatch: true}, 8},
+		{&TritonModelInfo{}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.info.batchLimit(8); got != tt.want {
+			t.Errorf("batchLimit(%+v) = %d, expected %d", tt.info, got, tt.want)
+		}
+	}
+}
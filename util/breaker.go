@@ -0,0 +1,207 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Detector circuit breaker. After `detector_breaker_threshold` consecutive
+// failed detections the breaker opens and Detect fails fast with
+// ErrDetectorUnavailable instead of making the backend time out on every
+// image. Once `detector_breaker_cooldown` seconds have passed a single
+// request is let through as a probe: success closes the breaker, failure
+// opens it for another cooldown. Images that fail to decode say nothing about
+// the backend and are not counted.
+
+// ErrDetectorUnavailable is returned without contacting the backend while the
+// breaker is open.
+var ErrDetectorUnavailable = errors.New("detector unavailable")
+
+// errImageDecode marks errors caused by the image rather than the backend.
+var errImageDecode = errors.New("decode image")
+
+// Breaker states, as reported in DetectorHealth.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// DetectorHealth is the breaker's view of the detection backend.
+type DetectorHealth struct {
+	Backend             string `json:"backend"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	LastError           string `json:"last_error,omitempty"`
+	Since               int64  `json:"since"` // unix time of the last state change
+}
+
+// Available reports whether detections are being attempted.
+func (h DetectorHealth) Available() bool {
+	return h.State != BreakerOpen
+}
+
+var healthListener atomic.Pointer[func(DetectorHealth)]
+
+// OnDetectorHealthChange registers the function called, outside any lock,
+// whenever the breaker changes state or a new detector is installed. Only one
+// listener is kept; nil removes it.
+func OnDetectorHealthChange(fn func(DetectorHealth)) {
+	if fn == nil {
+		healthListener.Store(nil)
+		return
+	}
+	healthListener.Store(&fn)
+}
+
+func notifyDetectorHealth(h DetectorHealth) {
+	if fn := healthListener.Load(); fn != nil {
+		(*fn)(h)
+	}
+}
+
+// CurrentDetectorHealth returns the health of the active detector. Detectors
+// installed without a breaker are always reported closed.
+func CurrentDetectorHealth() DetectorHealth {
+	d := CurrentDetector()
+	if b, ok := d.(*circuitBreaker); ok {
+		return b.Health()
+	}
+	return DetectorHealth{Backend: d.Name(), State: BreakerClosed}
+}
+
+// circuitBreaker wraps a Detector and stops calling it while it is failing.
+type circuitBreaker struct {
+	Detector
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	health   DetectorHealth
+	openedAt time.Time
+	probing  bool // a half-open probe is in flight
+}
+
+// newCircuitBreaker wraps d using the `detector_breaker_threshold` and
+// `detector_breaker_cooldown` config.
+func newCircuitBreaker(d Detector) *circuitBreaker {
+	threshold := Config.GetInt("detector_breaker_threshold")
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	cooldown := time.Duration(Config.GetInt64("detector_breaker_cooldown")) * time.Second
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return &circuitBreaker{
+		Detector:  d,
+		threshold: threshold,
+		cooldown:  cooldown,
+		health:    DetectorHealth{Backend: d.Name(), State: BreakerClosed, Since: time.Now().Unix()},
+	}
+}
+
+func (b *circuitBreaker) Detect(ctx context.Context, jpegData []byte) ([]TritonDetection, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	dets, err := b.Detector.Detect(ctx, jpegData)
+	b.record(err)
+	return dets, err
+}
+
+// Close closes the wrapped detector if it holds resources.
+func (b *circuitBreaker) Close() {
+	if c, ok := b.Detector.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// Health returns a snapshot of the breaker state.
+func (b *circuitBreaker) Health() DetectorHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.health
+}
+
+// allow decides whether a request may reach the backend, moving an open
+// breaker to half-open once the cooldown has passed.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	var changed bool
+	var err error
+	switch b.health.State {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			err = ErrDetectorUnavailable
+			break
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+		changed = true
+	case BreakerHalfOpen:
+		if b.probing {
+			err = ErrDetectorUnavailable
+			break
+		}
+		b.probing = true
+	}
+	h := b.health
+	b.mu.Unlock()
+	if changed {
+		Logger.Info().Msgf("%s detector: probing after %v", h.Backend, b.cooldown)
+		notifyDetectorHealth(h)
+	}
+	return err
+}
+
+// record updates the breaker with the outcome of a request it allowed.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	wasProbe := b.probing
+	b.probing = false
+	prev := b.health.State
+	switch {
+	case errors.Is(err, errImageDecode):
+		// Not the backend's fault; a probe ending here lets the next
+		// request probe instead.
+	case err == nil:
+		b.health.ConsecutiveFailures = 0
+		b.health.LastError = ""
+		if prev != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+	default:
+		b.health.ConsecutiveFailures++
+		b.health.LastError = err.Error()
+		if (prev == BreakerHalfOpen && wasProbe) || (prev == BreakerClosed && b.health.ConsecutiveFailures >= b.threshold) {
+			b.openedAt = time.Now()
+			b.setState(BreakerOpen)
+		}
+	}
+	h := b.health
+	b.mu.Unlock()
+	if h.State == prev {
+		return
+	}
+	switch h.State {
+	case BreakerOpen:
+		Logger.Error().Msgf("%s detector unavailable after %d consecutive failures, retrying in %v: %s",
+			h.Backend, h.ConsecutiveFailures, b.cooldown, h.LastError)
+	case BreakerClosed:
+		Logger.Info().Msgf("%s detector recovered", h.Backend)
+	}
+	notifyDetectorHealth(h)
+}
+
+// setState must be called with mu held.
+func (b *circuitBreaker) setState(state string) {
+	b.health.State = state
+	b.health.Since = time.Now().Unix()
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyDetector fails while err is set and counts the calls that reach it.
+type flakyDetector struct {
+	err   atomic.Pointer[error]
+	calls atomic.Int64
+}
+
+func (f *flakyDetector) Name() string { return "flaky" }
+
+func (f *flakyDetector) Detect(context.Context, []byte) ([]TritonDetection, error) {
+	f.calls.Add(1)
+	if err := f.err.Load(); err != nil {
+		return nil, *err
+	}
+	return []TritonDetection{{Label: "person"}}, nil
+}
+
+func (f *flakyDetector) fail(err error) { f.err.Store(&err) }
+
+func TestCircuitBreaker(t *testing.T) {
+	Config.Set("detector_breaker_threshold", 3)
+	Config.Set("detector_breaker_cooldown", 1)
+	defer Config.Set("detector_breaker_threshold", nil)
+	defer Config.Set("detector_breaker_cooldown", nil)
+
+	var changes []string
+	OnDetectorHealthChange(func(h DetectorHealth) { changes = append(changes, h.State) })
+	defer OnDetectorHealthChange(nil)
+
+	backend := &flakyDetector{}
+	b := newCircuitBreaker(backend)
+	ctx := context.Background()
+
+	// Undecodable images are the image's fault, not the backend's.
+	backend.fail(fmt.Errorf("%w: unexpected EOF", errImageDecode))
+	for range 5 {
+		if _, err := b.Detect(ctx, nil); !errors.Is(err, errImageDecode) {
+			t.Fatalf("expected the decode error, got %v", err)
+		}
+	}
+	if h := b.Health(); h.State != BreakerClosed || h.ConsecutiveFailures != 0 {
+		t.Fatalf("decode errors changed the breaker: %+v", h)
+	}
+
+	backend.fail(errors.New("connection refused"))
+	for range 3 {
+		if _, err := b.Detect(ctx, nil); errors.Is(err, ErrDetectorUnavailable) {
+			t.Fatal("breaker opened before the threshold")
+		}
+	}
+	h := b.Health()
+	if h.State != BreakerOpen || h.ConsecutiveFailures != 3 || h.LastError != "connection refused" || h.Available() {
+		t.Fatalf("after 3 failures: %+v", h)
+	}
+
+	// Open: no calls reach the backend.
+	calls := backend.calls.Load()
+	if _, err := b.Detect(ctx, nil); !errors.Is(err, ErrDetectorUnavailable) {
+		t.Fatalf("open breaker returned %v", err)
+	}
+	if backend.calls.Load() != calls {
+		t.Error("open breaker called the backend")
+	}
+
+	// After the cooldown one probe goes through; failing reopens.
+	b.openedAt = time.Now().Add(-2 * time.Second)
+	if _, err := b.Detect(ctx, nil); errors.Is(err, ErrDetectorUnavailable) || backend.calls.Load() != calls+1 {
+		t.Fatalf("expected a probe, got %v", err)
+	}
+	if b.Health().State != BreakerOpen {
+		t.Fatalf("failed probe left the breaker %s", b.Health().State)
+	}
+
+	// A successful probe closes it.
+	b.openedAt = time.Now().Add(-2 * time.Second)
+	backend.err.Store(nil)
+	if dets, err := b.Detect(ctx, nil); err != nil || len(dets) != 1 {
+		t.Fatalf("probe: %v %v", dets, err)
+	}
+	if h := b.Health(); h.State != BreakerClosed || h.ConsecutiveFailures != 0 || h.LastError != "" {
+		t.Fatalf("after recovery: %+v", h)
+	}
+
+	expected := []string{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if fmt.Sprint(changes) != fmt.Sprint(expected) {
+		t.Errorf("transitions %v, expected %v", changes, expected)
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	backend := &flakyDetector{}
+	b := newCircuitBreaker(backend)
+	b.health.State = BreakerOpen
+	b.openedAt = time.Now().Add(-time.Hour)
+
+	// The first caller after the cooldown probes; others are turned away
+	// until it reports back.
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrDetectorUnavailable) {
+		t.Errorf("second caller allowed during the probe: %v", err)
+	}
+	b.record(nil)
+	if err := b.allow(); err != nil {
+		t.Errorf("closed breaker refused: %v", err)
+	}
+}
+
+func TestCurrentDetectorHealth(t *testing.T) {
+	previous := CurrentDetector()
+	defer SetDetector(previous)
+
+	SetDetector(&flakyDetector{})
+	if h := CurrentDetectorHealth(); h.Backend != "flaky" || h.State != BreakerClosed {
+		t.Errorf("unwrapped detector health %+v", h)
+	}
+	b := newCircuitBreaker(&flakyDetector{})
+	b.health.State = BreakerOpen
+	SetDetector(b)
+	if h := CurrentDetectorHealth(); h.State != BreakerOpen {
+		t.Errorf("health %+v, expected open", h)
+	}
+}
@@ -0,0 +1,176 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+)
+
+// Per-camera models. `camera_models` assigns a detection model to pic topics,
+// optionally only by day or by night, overriding triton_model,
+// triton_model_version, triton_input_width, triton_input_height and
+// yolo_output_format for those cameras:
+//
+//	"camera_models": [
+//	  {"topics": ["cams/driveway"], "model": "yolo11_vehicles"},
+//	  {"topics": ["cams/porch"], "period": "night", "model": "yolo11_ir", "input_width": 512, "input_height": 512}
+//	]
+//
+// A profile for the current period wins over one without a period; cameras
+// with no matching profile use the global settings. Each model's metadata is
+// read from the server separately, so any number can be in use at once.
+
+// CameraModel is one camera_models profile.
+type CameraModel struct {
+	Topics        []string `mapstructure:"topics"`
+	Period        string   `mapstructure:"period"` // "day", "night" or "" for both
+	Model         string   `mapstructure:"model"`
+	Version       string   `mapstructure:"version"`
+	Input_width   int      `mapstructure:"input_width"`
+	Input_height  int      `mapstructure:"input_height"`
+	Output_format string   `mapstructure:"output_format"`
+}
+
+const (
+	PeriodDay   = "day"
+	PeriodNight = "night"
+)
+
+var cameraModelsPtr atomic.Pointer[[]CameraModel]
+
+// LoadCameraModels reads and checks `camera_models`. InitDetector calls it.
+func LoadCameraModels() error {
+	var models []CameraModel
+	if err := Config.UnmarshalKey("camera_models", &models); err != nil {
+		return fmt.Errorf("camera_models: %w", err)
+	}
+	for i, cm := range models {
+		switch {
+		case len(cm.Topics) == 0:
+			return fmt.Errorf("camera_models[%d]: no topics", i)
+		case cm.Period != "" && cm.Period != PeriodDay && cm.Period != PeriodNight:
+			return fmt.Errorf("camera_models[%d]: period %q is not day or night", i, cm.Period)
+		case cm.Output_format != "" && !slices.Contains(YOLOFormats, cm.Output_format):
+			return fmt.Errorf("camera_models[%d]: unknown output_format %q", i, cm.Output_format)
+		}
+	}
+	cameraModelsPtr.Store(&models)
+	return nil
+}
+
+type (
+	cameraKey  struct{}
+	profileKey struct{}
+)
+
+type cameraRef struct {
+	topic string
+	night bool
+}
+
+// WithCamera tells the detector which camera an image comes from and whether
+// it is night there, which selects its camera_models profile.
+func WithCamera(ctx context.Context, topic string, night bool) context.Context {
+	return context.WithValue(ctx, cameraKey{}, cameraRef{topic: topic, night: night})
+}
+
+// withModel makes cm the profile for the request in ctx, whatever its
+// camera, as the shadow model does.
+func withModel(ctx context.Context, cm *CameraModel) context.Context {
+	return context.WithValue(ctx, profileKey{}, cm)
+}
+
+// cameraModel returns the profile for the camera in ctx, or nil.
+func cameraModel(ctx context.Context) *CameraModel {
+	if cm, ok := ctx.Value(profileKey{}).(*CameraModel); ok {
+		return cm
+	}
+	ref, ok := ctx.Value(cameraKey{}).(cameraRef)
+	models := cameraModelsPtr.Load()
+	if !ok || models == nil {
+		return nil
+	}
+	period := PeriodDay
+	if ref.night {
+		period = PeriodNight
+	}
+	var fallback *CameraModel
+	for i := range *models {
+		cm := &(*models)[i]
+		if !slices.Contains(cm.Topics, ref.topic) {
+			continue
+		}
+		if cm.Period == period {
+			return cm
+		}
+		if cm.Period == "" && fallback == nil {
+			fallback = cm
+		}
+	}
+	return fallback
+}
+
+// modelSettings reads the model settings for a request: values from its
+// camera profile, then the config.
+type modelSettings struct {
+	strs     map[string]string
+	ints     map[string]int
+	explicit func(string) bool // nil: see isExplicit
+}
+
+// settingsFor returns the settings for a camera profile, which may be nil.
+func settingsFor(cm *CameraModel) modelSettings {
+	if cm == nil {
+		return modelSettings{}
+	}
+	s := modelSettings{strs: make(map[string]string), ints: make(map[string]int)}
+	for key, v := range map[string]string{
+		"triton_model":         cm.Model,
+		"triton_model_version": cm.Version,
+		"yolo_output_format":   cm.Output_format,
+	} {
+		if v != "" {
+			s.strs[key] = v
+		}
+	}
+	for key, v := range map[string]int{
+		"triton_input_width":  cm.Input_width,
+		"triton_input_height": cm.Input_height,
+	} {
+		if v > 0 {
+			s.ints[key] = v
+		}
+	}
+	return s
+}
+
+func (s modelSettings) getString(key string) string {
+	if v, ok := s.strs[key]; ok {
+		return v
+	}
+	return Config.GetString(key)
+}
+
+func (s modelSettings) getInt(key string) int {
+	if v, ok := s.ints[key]; ok {
+		return v
+	}
+	return Config.GetInt(key)
+}
+
+// isExplicit reports whether the operator set key, so only explicit settings
+// are checked against the model; defaults yield to it. A profile naming its
+// own model is only held to the values in the profile.
+func (s modelSettings) isExplicit(key string) bool {
+	if s.explicit != nil {
+		return s.explicit(key)
+	}
+	_, isStr := s.strs[key]
+	_, isInt := s.ints[key]
+	if isStr || isInt {
+		return true
+	}
+	_, ownModel := s.strs["triton_model"]
+	return !ownModel && Config.InConfig(key)
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+func setCameraModels(t *testing.T, models ...map[string]interface{}) {
+	t.Helper()
+	Config.Set("camera_models", models)
+	t.Cleanup(func() {
+		Config.Set("camera_models", nil)
+		if err := LoadCameraModels(); err != nil {
+			t.Error(err)
+		}
+	})
+	if err := LoadCameraModels(); err != nil {
+		t.Fatalf("LoadCameraModels: %v", err)
+	}
+}
+
+func TestCameraModel(t *testing.T) {
+	setCameraModels(t,
+		map[string]interface{}{"topics": []string{"cams/porch", "cams/yard"}, "model": "yolo11_day"},
+		map[string]interface{}{"topics": []string{"cams/porch"}, "period": "night", "model": "yolo11_ir", "input_width": 320, "input_height": 320},
+		map[string]interface{}{"topics": []string{"cams/driveway"}, "model": "vehicles", "output_format": "end2end"},
+	)
+	tests := []struct {
+		topic string
+		night bool
+		model string
+	}{
+		{"cams/porch", false, "yolo11_day"},
+		{"cams/porch", true, "yolo11_ir"},
+		{"cams/yard", true, "yolo11_day"},
+		{"cams/driveway", false, "vehicles"},
+		{"cams/kitchen", false, ""},
+	}
+	for _, tt := range tests {
+		cm := cameraModel(WithCamera(context.Background(), tt.topic, tt.night))
+		if got := ""; cm != nil {
+			got = cm.Model
+			if got != tt.model {
+				t.Errorf("%s night=%v: model %q, expected %q", tt.topic, tt.night, got, tt.model)
+			}
+		} else if tt.model != "" {
+			t.Errorf("%s night=%v: no profile, expected %q", tt.topic, tt.night, tt.model)
+		}
+	}
+	if cameraModel(context.Background()) != nil {
+		t.Error("a request without a camera got a profile")
+	}
+
+	Config.Set("triton_model", "yolo11")
+	Config.Set("triton_input_width", 640)
+	defer Config.Set("triton_input_width", nil)
+	p := yoloConfig(WithCamera(context.Background(), "cams/porch", true))
+	if p.model != "yolo11_ir" || p.width != 320 || p.height != 320 || p.key == yoloConfig(context.Background()).key {
+		t.Errorf("night porch params %+v", p)
+	}
+	if !p.settings.isExplicit("triton_input_width") || p.settings.isExplicit("triton_output_name") {
+		t.Error("only the profile's own values should be explicit for its model")
+	}
+	if p := yoloConfig(WithCamera(context.Background(), "cams/driveway", false)); p.format != YOLOFormatEnd2End || p.width != 640 {
+		t.Errorf("driveway params %+v", p)
+	}
+}
+
+func TestLoadCameraModelsErrors(t *testing.T) {
+	defer Config.Set("camera_models", nil)
+	for _, tt := range []struct {
+		model map[string]interface{}
+		want  string
+	}{
+		{map[string]interface{}{"model": "x"}, "no topics"},
+		{map[string]interface{}{"topics": []string{"a"}, "period": "dusk"}, "not day or night"},
+		{map[string]interface{}{"topics": []string{"a"}, "output_format": "yolov9"}, "unknown output_format"},
+	} {
+		Config.Set("camera_models", []map[string]interface{}{tt.model})
+		if err := LoadCameraModels(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: error %v, expected %q", tt.model, err, tt.want)
+		}
+	}
+}
+
+// multiModelServer serves several models, each with its own metadata.
+type multiModelServer struct {
+	metadataServer
+	metas map[string]*tritonpb.ModelMetadataResponse
+
+	mu     sync.Mutex
+	served map[string][]int64 // model name to the input shape it last received
+}
+
+func (s *multiModelServer) ModelReady(_ context.Context, req *tritonpb.ModelReadyRequest) (*tritonpb.ModelReadyResponse, error) {
+	return &tritonpb.ModelReadyResponse{Ready: s.metas[req.GetName()] != nil}, nil
+}
+
+func (s *multiModelServer) ModelMetadata(_ context.Context, req *tritonpb.ModelMetadataRequest) (*tritonpb.ModelMetadataResponse, error) {
+	return s.metas[req.GetName()], nil
+}
+
+func (s *multiModelServer) ModelInfer(ctx context.Context, req *tritonpb.ModelInferRequest) (*tritonpb.ModelInferResponse, error) {
+	s.mu.Lock()
+	s.served[req.GetModelName()] = req.GetInputs()[0].GetShape()
+	s.mu.Unlock()
+	return s.metadataServer.ModelInfer(ctx, req)
+}
+
+func TestCameraModelInference(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	ir := yoloMetadata("FP32", 32, 32)
+	ir.Name = "yolo11_ir"
+	s := &multiModelServer{
+		metadataServer: metadataServer{meta: yoloMetadata("FP32", 64, 64)},
+		metas:          map[string]*tritonpb.ModelMetadataResponse{"yolo11": yoloMetadata("FP32", 64, 64), "yolo11_ir": ir},
+		served:         make(map[string][]int64),
+	}
+	startMetadataServer(t, s)
+	setCameraModels(t, map[string]interface{}{"topics": []string{"cams/porch"}, "period": "night", "model": "yolo11_ir"})
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+
+	jpegData := testJPEG(t, 64, 64)
+	for _, night := range []bool{false, true} {
+		ctx := WithCamera(context.Background(), "cams/porch", night)
+		if dets, err := (tritonGRPCDetector{}).Detect(ctx, jpegData); err != nil || len(dets) != 1 {
+			t.Fatalf("night=%v: Detect = %+v, %v", night, dets, err)
+		}
+	}
+	if shape := s.served["yolo11"]; len(shape) != 4 || shape[3] != 64 {
+		t.Errorf("yolo11 received shape %v", shape)
+	}
+	if shape := s.served["yolo11_ir"]; len(shape) != 4 || shape[3] != 32 {
+		t.Errorf("yolo11_ir received shape %v", shape)
+	}
+	if n := len(tritonEndpoints.endpoints[0].models); n != 2 {
+		t.Errorf("endpoint holds %d model states, expected 2", n)
+	}
+}
@@ -0,0 +1,480 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	tritongprc "github.com/elijahnyp/home_controller/triton/generated"
+	"golang.org/x/image/draw"
+)
+
+// Two-stage cascade. With `cascade_model` set, each detection whose label is
+// in `cascade_labels` (default person) is cropped from the original image,
+// widened by `cascade_crop_padding` of its size on every side, and sent to a
+// secondary model; the `cascade_max_crops` most confident go in one request
+// per frame. Its answer is attached to the detection as Secondary:
+//
+//	classifier  the top class and its score, e.g. "resident" or "unknown";
+//	            labels come from labels_files or the model's Triton config
+//	embedding   the model output as an L2-normalised vector
+//
+// chosen with `cascade_output`. `cascade_backend` selects where the model
+// runs: "triton_grpc" (default) sends the crops, resized to
+// `cascade_input_width`×`cascade_input_height` and scaled to [0, 1], to the
+// Triton endpoints; any further normalisation belongs in the model. "stub"
+// labels every crop `cascade_stub_label`, or embeds it as its mean colour,
+// without running a model, for trying the pipeline without a GPU. Embeddings
+// are matched against the gallery of enrolled people (see gallery.go). A
+// failing secondary model leaves the detections as the primary model
+// returned them.
+
+const (
+	CascadeTritonGRPC = "triton_grpc"
+	CascadeStub       = "stub"
+
+	CascadeClassifier = "classifier"
+	CascadeEmbedding  = "embedding"
+
+	defaultCascadeInputSize = 224
+	defaultCascadeMaxCrops  = 8
+	defaultCascadePadding   = 0.1
+)
+
+// SecondaryResult is the secondary model's answer for one detection.
+type SecondaryResult struct {
+	Model      string    `json:"model"`
+	Label      string    `json:"label,omitempty"`
+	Confidence float32   `json:"confidence,omitempty"`
+	Embedding  []float32 `json:"embedding,omitempty"`
+	Person     string    `json:"person,omitempty"` // the enrolled person the embedding matches
+	Similarity float32   `json:"similarity,omitempty"`
+}
+
+// SecondaryModel classifies or embeds crops of detected objects, returning
+// one result per crop in order.
+type SecondaryModel interface {
+	Name() string
+	Run(ctx context.Context, crops []image.Image) ([]SecondaryResult, error)
+}
+
+type secondaryBox struct{ m SecondaryModel }
+
+var secondaryPtr atomic.Pointer[secondaryBox]
+
+// CurrentSecondaryModel returns the cascade's secondary model, or nil when
+// the cascade is off.
+func CurrentSecondaryModel() SecondaryModel {
+	if b := secondaryPtr.Load(); b != nil {
+		return b.m
+	}
+	return nil
+}
+
+// SetSecondaryModel replaces the secondary model; nil turns the cascade off.
+// Tests use it to inject fakes.
+func SetSecondaryModel(m SecondaryModel) {
+	secondaryPtr.Store(&secondaryBox{m: m})
+}
+
+// InitCascade builds the secondary model from the cascade settings.
+// InitDetector calls it.
+func InitCascade() error {
+	model := Config.GetString("cascade_model")
+	if model == "" {
+		SetSecondaryModel(nil)
+		return nil
+	}
+	output := Config.GetString("cascade_output")
+	if output == "" {
+		output = CascadeClassifier
+	}
+	if output != CascadeClassifier && output != CascadeEmbedding {
+		return fmt.Errorf("cascade: unknown cascade_output %q", output)
+	}
+	var m SecondaryModel
+	switch backend := Config.GetString("cascade_backend"); backend {
+	case "", CascadeTritonGRPC:
+		width, height := Config.GetInt("cascade_input_width"), Config.GetInt("cascade_input_height")
+		if width <= 0 {
+			width = defaultCascadeInputSize
+		}
+		if height <= 0 {
+			height = defaultCascadeInputSize
+		}
+		m = &tritonSecondary{
+			model:   model,
+			version: Config.GetString("cascade_model_version"),
+			output:  output,
+			width:   width,
+			height:  height,
+		}
+	case CascadeStub:
+		label := Config.GetString("cascade_stub_label")
+		if label == "" {
+			label = "unknown"
+		}
+		m = stubSecondary{model: model, label: label, output: output}
+	default:
+		return fmt.Errorf("cascade: unknown cascade_backend %q", backend)
+	}
+	SetSecondaryModel(m)
+	Logger.Info().Msgf("Cascading %v detections to %s (%s) on %s", cascadeLabels(), model, output, m.Name())
+	return nil
+}
+
+func cascadeLabels() []string {
+	if !Config.IsSet("cascade_labels") {
+		return []string{"person"}
+	}
+	return Config.GetStringSlice("cascade_labels")
+}
+
+// Cascade runs the secondary model on crops of the detections in dets that
+// cascade_labels names, attaching its results. dets is returned as it was
+// when the cascade is off or fails.
+func Cascade(ctx context.Context, jpegData []byte, dets []TritonDetection) []TritonDetection {
+	m := CurrentSecondaryModel()
+	if m == nil {
+		return dets
+	}
+	labels := cascadeLabels()
+	var picked []int
+	for i, d := range dets {
+		if slices.Contains(labels, d.Label) && d.XMax > d.XMin && d.YMax > d.YMin {
+			picked = append(picked, i)
+		}
+	}
+	if len(picked) == 0 {
+		return dets
+	}
+	sort.SliceStable(picked, func(a, b int) bool { return dets[picked[a]].Confidence > dets[picked[b]].Confidence })
+	maxCrops := defaultCascadeMaxCrops
+	if Config.IsSet("cascade_max_crops") {
+		maxCrops = Config.GetInt("cascade_max_crops")
+	}
+	if len(picked) > maxCrops {
+		picked = picked[:max(maxCrops, 0)]
+	}
+	if len(picked) == 0 {
+		return dets
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(jpegData))
+	if err != nil {
+		Logger.Debug().Msgf("cascade: %v", err)
+		return dets
+	}
+	padding := cascadePadding()
+	crops := make([]image.Image, len(picked))
+	for j, i := range picked {
+		var ok bool
+		if crops[j], ok = subImage(img, cropRect(dets[i], padding, img.Bounds())); !ok {
+			return dets
+		}
+	}
+	results, err := m.Run(ctx, crops)
+	if err != nil {
+		Logger.Warn().Msgf("cascade: %s: %v", m.Name(), err)
+		return dets
+	}
+	if len(results) != len(crops) {
+		Logger.Warn().Msgf("cascade: %s returned %d results for %d crops", m.Name(), len(results), len(crops))
+		return dets
+	}
+	out := slices.Clone(dets)
+	for j, i := range picked {
+		r := &results[j]
+		if len(r.Embedding) > 0 {
+			r.Person, r.Similarity, _ = MatchPerson(r.Model, r.Embedding)
+		}
+		out[i].Secondary = r
+	}
+	return out
+}
+
+func cascadePadding() float64 {
+	if Config.IsSet("cascade_crop_padding") {
+		return Config.GetFloat64("cascade_crop_padding")
+	}
+	return defaultCascadePadding
+}
+
+// subImage returns the part of img inside r, sharing its pixels.
+func subImage(img image.Image, r image.Rectangle) (image.Image, bool) {
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, false
+	}
+	return sub.SubImage(r), true
+}
+
+// cropRect is the box of d widened by padding of its size on each side and
+// clipped to bounds.
+func cropRect(d TritonDetection, padding float64, bounds image.Rectangle) image.Rectangle {
+	padX := int(math.Round(float64(d.XMax-d.XMin) * padding))
+	padY := int(math.Round(float64(d.YMax-d.YMin) * padding))
+	return image.Rect(d.XMin-padX, d.YMin-padY, d.XMax+padX, d.YMax+padY).Intersect(bounds)
+}
+
+// stubSecondary gives every crop the same label or, as an embedding model,
+// embeds each crop as its mean colour.
+type stubSecondary struct {
+	model  string
+	label  string
+	output string
+}
+
+func (stubSecondary) Name() string { return CascadeStub }
+
+func (s stubSecondary) Run(_ context.Context, crops []image.Image) ([]SecondaryResult, error) {
+	results := make([]SecondaryResult, len(crops))
+	for i, crop := range crops {
+		if s.output == CascadeEmbedding {
+			results[i] = SecondaryResult{Model: s.model, Embedding: l2Normalize(meanColour(crop))}
+			continue
+		}
+		results[i] = SecondaryResult{Model: s.model, Label: s.label, Confidence: 1}
+	}
+	return results, nil
+}
+
+// meanColour is the average red, green and blue of img.
+func meanColour(img image.Image) []float32 {
+	var sum [3]float64
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum[0], sum[1], sum[2] = sum[0]+float64(r), sum[1]+float64(g), sum[2]+float64(bl)
+		}
+	}
+	n := float64(max(b.Dx()*b.Dy(), 1))
+	return []float32{float32(sum[0] / n), float32(sum[1] / n), float32(sum[2] / n)}
+}
+
+// tritonSecondary runs the secondary model on the Triton endpoints.
+type tritonSecondary struct {
+	model   string
+	version string
+	output  string
+	width   int
+	height  int
+
+	mu   sync.Mutex
+	meta *secondaryMeta // read from the server on first use
+}
+
+// secondaryMeta is what the secondary model's metadata says about its
+// tensors.
+type secondaryMeta struct {
+	input    string
+	datatype string
+	output   string
+	width    int
+	height   int
+	labels   []string
+	batchDim bool // the input has a leading batch dimension
+	maxBatch int  // crops per request; 0 for no limit
+}
+
+func (*tritonSecondary) Name() string { return CascadeTritonGRPC }
+
+func (s *tritonSecondary) Run(ctx context.Context, crops []image.Image) ([]SecondaryResult, error) {
+	tp := tritonEndpoints
+	if tp == nil {
+		return nil, fmt.Errorf("triton client not initialized")
+	}
+	meta, err := s.metadata(ctx, tp)
+	if err != nil {
+		return nil, err
+	}
+	batch := len(crops)
+	if meta.maxBatch > 0 {
+		batch = meta.maxBatch
+	}
+	results := make([]SecondaryResult, 0, len(crops))
+	for start := 0; start < len(crops); start += batch {
+		r, err := s.infer(ctx, tp, meta, crops[start:min(start+batch, len(crops))])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r...)
+	}
+	return results, nil
+}
+
+// infer runs one request for at most meta.maxBatch crops.
+func (s *tritonSecondary) infer(ctx context.Context, tp *tritonPool, meta *secondaryMeta, crops []image.Image) ([]SecondaryResult, error) {
+	size := inputElementSize(meta.datatype)
+	per := 3 * meta.width * meta.height * size
+	tensor := getTensor(per * len(crops))
+	defer releaseTensor(tensor)
+	canvas := getCanvas(meta.width, meta.height)
+	for i, crop := range crops {
+		draw.ApproxBiLinear.Scale(canvas, canvas.Rect, crop, crop.Bounds(), draw.Src, nil)
+		writeNCHW(tensor[i*per:(i+1)*per], canvas, meta.datatype)
+	}
+	canvasPool.Put(canvas)
+
+	shape := []int64{3, int64(meta.height), int64(meta.width)}
+	if meta.batchDim {
+		shape = append([]int64{int64(len(crops))}, shape...)
+	}
+	req := &tritongprc.ModelInferRequest{
+		ModelName:    s.model,
+		ModelVersion: s.version,
+		Inputs: []*tritongprc.ModelInferRequest_InferInputTensor{{
+			Name:     meta.input,
+			Datatype: meta.datatype,
+			Shape:    shape,
+		}},
+		Outputs:          []*tritongprc.ModelInferRequest_InferRequestedOutputTensor{{Name: meta.output}},
+		RawInputContents: [][]byte{tensor},
+	}
+	var resp *tritongprc.ModelInferResponse
+	err := tp.do(ctx, nil, func(tc *TritonClient) error {
+		var err error
+		resp, err = tc.client.ModelInfer(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("triton: ModelInfer RPC: %w", err)
+	}
+	if len(resp.Outputs) == 0 || len(resp.RawOutputContents) == 0 {
+		return nil, fmt.Errorf("triton: empty response outputs")
+	}
+	o := resp.Outputs[0]
+	data, err := tensorToFloat32(o.Datatype, resp.RawOutputContents[0])
+	if err != nil {
+		return nil, fmt.Errorf("triton: parse output %s: %w", o.Name, err)
+	}
+	split, err := splitBatch(outputTensor{name: o.Name, shape: o.Shape, data: data}, len(crops))
+	if err != nil {
+		return nil, fmt.Errorf("triton: %w", err)
+	}
+	results := make([]SecondaryResult, len(crops))
+	for i, t := range split {
+		results[i] = SecondaryResult{Model: s.model}
+		if s.output == CascadeEmbedding {
+			results[i].Embedding = l2Normalize(t.data)
+			continue
+		}
+		idx, score := topClass(t.data)
+		results[i].Label, results[i].Confidence = labelFor(meta.labels, idx), score
+	}
+	return results, nil
+}
+
+// metadata reads the model's first input and output from whichever endpoint
+// answers, once.
+func (s *tritonSecondary) metadata(ctx context.Context, tp *tritonPool) (*secondaryMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.meta != nil {
+		return s.meta, nil
+	}
+	var meta *tritongprc.ModelMetadataResponse
+	var cfg *tritongprc.ModelConfig
+	err := tp.do(ctx, nil, func(tc *TritonClient) error {
+		var err error
+		if meta, err = tc.client.ModelMetadata(ctx, &tritongprc.ModelMetadataRequest{Name: s.model, Version: s.version}); err != nil {
+			return err
+		}
+		if resp, err := tc.client.ModelConfig(ctx, &tritongprc.ModelConfigRequest{Name: s.model, Version: s.version}); err == nil {
+			cfg = resp.GetConfig()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("triton: ModelMetadata for %s: %w", s.model, err)
+	}
+	if len(meta.GetInputs()) == 0 || len(meta.GetOutputs()) == 0 {
+		return nil, fmt.Errorf("triton: model %s has no inputs or outputs", s.model)
+	}
+	in := meta.Inputs[0]
+	m := &secondaryMeta{
+		input:    in.GetName(),
+		datatype: in.GetDatatype(),
+		output:   meta.Outputs[0].GetName(),
+		width:    s.width,
+		height:   s.height,
+	}
+	if inputElementSize(m.datatype) == 0 || m.datatype == InputBYTES {
+		return nil, fmt.Errorf("triton: model %s input %s has unsupported datatype %s", s.model, m.input, m.datatype)
+	}
+	// A fixed [N,] 3, H, W input overrides the configured size.
+	shape := in.GetShape()
+	if len(shape) >= 3 && shape[len(shape)-2] > 0 && shape[len(shape)-1] > 0 {
+		m.height, m.width = int(shape[len(shape)-2]), int(shape[len(shape)-1])
+	}
+	// Models with max_batch_size 0 take one crop per request without a
+	// batch dimension unless their own shape has one, which may be fixed.
+	switch m.batchDim = len(shape) == 4; {
+	case !m.batchDim:
+		m.maxBatch = 1
+	case cfg.GetMaxBatchSize() > 0:
+		m.maxBatch = int(cfg.GetMaxBatchSize())
+	case shape[0] > 0:
+		m.maxBatch = int(shape[0])
+	}
+	if m.labels = ModelLabels(s.model); m.labels == nil {
+		m.labels = tritonConfigLabels(cfg, m.output)
+	}
+	Logger.Info().Msgf("Cascade model %s: input %s %s %dx%d, output %s, max batch %d", s.model, m.input, m.datatype, m.width, m.height, m.output, m.maxBatch)
+	s.meta = m
+	return m, nil
+}
+
+// topClass returns the index and probability of the highest score. Scores
+// that are not already probabilities are taken to be logits.
+func topClass(scores []float32) (int, float32) {
+	if len(scores) == 0 {
+		return -1, 0
+	}
+	best := 0
+	var sum float64
+	probabilities := true
+	for i, v := range scores {
+		if v > scores[best] {
+			best = i
+		}
+		if v < 0 || v > 1 {
+			probabilities = false
+		}
+		sum += float64(v)
+	}
+	if probabilities && math.Abs(sum-1) < 0.01 {
+		return best, scores[best]
+	}
+	var denom float64
+	for _, v := range scores {
+		denom += math.Exp(float64(v - scores[best]))
+	}
+	return best, float32(1 / denom)
+}
+
+// l2Normalize returns v scaled to unit length.
+func l2Normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if sum == 0 {
+		return out
+	}
+	norm := float32(math.Sqrt(sum))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
@@ -0,0 +1,250 @@
+package util
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+// cropRecorder is a secondary model that remembers the crops it was given.
+type cropRecorder struct {
+	crops []image.Rectangle
+}
+
+func (*cropRecorder) Name() string { return "recorder" }
+
+func (c *cropRecorder) Run(_ context.Context, crops []image.Image) ([]SecondaryResult, error) {
+	results := make([]SecondaryResult, len(crops))
+	for i, crop := range crops {
+		c.crops = append(c.crops, crop.Bounds())
+		results[i] = SecondaryResult{Model: "recorder", Label: "resident", Confidence: 0.8}
+	}
+	return results, nil
+}
+
+func TestCascadeStub(t *testing.T) {
+	Config.Set("cascade_model", "reid")
+	Config.Set("cascade_backend", CascadeStub)
+	defer Config.Set("cascade_model", nil)
+	defer Config.Set("cascade_backend", nil)
+	defer SetSecondaryModel(nil)
+	if err := InitCascade(); err != nil {
+		t.Fatalf("InitCascade: %v", err)
+	}
+
+	dets := []TritonDetection{
+		{Label: "person", Confidence: 0.9, XMin: 10, YMin: 10, XMax: 30, YMax: 60},
+		{Label: "car", Confidence: 0.9, XMin: 0, YMin: 0, XMax: 20, YMax: 20},
+	}
+	out := Cascade(context.Background(), testJPEG(t, 64, 64), dets)
+	if out[0].Secondary == nil || out[0].Secondary.Label != "unknown" || out[0].Secondary.Model != "reid" {
+		t.Errorf("person secondary %+v", out[0].Secondary)
+	}
+	if out[1].Secondary != nil {
+		t.Errorf("car was cascaded: %+v", out[1].Secondary)
+	}
+	if dets[0].Secondary != nil {
+		t.Error("Cascade modified its input")
+	}
+
+	Config.Set("cascade_backend", "magic")
+	if err := InitCascade(); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
+
+func TestCascadeCrops(t *testing.T) {
+	rec := &cropRecorder{}
+	SetSecondaryModel(rec)
+	defer SetSecondaryModel(nil)
+	Config.Set("cascade_max_crops", 2)
+	defer Config.Set("cascade_max_crops", nil)
+
+	dets := []TritonDetection{
+		{Label: "person", Confidence: 0.5, XMin: 0, YMin: 0, XMax: 10, YMax: 10},
+		{Label: "person", Confidence: 0.9, XMin: 50, YMin: 20, XMax: 60, YMax: 40},
+		{Label: "person", Confidence: 0.7, XMin: 20, YMin: 20, XMax: 40, YMax: 40},
+		{Label: "person", Confidence: 0.95}, // an empty box
+	}
+	out := Cascade(context.Background(), testJPEG(t, 64, 64), dets)
+	// The two most confident real boxes, padded by 10% and clipped.
+	want := []image.Rectangle{image.Rect(49, 18, 61, 42), image.Rect(18, 18, 42, 42)}
+	if len(rec.crops) != 2 || rec.crops[0] != want[0] || rec.crops[1] != want[1] {
+		t.Errorf("crops %v, expected %v", rec.crops, want)
+	}
+	if out[0].Secondary != nil || out[1].Secondary == nil || out[2].Secondary == nil || out[3].Secondary != nil {
+		t.Errorf("secondaries attached to the wrong detections: %+v", out)
+	}
+	if got := cropRect(TritonDetection{XMin: 0, YMin: 0, XMax: 64, YMax: 64}, 0.1, image.Rect(0, 0, 64, 64)); got != image.Rect(0, 0, 64, 64) {
+		t.Errorf("crop not clipped: %v", got)
+	}
+}
+
+// classifierServer serves a three-class model with a 32×16 input whose
+// answer for the i-th crop of a request is class i%3. Its input shape is
+// [-1, 3, 32, 16] unless input is set, and requests that do not fit it are
+// refused, as Triton does.
+type classifierServer struct {
+	tritonpb.UnimplementedGRPCInferenceServiceServer
+	input    []int64
+	maxBatch int32
+	shape    atomic.Pointer[[]int64]
+	requests atomic.Int32
+}
+
+func (s *classifierServer) inputShape() []int64 {
+	if s.input != nil {
+		return s.input
+	}
+	return []int64{-1, 3, 32, 16}
+}
+
+func (s *classifierServer) ModelMetadata(context.Context, *tritonpb.ModelMetadataRequest) (*tritonpb.ModelMetadataResponse, error) {
+	return &tritonpb.ModelMetadataResponse{
+		Name:    "reid",
+		Inputs:  []*tritonpb.ModelMetadataResponse_TensorMetadata{{Name: "input", Datatype: "FP32", Shape: s.inputShape()}},
+		Outputs: []*tritonpb.ModelMetadataResponse_TensorMetadata{{Name: "logits", Datatype: "FP32", Shape: []int64{-1, 3}}},
+	}, nil
+}
+
+func (s *classifierServer) ModelConfig(context.Context, *tritonpb.ModelConfigRequest) (*tritonpb.ModelConfigResponse, error) {
+	return &tritonpb.ModelConfigResponse{Config: &tritonpb.ModelConfig{
+		MaxBatchSize: s.maxBatch,
+		Parameters:   map[string]*tritonpb.ModelParameter{"labels": {StringValue: `["resident", "guest", "unknown"]`}},
+	}}, nil
+}
+
+func (s *classifierServer) ModelInfer(_ context.Context, req *tritonpb.ModelInferRequest) (*tritonpb.ModelInferResponse, error) {
+	s.requests.Add(1)
+	shape := req.GetInputs()[0].GetShape()
+	s.shape.Store(&shape)
+	want := s.inputShape()
+	if len(shape) != len(want) {
+		return nil, fmt.Errorf("input shape %v, expected %v", shape, want)
+	}
+	for i, d := range want {
+		if d > 0 && shape[i] != d {
+			return nil, fmt.Errorf("input shape %v, expected %v", shape, want)
+		}
+	}
+	n, outShape := int64(1), []int64{3}
+	if len(shape) == 4 {
+		n = shape[0]
+		outShape = []int64{n, 3}
+	}
+	if s.maxBatch > 0 && n > int64(s.maxBatch) {
+		return nil, fmt.Errorf("batch %d over max_batch_size %d", n, s.maxBatch)
+	}
+	var raw []byte
+	for i := range n {
+		for c := range int64(3) {
+			v := float32(-2)
+			if c == i%3 {
+				v = 2
+			}
+			raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(v))
+		}
+	}
+	return &tritonpb.ModelInferResponse{
+		Outputs:           []*tritonpb.ModelInferResponse_InferOutputTensor{{Name: "logits", Datatype: "FP32", Shape: outShape}},
+		RawOutputContents: [][]byte{raw},
+	}, nil
+}
+
+func TestTritonSecondary(t *testing.T) {
+	s := &classifierServer{}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	crops := []image.Image{img.SubImage(image.Rect(0, 0, 20, 40)), img.SubImage(image.Rect(30, 10, 50, 60))}
+
+	m := &tritonSecondary{model: "reid", output: CascadeClassifier, width: 224, height: 224}
+	results, err := m.Run(context.Background(), crops)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if shape := *s.shape.Load(); len(shape) != 4 || shape[0] != 2 || shape[2] != 32 || shape[3] != 16 {
+		t.Errorf("input shape %v, expected [2 3 32 16]", shape)
+	}
+	if len(results) != 2 || results[0].Label != "resident" || results[1].Label != "guest" {
+		t.Fatalf("results %+v", results)
+	}
+	if c := results[0].Confidence; c < 0.96 || c > 0.97 {
+		t.Errorf("softmax confidence %v, expected about 0.965", c)
+	}
+
+	m = &tritonSecondary{model: "reid", output: CascadeEmbedding, width: 224, height: 224}
+	results, err = m.Run(context.Background(), crops[:1])
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if e := results[0].Embedding; len(e) != 3 || math.Abs(float64(e[0])-2/math.Sqrt(12)) > 1e-6 {
+		t.Errorf("embedding %v", e)
+	}
+}
+
+func TestTritonSecondaryBatchLimit(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	crops := []image.Image{
+		img.SubImage(image.Rect(0, 0, 20, 40)),
+		img.SubImage(image.Rect(30, 10, 50, 60)),
+		img.SubImage(image.Rect(10, 10, 40, 40)),
+	}
+	for _, tt := range []struct {
+		name     string
+		server   *classifierServer
+		requests int32
+		shape    []int64
+		labels   []string
+	}{
+		{"fixed batch of 1", &classifierServer{input: []int64{1, 3, 32, 16}}, 3, []int64{1, 3, 32, 16}, []string{"resident", "resident", "resident"}},
+		{"no batch dimension", &classifierServer{input: []int64{3, 32, 16}}, 3, []int64{3, 32, 16}, []string{"resident", "resident", "resident"}},
+		{"max_batch_size 2", &classifierServer{maxBatch: 2}, 2, []int64{1, 3, 32, 16}, []string{"resident", "guest", "resident"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			startMetadataServer(t, tt.server)
+			if err := InitTritonClient(); err != nil {
+				t.Fatalf("InitTritonClient: %v", err)
+			}
+			m := &tritonSecondary{model: "reid", output: CascadeClassifier, width: 224, height: 224}
+			results, err := m.Run(context.Background(), crops)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if n := tt.server.requests.Load(); n != tt.requests {
+				t.Errorf("%d requests, expected %d", n, tt.requests)
+			}
+			if shape := *tt.server.shape.Load(); !slices.Equal(shape, tt.shape) {
+				t.Errorf("last input shape %v, expected %v", shape, tt.shape)
+			}
+			var labels []string
+			for _, r := range results {
+				labels = append(labels, r.Label)
+			}
+			if !slices.Equal(labels, tt.labels) {
+				t.Errorf("labels %v, expected %v", labels, tt.labels)
+			}
+		})
+	}
+}
+
+func TestTopClass(t *testing.T) {
+	if idx, p := topClass([]float32{0.1, 0.7, 0.2}); idx != 1 || p != 0.7 {
+		t.Errorf("probabilities: %d %v", idx, p)
+	}
+	if idx, p := topClass([]float32{0, 0}); idx != 0 || p != 0.5 {
+		t.Errorf("equal logits: %d %v", idx, p)
+	}
+	if idx, _ := topClass(nil); idx != -1 {
+		t.Errorf("no scores: %d", idx)
+	}
+}
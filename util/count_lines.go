@@ -0,0 +1,100 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"slices"
+)
+
+// Count lines. A room's count_lines are virtual lines across its camera
+// pictures, such as across the kitchen doorway, in image pixels:
+//
+//	"count_lines": [
+//	  {"name": "doorway", "topics": ["esp-cam/kitchen/image"], "from": [400, 650], "to": [700, 650], "in_side": "left"}
+//	]
+//
+// A confirmed track crosses a line when the bottom centre of its box moves
+// from one side of the segment to the other between sightings. Ending up on
+// in_side, "left" (default) or "right" of from→to as seen in the picture,
+// counts as going into the room. Only labels (default ["person"]) are
+// counted, and lines without topics apply to all of the room's cameras.
+
+// CountLine is one line across a room's camera pictures.
+type CountLine struct {
+	Name    string   `mapstructure:"name"`
+	Topics  []string `mapstructure:"topics"`
+	From    []int    `mapstructure:"from"` // [x, y]
+	To      []int    `mapstructure:"to"`
+	In_side string   `mapstructure:"in_side"`
+	Labels  []string `mapstructure:"labels"`
+}
+
+const (
+	CrossIn  = "in"
+	CrossOut = "out"
+
+	lineSideLeft  = "left"
+	lineSideRight = "right"
+)
+
+func (l CountLine) validate() error {
+	switch {
+	case l.Name == "":
+		return fmt.Errorf("count line has no name")
+	case len(l.From) != 2 || len(l.To) != 2:
+		return fmt.Errorf("count line %s: from and to must be [x, y]", l.Name)
+	case l.From[0] == l.To[0] && l.From[1] == l.To[1]:
+		return fmt.Errorf("count line %s: from and to are the same point", l.Name)
+	case l.In_side != "" && l.In_side != lineSideLeft && l.In_side != lineSideRight:
+		return fmt.Errorf("count line %s: in_side %q is not left or right", l.Name, l.In_side)
+	}
+	return nil
+}
+
+// AppliesTo reports whether the line is drawn on pictures from topic.
+func (l CountLine) AppliesTo(topic string) bool {
+	return topicsMatch(l.Topics, topic)
+}
+
+// Crossing returns CrossIn or CrossOut when the move crosses the line, and ""
+// otherwise.
+func (l CountLine) Crossing(m TrackMove) string {
+	labels := l.Labels
+	if len(labels) == 0 {
+		labels = []string{"person"}
+	}
+	if !slices.Contains(labels, m.Label) {
+		return ""
+	}
+	a, b := image.Pt(l.From[0], l.From[1]), image.Pt(l.To[0], l.To[1])
+	before, after := side(a, b, m.From), side(a, b, m.To)
+	// Both ends of the move must be strictly either side of the line, and
+	// the line's ends either side of the move.
+	if before == 0 || after == 0 || before == after || side(m.From, m.To, a) == side(m.From, m.To, b) {
+		return ""
+	}
+	// In picture coordinates, with y down, left of a→b is negative.
+	if (after < 0) == (l.In_side != lineSideRight) {
+		return CrossIn
+	}
+	return CrossOut
+}
+
+// side is the sign of the cross product (b-a)×(p-a): which side of the line
+// through a and b p is on.
+func side(a, b, p image.Point) int {
+	c := (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+	switch {
+	case c > 0:
+		return 1
+	case c < 0:
+		return -1
+	}
+	return 0
+}
+
+// topicsMatch reports whether topic matches one of filters, or filters is
+// empty.
+func topicsMatch(filters []string, topic string) bool {
+	return len(filters) == 0 || slices.ContainsFunc(filters, func(f string) bool { return topicMatches(f, topic) })
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"image"
+	"testing"
+)
+
+func TestCountLineCrossing(t *testing.T) {
+	// A doorway along y=100 from x=0 to x=200; below it, on screen, is the
+	// right of from→to.
+	door := CountLine{Name: "door", From: []int{0, 100}, To: []int{200, 100}}
+	move := func(label string, from, to image.Point) TrackMove {
+		return TrackMove{Track: Track{ID: 1, Label: label}, From: from, To: to}
+	}
+	for _, tt := range []struct {
+		name string
+		line CountLine
+		move TrackMove
+		want string
+	}{
+		{"up, to the left", door, move("person", image.Pt(50, 150), image.Pt(60, 50)), CrossIn},
+		{"down, to the right", door, move("person", image.Pt(50, 50), image.Pt(60, 150)), CrossOut},
+		{"in_side right", CountLine{Name: "door", From: door.From, To: door.To, In_side: "right"},
+			move("person", image.Pt(50, 50), image.Pt(60, 150)), CrossIn},
+		{"same side", door, move("person", image.Pt(50, 150), image.Pt(60, 110)), ""},
+		{"onto the line", door, move("person", image.Pt(50, 150), image.Pt(60, 100)), ""},
+		{"past the end", door, move("person", image.Pt(250, 150), image.Pt(260, 50)), ""},
+		{"a cat", door, move("cat", image.Pt(50, 150), image.Pt(60, 50)), ""},
+		{"counting cats", CountLine{Name: "flap", From: door.From, To: door.To, Labels: []string{"cat"}},
+			move("cat", image.Pt(50, 150), image.Pt(60, 50)), CrossIn},
+	} {
+		if got := tt.line.Crossing(tt.move); got != tt.want {
+			t.Errorf("%s: Crossing = %q, expected %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCountLineValidate(t *testing.T) {
+	ok := CountLine{Name: "door", Topics: []string{"cams/+/image"}, From: []int{0, 0}, To: []int{10, 0}}
+	if err := ok.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+	if !ok.AppliesTo("cams/kitchen/image") || ok.AppliesTo("cams/kitchen/thumb") {
+		t.Error("topic filter not applied")
+	}
+	for _, l := range []CountLine{
+		{From: ok.From, To: ok.To},
+		{Name: "short", From: []int{0}, To: ok.To},
+		{Name: "point", From: ok.From, To: ok.From},
+		{Name: "side", From: ok.From, To: ok.To, In_side: "up"},
+	} {
+		if err := l.validate(); err == nil {
+			t.Errorf("%+v validated", l)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Detector runs object detection on a JPEG image. Backends are selected with
+// the `detector_backend` config:
+//
+//	triton_grpc  Triton Inference Server over gRPC (default)
+//	triton_http  Triton / KServe v2 REST inference protocol
+//	deepstack    DeepStack / CodeProject.AI /v1/vision/detection
+type Detector interface {
+	Name() string
+	Detect(ctx context.Context, jpegData []byte) ([]TritonDetection, error)
+}
+
+const (
+	DetectorTritonGRPC = "triton_grpc"
+	DetectorTritonHTTP = "triton_http"
+	DetectorDeepStack  = "deepstack"
+
+	defaultDetectorTimeout = 15 * time.Second
+)
+
+type detectorBox struct{ d Detector }
+
+var detectorPtr atomic.Pointer[detectorBox]
+
+// CurrentDetector returns the active detector. Before InitDetector has run it
+// is the Triton gRPC detector, which fails until InitTritonClient succeeds.
+func CurrentDetector() Detector {
+	if b := detectorPtr.Load(); b != nil {
+		return b.d
+	}
+	return tritonGRPCDetector{}
+}
+
+// SetDetector replaces the active detector; tests use it to inject fakes.
+func SetDetector(d Detector) {
+	detectorPtr.Store(&detectorBox{d: d})
+}
+
+// InitDetector builds the detector named by `detector_backend`, wraps it in a
+// circuit breaker and makes it the active one. Call it at startup and
+// whenever config changes.
+func InitDetector() error {
+	ResetLabelsCache()
+	if err := LoadCameraModels(); err != nil {
+		return err
+	}
+	if err := InitCascade(); err != nil {
+		return err
+	}
+	if err := LoadGallery(); err != nil {
+		return err
+	}
+	backend := Config.GetString("detector_backend")
+	var d Detector
+	switch backend {
+	case "", DetectorTritonGRPC:
+		if err := InitTritonClient(); err != nil {
+			return err
+		}
+		d = tritonGRPCDetector{}
+		if size := Config.GetInt("inference_batch_size"); size > 1 {
+			delay := time.Duration(Config.GetInt("inference_batch_delay_ms")) * time.Millisecond
+			d = newBatchingDetector(size, delay)
+			Logger.Info().Msgf("Batching up to %d images per inference, waiting at most %v", size, delay)
+		}
+	case DetectorTritonHTTP:
+		url := Config.GetString("triton_http_url")
+		if url == "" {
+			return fmt.Errorf("detector: triton_http_url is required for the %s backend", backend)
+		}
+		d = newTritonHTTPDetector(url)
+	case DetectorDeepStack:
+		url := Config.GetString("deepstack_url")
+		if url == "" {
+			return fmt.Errorf("detector: deepstack_url is required for the %s backend", backend)
+		}
+		d = newDeepStackDetector(url)
+	default:
+		return fmt.Errorf("detector: unknown detector_backend %q", backend)
+	}
+	b := newCircuitBreaker(d)
+	old := CurrentDetector()
+	SetDetector(b)
+	if c, ok := old.(interface{ Close() }); ok {
+		c.Close()
+	}
+	Logger.Info().Msgf("Object detection using %s backend", d.Name())
+	notifyDetectorHealth(b.Health())
+	return nil
+}
+
+// DetectorTimeout is the deadline for a single detection request, from the
+// `detector_timeout` config in seconds.
+func DetectorTimeout() time.Duration {
+	if t := Config.GetInt64("detector_timeout"); t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	return defaultDetectorTimeout
+}
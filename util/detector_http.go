@@ -0,0 +1,291 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---- Triton / KServe v2 REST ----------------------------------------------
+
+// tritonHTTPDetector speaks the KServe v2 inference protocol. With
+// `triton_http_binary` (the default) tensors travel using Triton's binary data
+// extension; turn it off for servers that only accept JSON tensors.
+type tritonHTTPDetector struct {
+	baseURL  string
+	endpoint string // metrics label
+	client   *http.Client
+}
+
+func newTritonHTTPDetector(baseURL string) *tritonHTTPDetector {
+	return &tritonHTTPDetector{baseURL: strings.TrimRight(baseURL, "/"), endpoint: endpointLabel(baseURL), client: http.DefaultClient}
+}
+
+func (d *tritonHTTPDetector) Name() string { return DetectorTritonHTTP }
+
+type kserveTensor struct {
+	Name       string                 `json:"name"`
+	Shape      []int64                `json:"shape,omitempty"`
+	Datatype   string                 `json:"datatype,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Data       []float32              `json:"data,omitempty"`
+}
+
+type kserveRequest struct {
+	Inputs  []kserveTensor `json:"inputs"`
+	Outputs []kserveTensor `json:"outputs"`
+}
+
+type kserveResponse struct {
+	ModelName string         `json:"model_name"`
+	Outputs   []kserveTensor `json:"outputs"`
+	Error     string         `json:"error"`
+}
+
+const inferHeaderLength = "Inference-Header-Content-Length"
+
+// endpointLabel is the host:port of a server URL, identifying it in metrics.
+func endpointLabel(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+func (d *tritonHTTPDetector) inferURL(p yoloParams) string {
+	u := d.baseURL + "/v2/models/" + p.model
+	if p.version != "" {
+		u += "/versions/" + p.version
+	}
+	return u + "/infer"
+}
+
+func (d *tritonHTTPDetector) Detect(ctx context.Context, jpegData []byte) ([]TritonDetection, error) {
+	p := yoloConfig(ctx)
+	rawBytes, lb, err := prepareInput(jpegData, p)
+	if err != nil {
+		return nil, fmt.Errorf("triton http: %w", err)
+	}
+	defer releaseTensor(rawBytes)
+	binaryData := !Config.IsSet("triton_http_binary") || Config.GetBool("triton_http_binary")
+	if p.jpeg && !binaryData {
+		return nil, fmt.Errorf("triton http: jpeg input for %s needs triton_http_binary", p.model)
+	}
+
+	input := kserveTensor{
+		Name:     p.inputName,
+		Shape:    p.inputShape([][]byte{rawBytes}),
+		Datatype: p.datatype,
+	}
+	outputs := make([]kserveTensor, len(p.outputs))
+	for i, name := range p.outputs {
+		outputs[i] = kserveTensor{Name: name}
+	}
+	if binaryData {
+		input.Parameters = map[string]interface{}{"binary_data_size": len(rawBytes)}
+		for i := range outputs {
+			outputs[i].Parameters = map[string]interface{}{"binary_data": true}
+		}
+	} else {
+		input.Data, err = tensorToFloat32(p.datatype, rawBytes)
+		if err != nil {
+			return nil, fmt.Errorf("triton http: %w", err)
+		}
+	}
+	header, err := json.Marshal(kserveRequest{Inputs: []kserveTensor{input}, Outputs: outputs})
+	if err != nil {
+		return nil, fmt.Errorf("triton http: encode request: %w", err)
+	}
+	body := header
+	if binaryData {
+		body = make([]byte, 0, len(header)+len(rawBytes))
+		body = append(append(body, header...), rawBytes...)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.inferURL(p), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("triton http: %w", err)
+	}
+	if binaryData {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(inferHeaderLength, strconv.Itoa(len(header)))
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	inferStart := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		RecordDetection(p.model, d.endpoint, "error", time.Since(inferStart))
+		return nil, fmt.Errorf("triton http: infer request: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		RecordDetection(p.model, d.endpoint, "error", time.Since(inferStart))
+		return nil, fmt.Errorf("triton http: read response: %w", err)
+	}
+	tensors, err := parseKServeResponse(resp, respBody, p.outputs)
+	if err != nil {
+		RecordDetection(p.model, d.endpoint, "error", time.Since(inferStart))
+		return nil, fmt.Errorf("triton http: %w", err)
+	}
+	RecordDetection(p.model, d.endpoint, "ok", time.Since(inferStart))
+
+	dets, err := decodeYOLOOutputs(p.format, tensors, lb, detectionLabels(p.model, nil), p.minConf, p.iou)
+	if err != nil {
+		return nil, fmt.Errorf("triton http: %w", err)
+	}
+	return dets, nil
+}
+
+// parseKServeResponse extracts the named output tensors from an infer
+// response, whether they came back as JSON data or as appended binary data.
+func parseKServeResponse(resp *http.Response, body []byte, names []string) ([]outputTensor, error) {
+	header := body
+	var binaryPart []byte
+	if hl := resp.Header.Get(inferHeaderLength); hl != "" {
+		n, err := strconv.Atoi(hl)
+		if err != nil || n < 0 || n > len(body) {
+			return nil, fmt.Errorf("invalid %s %q", inferHeaderLength, hl)
+		}
+		header, binaryPart = body[:n], body[n:]
+	}
+	var r kserveResponse
+	if err := json.Unmarshal(header, &r); err != nil {
+		return nil, fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, r.Error)
+	}
+
+	byName := make(map[string]outputTensor)
+	offset := 0
+	for _, out := range r.Outputs {
+		t := outputTensor{name: out.Name, shape: out.Shape, data: out.Data}
+		if v, ok := out.Parameters["binary_data_size"].(float64); ok {
+			size := int(v)
+			if offset+size > len(binaryPart) {
+				return nil, fmt.Errorf("output %s binary data truncated", out.Name)
+			}
+			data, err := tensorToFloat32(out.Datatype, binaryPart[offset:offset+size])
+			if err != nil {
+				return nil, fmt.Errorf("parse output %s: %w", out.Name, err)
+			}
+			t.data = data
+			offset += size
+		}
+		byName[out.Name] = t
+	}
+	tensors := make([]outputTensor, 0, len(names))
+	for _, name := range names {
+		t, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("response has no output %s", name)
+		}
+		tensors = append(tensors, t)
+	}
+	return tensors, nil
+}
+
+// ---- DeepStack / CodeProject.AI -------------------------------------------
+
+// deepStackDetector posts the image to a DeepStack-compatible
+// /v1/vision/detection endpoint, which does its own pre- and post-processing.
+type deepStackDetector struct {
+	url      string
+	endpoint string // metrics label
+	client   *http.Client
+}
+
+func newDeepStackDetector(rawURL string) *deepStackDetector {
+	return &deepStackDetector{url: rawURL, endpoint: endpointLabel(rawURL), client: http.DefaultClient}
+}
+
+func (d *deepStackDetector) Name() string { return DetectorDeepStack }
+
+type deepStackPrediction struct {
+	Label      string  `json:"label"`
+	Confidence float32 `json:"confidence"`
+	Y_min      int     `json:"y_min"`
+	X_min      int     `json:"x_min"`
+	X_max      int     `json:"x_max"`
+	Y_max      int     `json:"y_max"`
+}
+
+type deepStackResponse struct {
+	Success     bool                  `json:"success"`
+	Error       string                `json:"error"`
+	Predictions []deepStackPrediction `json:"predictions"`
+}
+
+func (d *deepStackDetector) Detect(ctx context.Context, jpegData []byte) ([]TritonDetection, error) {
+	minConf := Config.GetFloat64("min_confidence")
+	if minConf <= 0 {
+		minConf = 0.5
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("image", "image.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("deepstack: %w", err)
+	}
+	if _, err := part.Write(jpegData); err != nil {
+		return nil, fmt.Errorf("deepstack: %w", err)
+	}
+	if err := mw.WriteField("min_confidence", strconv.FormatFloat(minConf, 'f', -1, 64)); err != nil {
+		return nil, fmt.Errorf("deepstack: %w", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("deepstack: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("deepstack: %w", err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	inferStart := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		RecordDetection(DetectorDeepStack, d.endpoint, "error", time.Since(inferStart))
+		return nil, fmt.Errorf("deepstack: request: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only body
+	var r deepStackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		RecordDetection(DetectorDeepStack, d.endpoint, "error", time.Since(inferStart))
+		return nil, fmt.Errorf("deepstack: decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || !r.Success {
+		RecordDetection(DetectorDeepStack, d.endpoint, "error", time.Since(inferStart))
+		return nil, fmt.Errorf("deepstack: server returned %d: %s", resp.StatusCode, r.Error)
+	}
+	RecordDetection(DetectorDeepStack, d.endpoint, "ok", time.Since(inferStart))
+
+	var results []TritonDetection
+	for _, p := range r.Predictions {
+		if float64(p.Confidence) < minConf {
+			continue
+		}
+		results = append(results, TritonDetection{
+			Label:      p.Label,
+			Confidence: p.Confidence,
+			XMin:       p.X_min,
+			YMin:       p.Y_min,
+			XMax:       p.X_max,
+			YMax:       p.Y_max,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,176 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// yoloOutput builds a [1, 84, anchors] tensor with one person at the centre of
+// a 64×64 model input.
+func yoloOutput(anchors int) []float32 {
+	out := make([]float32, 84*anchors)
+	out[0*anchors] = 32  // cx
+	out[1*anchors] = 32  // cy
+	out[2*anchors] = 20  // w
+	out[3*anchors] = 20  // h
+	out[4*anchors] = 0.9 // person
+	return out
+}
+
+func setSmallYOLOConfig(t *testing.T) {
+	t.Helper()
+	Config.Set("triton_model", "yolo11")
+	Config.Set("triton_input_width", 64)
+	Config.Set("triton_input_height", 64)
+	Config.Set("min_confidence", 0.5)
+	t.Cleanup(func() {
+		Config.Set("triton_input_width", nil)
+		Config.Set("triton_input_height", nil)
+		Config.Set("triton_http_binary", nil)
+	})
+}
+
+func TestTritonHTTPDetector(t *testing.T) {
+	setSmallYOLOConfig(t)
+	const anchors = 100
+	floats := yoloOutput(anchors)
+
+	for _, binaryData := range []bool{true, false} {
+		t.Run("binary="+strconv.FormatBool(binaryData), func(t *testing.T) {
+			Config.Set("triton_http_binary", binaryData)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/models/yolo11/infer" {
+					http.Error(w, `{"error":"bad path"}`, http.StatusNotFound)
+					return
+				}
+				body, _ := io.ReadAll(r.Body) //nolint:errcheck // test server
+				header := body
+				if hl := r.Header.Get(inferHeaderLength); hl != "" {
+					n, _ := strconv.Atoi(hl) //nolint:errcheck // test server
+					header = body[:n]
+					if len(body)-n != 3*64*64*4 {
+						t.Errorf("binary input has %d bytes", len(body)-n)
+					}
+				}
+				var req kserveRequest
+				if err := json.Unmarshal(header, &req); err != nil {
+					t.Errorf("decode request: %v", err)
+				}
+				if len(req.Inputs) != 1 || req.Inputs[0].Name != "images" {
+					t.Errorf("unexpected inputs: %+v", req.Inputs)
+				}
+				out := kserveTensor{Name: "output0", Datatype: "FP32", Shape: []int64{1, 84, anchors}}
+				if !binaryData {
+					out.Data = floats
+					_ = json.NewEncoder(w).Encode(kserveResponse{Outputs: []kserveTensor{out}}) //nolint:errcheck // test server
+					return
+				}
+				raw := make([]byte, len(floats)*4)
+				for i, v := range floats {
+					binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(v))
+				}
+				out.Parameters = map[string]interface{}{"binary_data_size": len(raw)}
+				hdr, _ := json.Marshal(kserveResponse{Outputs: []kserveTensor{out}}) //nolint:errcheck // test server
+				w.Header().Set(inferHeaderLength, strconv.Itoa(len(hdr)))
+				_, _ = w.Write(append(hdr, raw...)) //nolint:errcheck // test server
+			}))
+			defer srv.Close()
+
+			dets, err := newTritonHTTPDetector(srv.URL+"/").Detect(context.Background(), testJPEG(t, 64, 64))
+			if err != nil {
+				t.Fatalf("Detect: %v", err)
+			}
+			if len(dets) != 1 || dets[0].Label != "person" {
+				t.Fatalf("expected one person, got %+v", dets)
+			}
+			if dets[0].XMin != 22 || dets[0].XMax != 42 {
+				t.Errorf("unexpected box %+v", dets[0])
+			}
+		})
+	}
+}
+
+func TestTritonHTTPDetectorError(t *testing.T) {
+	setSmallYOLOConfig(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, `{"error":"unexpected shape"}`) //nolint:errcheck // test server
+	}))
+	defer srv.Close()
+	if _, err := newTritonHTTPDetector(srv.URL).Detect(context.Background(), testJPEG(t, 64, 64)); err == nil {
+		t.Error("expected an error for a 400 response")
+	}
+}
+
+func TestDeepStackDetector(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if _, _, err := r.FormFile("image"); err != nil {
+			t.Errorf("missing image: %v", err)
+		}
+		//nolint:errcheck // test server
+		_, _ = io.WriteString(w, `{"success": true, "predictions": [
+			{"label": "person", "confidence": 0.8, "x_min": 1, "y_min": 2, "x_max": 30, "y_max": 40},
+			{"label": "cat", "confidence": 0.3, "x_min": 1, "y_min": 2, "x_max": 3, "y_max": 4}]}`)
+	}))
+	defer srv.Close()
+
+	dets, err := newDeepStackDetector(srv.URL).Detect(context.Background(), testJPEG(t, 32, 32))
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if len(dets) != 1 || dets[0].Label != "person" || dets[0].YMax != 40 {
+		t.Errorf("unexpected detections %+v", dets)
+	}
+}
+
+func TestInitDetector(t *testing.T) {
+	defer Config.Set("detector_backend", nil)
+	defer SetDetector(tritonGRPCDetector{})
+
+	Config.Set("detector_backend", "bogus")
+	if err := InitDetector(); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+	Config.Set("detector_backend", DetectorDeepStack)
+	Config.Set("deepstack_url", "")
+	if err := InitDetector(); err == nil {
+		t.Error("expected an error for a missing deepstack_url")
+	}
+	Config.Set("deepstack_url", "http://localhost:5000/v1/vision/detection")
+	if err := InitDetector(); err != nil {
+		t.Fatalf("InitDetector: %v", err)
+	}
+	if CurrentDetector().Name() != DetectorDeepStack {
+		t.Errorf("CurrentDetector = %s", CurrentDetector().Name())
+	}
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// Frame hashing. FrameHash is a difference hash (dHash) of a camera picture:
+// the brightness of a 9×8 grid of cell averages, one bit per pair of
+// neighbouring cells saying whether brightness falls from left to right.
+// Near-identical frames, such as those from a camera watching an empty room,
+// hash within a few bits of each other; lighting shifts across the whole
+// picture change little, while someone moving changes many bits. The grid is
+// built from the JPEG's DC coefficients (see decodeJPEGLuma) where it can be,
+// so hashing costs a fraction of a full decode.
+
+const (
+	frameHashCols = 9
+	frameHashRows = 8
+)
+
+// FrameHash returns the dHash of a JPEG.
+func FrameHash(jpegData []byte) (uint64, error) {
+	var img image.Image
+	if luma, err := decodeJPEGLuma(jpegData); err == nil && luma.Rect.Dx() >= frameHashCols && luma.Rect.Dy() >= frameHashRows {
+		img = luma
+	} else {
+		full, _, err := image.Decode(bytes.NewReader(jpegData))
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", errImageDecode, err)
+		}
+		img = full
+	}
+	b := img.Bounds()
+	if b.Dx() < frameHashCols || b.Dy() < frameHashRows {
+		return 0, fmt.Errorf("frame %dx%d too small to hash", b.Dx(), b.Dy())
+	}
+
+	var sums [frameHashRows][frameHashCols]uint64
+	var counts [frameHashRows][frameHashCols]uint64
+	add := func(x, y int, lum uint8) {
+		r := (y - b.Min.Y) * frameHashRows / b.Dy()
+		c := (x - b.Min.X) * frameHashCols / b.Dx()
+		sums[r][c] += uint64(lum)
+		counts[r][c]++
+	}
+	// JPEGs decode to YCbCr (or Gray), whose luma can be read directly.
+	switch m := img.(type) {
+	case *image.YCbCr:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				add(x, y, m.Y[m.YOffset(x, y)])
+			}
+		}
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				add(x, y, m.Pix[m.PixOffset(x, y)])
+			}
+		}
+	default:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				add(x, y, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y) //nolint:forcetypeassert // GrayModel returns Gray
+			}
+		}
+	}
+
+	var hash uint64
+	for r := range frameHashRows {
+		for c := range frameHashCols - 1 {
+			hash <<= 1
+			if sums[r][c]*counts[r][c+1] > sums[r][c+1]*counts[r][c] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// HashDistance is the number of bits in which two frame hashes differ.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
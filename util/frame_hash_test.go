@@ -0,0 +1,54 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// sceneJPEG is a grey gradient room, brightened by light, with a dark
+// 40×80 figure at x.
+func sceneJPEG(t *testing.T, light uint8, x int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for py := range 240 {
+		for px := range 320 {
+			v := uint8(40+px/4) + light
+			if px >= x && px < x+40 && py >= 100 && py < 180 {
+				v = 10
+			}
+			img.Set(px, py, color.RGBA{v, v, v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrameHash(t *testing.T) {
+	hash := func(data []byte) uint64 {
+		t.Helper()
+		h, err := FrameHash(data)
+		if err != nil {
+			t.Fatalf("FrameHash: %v", err)
+		}
+		return h
+	}
+	base := hash(sceneJPEG(t, 0, 40))
+	if d := HashDistance(base, hash(sceneJPEG(t, 0, 40))); d != 0 {
+		t.Errorf("same frame differs by %d bits", d)
+	}
+	if d := HashDistance(base, hash(sceneJPEG(t, 20, 40))); d > 4 {
+		t.Errorf("brighter frame differs by %d bits", d)
+	}
+	if d := HashDistance(base, hash(sceneJPEG(t, 0, 200))); d <= 4 {
+		t.Errorf("figure moving across the room changed only %d bits", d)
+	}
+	if _, err := FrameHash([]byte("jpeg")); err == nil {
+		t.Error("hashed a non-image")
+	}
+}
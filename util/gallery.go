@@ -0,0 +1,233 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Known-person recognition. Reference photos of the people in the model are
+// enrolled through /api/gallery: the photo's most confident person (or the
+// whole photo when the detector finds none) is embedded by the cascade's
+// secondary model, which must be an embedding model, and the embedding is
+// kept in `gallery_file`. A cascaded person whose embedding has a cosine
+// similarity of at least `gallery_match_threshold` with one of a person's
+// references is recognised as the closest such person. Embeddings from
+// different models are never compared, so changing cascade_model means
+// enrolling again.
+
+const defaultGalleryMatchThreshold = 0.6
+
+var (
+	ErrGalleryNoModel   = errors.New("gallery: no cascade_model configured")
+	ErrGalleryEmbedding = errors.New("gallery: the cascade model does not produce embeddings; set cascade_output to embedding")
+)
+
+// GalleryEntry is one enrolled reference image.
+type GalleryEntry struct {
+	ID        int64     `json:"id"`
+	Person    string    `json:"person"`
+	Model     string    `json:"model"`
+	Added     int64     `json:"added"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+type galleryStore struct {
+	mu      sync.RWMutex
+	path    string
+	entries []GalleryEntry
+}
+
+var gallery = &galleryStore{}
+
+// LoadGallery reads the enrolled embeddings from `gallery_file`; a missing
+// file is an empty gallery. InitDetector calls it.
+func LoadGallery() error {
+	path := Config.GetString("gallery_file")
+	if path == "" {
+		path = "gallery.json"
+	}
+	var entries []GalleryEntry
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from the operator's config
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("gallery: %w", err)
+	default:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("gallery: %s: %w", path, err)
+		}
+	}
+	gallery.mu.Lock()
+	gallery.path, gallery.entries = path, entries
+	gallery.mu.Unlock()
+	return nil
+}
+
+// GalleryEntries lists the enrolled references, without their embeddings.
+func GalleryEntries() []GalleryEntry {
+	gallery.mu.RLock()
+	defer gallery.mu.RUnlock()
+	out := make([]GalleryEntry, len(gallery.entries))
+	for i, e := range gallery.entries {
+		e.Embedding = nil
+		out[i] = e
+	}
+	return out
+}
+
+// EnrollPerson embeds a reference photo of person and adds it to the gallery.
+func EnrollPerson(ctx context.Context, person string, jpegData []byte) (GalleryEntry, error) {
+	m := CurrentSecondaryModel()
+	if m == nil {
+		return GalleryEntry{}, ErrGalleryNoModel
+	}
+	img, _, err := image.Decode(bytes.NewReader(jpegData))
+	if err != nil {
+		return GalleryEntry{}, fmt.Errorf("gallery: %w: %w", errImageDecode, err)
+	}
+	rect := img.Bounds()
+	if dets, err := CurrentDetector().Detect(ctx, jpegData); err != nil {
+		Logger.Debug().Msgf("gallery: detection failed, embedding the whole photo: %v", err)
+	} else {
+		var best *TritonDetection
+		for i, d := range dets {
+			if d.Label == "person" && d.XMax > d.XMin && d.YMax > d.YMin && (best == nil || d.Confidence > best.Confidence) {
+				best = &dets[i]
+			}
+		}
+		if best != nil {
+			rect = cropRect(*best, cascadePadding(), img.Bounds())
+		}
+	}
+	crop, ok := subImage(img, rect)
+	if !ok {
+		return GalleryEntry{}, fmt.Errorf("gallery: cannot crop a %T", img)
+	}
+	results, err := m.Run(ctx, []image.Image{crop})
+	if err != nil {
+		return GalleryEntry{}, fmt.Errorf("gallery: %s: %w", m.Name(), err)
+	}
+	if len(results) != 1 || len(results[0].Embedding) == 0 {
+		return GalleryEntry{}, ErrGalleryEmbedding
+	}
+	return gallery.add(GalleryEntry{
+		Person:    person,
+		Model:     results[0].Model,
+		Added:     time.Now().Unix(),
+		Embedding: results[0].Embedding,
+	})
+}
+
+// RemoveGalleryEntries removes the entry with the given id, or with id 0
+// every entry for person, returning how many were removed.
+func RemoveGalleryEntries(id int64, person string) (int, error) {
+	gallery.mu.Lock()
+	defer gallery.mu.Unlock()
+	kept := slices.DeleteFunc(slices.Clone(gallery.entries), func(e GalleryEntry) bool {
+		return (id != 0 && e.ID == id) || (id == 0 && e.Person == person)
+	})
+	removed := len(gallery.entries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := gallery.save(kept); err != nil {
+		return 0, err
+	}
+	gallery.entries = kept
+	return removed, nil
+}
+
+func (g *galleryStore) add(e GalleryEntry) (GalleryEntry, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, old := range g.entries {
+		e.ID = max(e.ID, old.ID)
+	}
+	e.ID++
+	entries := append(slices.Clone(g.entries), e)
+	if err := g.save(entries); err != nil {
+		return GalleryEntry{}, err
+	}
+	g.entries = entries
+	Logger.Info().Msgf("gallery: enrolled reference %d for %s", e.ID, e.Person)
+	return e, nil
+}
+
+// save writes entries to the gallery file, replacing it only once the new
+// contents are on disk. g.mu must be held.
+func (g *galleryStore) save(entries []GalleryEntry) error {
+	path := g.path
+	if path == "" {
+		path = "gallery.json"
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("gallery: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gallery-*")
+	if err != nil {
+		return fmt.Errorf("gallery: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // gone after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck,gosec // the write error is the one to report
+		return fmt.Errorf("gallery: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("gallery: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("gallery: %w", err)
+	}
+	return nil
+}
+
+// MatchPerson returns the enrolled person whose reference embedding from
+// model is most similar to embedding, if any is similar enough.
+func MatchPerson(model string, embedding []float32) (string, float32, bool) {
+	threshold := defaultGalleryMatchThreshold
+	if Config.IsSet("gallery_match_threshold") {
+		threshold = Config.GetFloat64("gallery_match_threshold")
+	}
+	gallery.mu.RLock()
+	defer gallery.mu.RUnlock()
+	var person string
+	var best float32
+	for _, e := range gallery.entries {
+		if e.Model != model {
+			continue
+		}
+		if sim := cosineSimilarity(embedding, e.Embedding); sim >= float32(threshold) && (person == "" || sim > best) {
+			person, best = e.Person, sim
+		}
+	}
+	return person, best, person != ""
+}
+
+// cosineSimilarity is the cosine of the angle between a and b, or 0 when
+// they differ in length or either is zero.
+func cosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(na*nb))
+}
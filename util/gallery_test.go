@@ -0,0 +1,109 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"path/filepath"
+	"testing"
+)
+
+func colourJPEG(t *testing.T, c color.RGBA) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for x := 0; x < 32; x++ {
+		for y := 0; y < 32; y++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGallery(t *testing.T) {
+	Config.Set("gallery_file", filepath.Join(t.TempDir(), "gallery.json"))
+	Config.Set("cascade_model", "reid")
+	Config.Set("cascade_backend", CascadeStub)
+	defer Config.Set("gallery_file", nil)
+	defer Config.Set("cascade_model", nil)
+	defer Config.Set("cascade_backend", nil)
+	defer SetSecondaryModel(nil)
+	old := CurrentDetector()
+	defer SetDetector(old)
+	SetDetector(&flakyDetector{}) // a person with an empty box: the whole photo is used
+	if err := LoadGallery(); err != nil {
+		t.Fatalf("LoadGallery: %v", err)
+	}
+	ctx := context.Background()
+	red, blue := colourJPEG(t, color.RGBA{R: 220, A: 255}), colourJPEG(t, color.RGBA{B: 220, A: 255})
+
+	if err := InitCascade(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnrollPerson(ctx, "alice", red); !errors.Is(err, ErrGalleryEmbedding) {
+		t.Errorf("enrolling with a classifier: %v", err)
+	}
+	Config.Set("cascade_output", CascadeEmbedding)
+	defer Config.Set("cascade_output", nil)
+	if err := InitCascade(); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := EnrollPerson(ctx, "alice", red)
+	if err != nil {
+		t.Fatalf("EnrollPerson: %v", err)
+	}
+	if _, err := EnrollPerson(ctx, "bob", blue); err != nil {
+		t.Fatalf("EnrollPerson: %v", err)
+	}
+
+	// The gallery survives a reload, and cascaded people are matched.
+	if err := LoadGallery(); err != nil {
+		t.Fatalf("LoadGallery: %v", err)
+	}
+	if entries := GalleryEntries(); len(entries) != 2 || entries[0].Person != "alice" || entries[0].Embedding != nil {
+		t.Fatalf("entries %+v", entries)
+	}
+	dets := Cascade(ctx, red, []TritonDetection{{Label: "person", Confidence: 0.9, XMin: 4, YMin: 4, XMax: 28, YMax: 28}})
+	if s := dets[0].Secondary; s == nil || s.Person != "alice" || s.Similarity < 0.9 {
+		t.Errorf("secondary %+v, expected alice", s)
+	}
+	if person, _, ok := MatchPerson("other_model", dets[0].Secondary.Embedding); ok {
+		t.Errorf("matched %s across models", person)
+	}
+	if _, _, ok := MatchPerson("reid", []float32{0, 1, 0}); ok {
+		t.Error("matched a green embedding")
+	}
+
+	if n, err := RemoveGalleryEntries(alice.ID, ""); err != nil || n != 1 {
+		t.Errorf("RemoveGalleryEntries(id) = %d, %v", n, err)
+	}
+	if n, err := RemoveGalleryEntries(0, "bob"); err != nil || n != 1 {
+		t.Errorf("RemoveGalleryEntries(person) = %d, %v", n, err)
+	}
+	if err := LoadGallery(); err != nil || len(GalleryEntries()) != 0 {
+		t.Errorf("after removal: %v, %+v", err, GalleryEntries())
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	for _, tt := range []struct {
+		a, b []float32
+		want float32
+	}{
+		{[]float32{1, 0}, []float32{2, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 1}, 0},
+		{[]float32{1, 0}, []float32{-1, 0}, -1},
+		{[]float32{1, 0}, []float32{1, 0, 0}, 0},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+	} {
+		if got := cosineSimilarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("cosineSimilarity(%v, %v) = %v, expected %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
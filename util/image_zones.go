@@ -0,0 +1,117 @@
+package util
+
+import "fmt"
+
+// Image zones. A room's image_zones mark out areas of its camera pictures,
+// such as the bed or a doorway, as polygons in image pixels:
+//
+//	"image_zones": [
+//	  {"name": "bed", "topics": ["esp-cam/bedroom/image"], "polygon": [[0, 300], [400, 300], [400, 600], [0, 600]]},
+//	  {"name": "tv", "polygon": [[900, 100], [1200, 100], [1200, 400], [900, 400]], "ignore": true}
+//	]
+//
+// A detection is in a zone when the centre of its box is inside the polygon
+// or, with "membership": "mask", when at least min_overlap (default 0.5) of
+// its segmentation mask is; detections without a mask fall back to the box
+// centre. Detections in an ignore zone, such as people on a TV, are dropped.
+// Zones without topics apply to all of the room's cameras.
+
+// ImageZone is one area of a room's camera pictures.
+type ImageZone struct {
+	Name        string   `mapstructure:"name"`
+	Topics      []string `mapstructure:"topics"`
+	Polygon     [][]int  `mapstructure:"polygon"`    // [x, y] image pixels
+	Membership  string   `mapstructure:"membership"` // "centre" (default) or "mask"
+	Min_overlap float64  `mapstructure:"min_overlap"`
+	Ignore      bool     `mapstructure:"ignore"`
+}
+
+const (
+	ImageZoneCentre = "centre"
+	ImageZoneMask   = "mask"
+
+	defaultMinOverlap = 0.5
+)
+
+func (z ImageZone) validate() error {
+	switch {
+	case z.Name == "":
+		return fmt.Errorf("image zone has no name")
+	case len(z.Polygon) < 3:
+		return fmt.Errorf("image zone %s: polygon needs at least 3 points", z.Name)
+	case z.Membership != "" && z.Membership != ImageZoneCentre && z.Membership != ImageZoneMask:
+		return fmt.Errorf("image zone %s: membership %q is not centre or mask", z.Name, z.Membership)
+	case z.Min_overlap < 0 || z.Min_overlap > 1:
+		return fmt.Errorf("image zone %s: min_overlap %v is not between 0 and 1", z.Name, z.Min_overlap)
+	}
+	for _, p := range z.Polygon {
+		if len(p) != 2 {
+			return fmt.Errorf("image zone %s: point %v is not [x, y]", z.Name, p)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the zone covers pictures from topic.
+func (z ImageZone) appliesTo(topic string) bool {
+	return topicsMatch(z.Topics, topic)
+}
+
+// Contains reports whether detection d is in the zone.
+func (z ImageZone) Contains(d TritonDetection) bool {
+	if z.Membership == ImageZoneMask && d.Mask.Area() > 0 {
+		minOverlap := z.Min_overlap
+		if minOverlap == 0 {
+			minOverlap = defaultMinOverlap
+		}
+		inside := 0
+		d.Mask.Each(func(x, y int) {
+			if insidePolygon(z.Polygon, float64(x)+0.5, float64(y)+0.5) { //nolint:mnd // pixel centre
+				inside++
+			}
+		})
+		return float64(inside) >= minOverlap*float64(d.Mask.Area())
+	}
+	return insidePolygon(z.Polygon, float64(d.XMin+d.XMax)/2, float64(d.YMin+d.YMax)/2) //nolint:mnd
+}
+
+// insidePolygon is the even-odd rule: a ray from (x, y) crosses the polygon's
+// edges an odd number of times when the point is inside.
+func insidePolygon(poly [][]int, x, y float64) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		xi, yi := float64(poly[i][0]), float64(poly[i][1])
+		xj, yj := float64(poly[j][0]), float64(poly[j][1])
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			in = !in
+		}
+	}
+	return in
+}
+
+// PlaceInImageZones fills in the image zones of detections from the camera
+// on topic and drops those in ignore zones.
+func PlaceInImageZones(zones []ImageZone, topic string, dets []TritonDetection) []TritonDetection {
+	if len(zones) == 0 {
+		return dets
+	}
+	out := make([]TritonDetection, 0, len(dets))
+	for _, d := range dets {
+		d.ImageZones = nil
+		ignore := false
+		for _, z := range zones {
+			if !z.appliesTo(topic) || !z.Contains(d) {
+				continue
+			}
+			if z.Ignore {
+				ignore = true
+				break
+			}
+			d.ImageZones = append(d.ImageZones, z.Name)
+		}
+		if !ignore {
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlaceInImageZones(t *testing.T) {
+	square := func(x0, y0, x1, y1 int) [][]int { return [][]int{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}} }
+	zones := []ImageZone{
+		{Name: "bed", Topics: []string{"cams/bedroom/#"}, Polygon: square(0, 0, 50, 100)},
+		{Name: "bed_mask", Polygon: square(0, 0, 50, 100), Membership: ImageZoneMask},
+		{Name: "tv", Polygon: square(80, 0, 100, 20), Ignore: true},
+	}
+	// A box centred at x=55 whose mask lies mostly left of x=50.
+	d := TritonDetection{Label: "person", XMin: 30, YMin: 10, XMax: 80, YMax: 60}
+	d.Mask = newMask(30, 10, 50, 50, func(x, _ int) bool { return x < 55 })
+	tv := TritonDetection{Label: "person", XMin: 85, YMin: 5, XMax: 95, YMax: 15}
+
+	got := PlaceInImageZones(zones, "cams/bedroom/image", []TritonDetection{d, tv})
+	if len(got) != 1 || !reflect.DeepEqual(got[0].ImageZones, []string{"bed_mask"}) {
+		t.Fatalf("placed %+v", got)
+	}
+
+	// Without a mask, mask membership falls back to the box centre.
+	d.Mask = nil
+	d.XMin = 10
+	got = PlaceInImageZones(zones, "cams/bedroom/image", []TritonDetection{d})
+	if !reflect.DeepEqual(got[0].ImageZones, []string{"bed", "bed_mask"}) {
+		t.Errorf("zones %v", got[0].ImageZones)
+	}
+	got = PlaceInImageZones(zones, "cams/office/image", []TritonDetection{d})
+	if !reflect.DeepEqual(got[0].ImageZones, []string{"bed_mask"}) {
+		t.Errorf("zones for another camera %v", got[0].ImageZones)
+	}
+}
+
+func TestImageZoneValidate(t *testing.T) {
+	ok := ImageZone{Name: "bed", Polygon: [][]int{{0, 0}, {10, 0}, {0, 10}}}
+	if err := ok.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+	for _, z := range []ImageZone{
+		{Polygon: ok.Polygon},
+		{Name: "line", Polygon: [][]int{{0, 0}, {10, 0}}},
+		{Name: "point", Polygon: [][]int{{0, 0}, {10, 0}, {0}}},
+		{Name: "how", Polygon: ok.Polygon, Membership: "keypoints"},
+		{Name: "overlap", Polygon: ok.Polygon, Min_overlap: 2},
+	} {
+		if err := z.validate(); err == nil {
+			t.Errorf("%+v validated", z)
+		}
+	}
+}
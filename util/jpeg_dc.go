@@ -0,0 +1,371 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+// DC-only JPEG decoding. A baseline JPEG stores, for each 8×8 block, the
+// block's mean brightness as its DC coefficient; reading only those from the
+// luma component gives a 1/8-scale greyscale picture without any inverse DCT
+// or colour conversion. The entropy-coded data must still be walked, but the
+// AC coefficients are only skipped. Progressive, arithmetic-coded and 12-bit
+// JPEGs are not handled; callers fall back to image.Decode for them.
+
+var errJPEGUnsupported = errors.New("jpeg: not a baseline huffman JPEG")
+
+const (
+	jpegBlock     = 8
+	jpegBlockSize = 64
+	jpegMaxTables = 4
+)
+
+// jpegHuffman is a canonical Huffman table, decoded as in ITU T.81 F.2.2.3.
+type jpegHuffman struct {
+	maxCode [17]int32 // largest code of each length, -1 when none
+	valPtr  [17]int32 // index in vals of the first code of each length
+	minCode [17]int32
+	vals    []byte
+}
+
+func newJPEGHuffman(counts []byte, vals []byte) *jpegHuffman {
+	h := &jpegHuffman{vals: vals}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		h.maxCode[l] = -1
+		if n > 0 {
+			h.valPtr[l] = k
+			h.minCode[l] = code
+			code += n
+			k += n
+			h.maxCode[l] = code - 1
+		}
+		code <<= 1
+	}
+	return h
+}
+
+// jpegBits reads the entropy-coded segment of a scan.
+type jpegBits struct {
+	data   []byte
+	pos    int
+	acc    uint32 // pending bits, most significant first
+	n      uint
+	marker bool // reached a marker; zeros are read from here on
+}
+
+func (b *jpegBits) fill() {
+	for b.n <= 24 {
+		var c byte
+		if !b.marker && b.pos < len(b.data) {
+			c = b.data[b.pos]
+			switch {
+			case c != 0xff:
+				b.pos++
+			case b.pos+1 < len(b.data) && b.data[b.pos+1] == 0:
+				b.pos += 2
+			default:
+				b.marker = true
+				c = 0
+			}
+		}
+		b.acc |= uint32(c) << (24 - b.n)
+		b.n += 8
+	}
+}
+
+func (b *jpegBits) bits(k uint) int32 {
+	if k == 0 {
+		return 0
+	}
+	if b.n < k {
+		b.fill()
+	}
+	v := int32(b.acc >> (32 - k)) //nolint:gosec // k <= 16
+	b.acc <<= k
+	b.n -= k
+	return v
+}
+
+// extend reads a k-bit magnitude and sign, as in T.81 F.2.2.1.
+func (b *jpegBits) extend(k uint) int32 {
+	v := b.bits(k)
+	if k > 0 && v < 1<<(k-1) {
+		v += -1<<k + 1
+	}
+	return v
+}
+
+func (b *jpegBits) decode(h *jpegHuffman) (byte, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | b.bits(1)
+		if code <= h.maxCode[l] {
+			i := h.valPtr[l] + code - h.minCode[l]
+			if int(i) >= len(h.vals) {
+				break
+			}
+			return h.vals[i], nil
+		}
+	}
+	return 0, errors.New("jpeg: bad huffman code")
+}
+
+// restart skips to just past the next RSTn marker.
+func (b *jpegBits) restart() error {
+	b.acc, b.n, b.marker = 0, 0, false
+	if b.pos+1 >= len(b.data) || b.data[b.pos] != 0xff || b.data[b.pos+1] < 0xd0 || b.data[b.pos+1] > 0xd7 {
+		return errors.New("jpeg: missing restart marker")
+	}
+	b.pos += 2
+	return nil
+}
+
+type jpegComponent struct {
+	id     byte
+	h, v   int
+	quant  int
+	dc, ac int // huffman tables of the scan
+}
+
+// decodeJPEGLuma returns the mean brightness of every 8×8 luma block of a
+// baseline JPEG: a picture 1/8 the size of the JPEG's.
+func decodeJPEGLuma(data []byte) (*image.Gray, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("jpeg: missing SOI marker")
+	}
+	var (
+		quant         [jpegMaxTables]int32 // DC quantiser of each table
+		dcTables      [jpegMaxTables]*jpegHuffman
+		acTables      [jpegMaxTables]*jpegHuffman
+		comps         []jpegComponent
+		width, height int
+		restartEvery  int
+	)
+	pos := 2
+	for {
+		// Markers may be preceded by fill bytes.
+		for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("jpeg: bad marker")
+		}
+		marker := data[pos+1]
+		if marker == 0xd9 { // end of image
+			return nil, errors.New("jpeg: no scan")
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			return nil, errors.New("jpeg: short segment")
+		}
+		seg := data[pos+4 : pos+2+n]
+		pos += 2 + n
+
+		switch marker {
+		case 0xc0, 0xc1: // baseline, extended sequential huffman
+			if len(seg) < 6 || seg[0] != 8 {
+				return nil, errJPEGUnsupported
+			}
+			height = int(binary.BigEndian.Uint16(seg[1:]))
+			width = int(binary.BigEndian.Uint16(seg[3:]))
+			nc := int(seg[5])
+			if width == 0 || height == 0 || nc == 0 || len(seg) < 6+3*nc {
+				return nil, errors.New("jpeg: bad frame header")
+			}
+			comps = make([]jpegComponent, nc)
+			for i := range comps {
+				c := seg[6+3*i:]
+				comps[i] = jpegComponent{id: c[0], h: int(c[1] >> 4), v: int(c[1] & 0xf), quant: int(c[2])}
+				if comps[i].h == 0 || comps[i].v == 0 || comps[i].quant >= jpegMaxTables {
+					return nil, errors.New("jpeg: bad frame header")
+				}
+			}
+		case 0xc2, 0xc3, 0xc5, 0xc6, 0xc7, 0xc9, 0xca, 0xcb, 0xcd, 0xce, 0xcf:
+			return nil, errJPEGUnsupported
+		case 0xdb: // quantisation tables
+			for len(seg) > 0 {
+				precision, id := seg[0]>>4, int(seg[0]&0xf)
+				size := 1 + jpegBlockSize
+				if precision != 0 {
+					size = 1 + 2*jpegBlockSize
+				}
+				if id >= jpegMaxTables || len(seg) < size {
+					return nil, errors.New("jpeg: bad quantisation table")
+				}
+				quant[id] = int32(seg[1])
+				if precision != 0 {
+					quant[id] = int32(binary.BigEndian.Uint16(seg[1:]))
+				}
+				seg = seg[size:]
+			}
+		case 0xc4: // huffman tables
+			for len(seg) > 0 {
+				if len(seg) < 17 {
+					return nil, errors.New("jpeg: bad huffman table")
+				}
+				class, id := seg[0]>>4, int(seg[0]&0xf)
+				total := 0
+				for _, c := range seg[1:17] {
+					total += int(c)
+				}
+				if id >= jpegMaxTables || class > 1 || len(seg) < 17+total {
+					return nil, errors.New("jpeg: bad huffman table")
+				}
+				h := newJPEGHuffman(seg[1:17], seg[17:17+total])
+				if class == 0 {
+					dcTables[id] = h
+				} else {
+					acTables[id] = h
+				}
+				seg = seg[17+total:]
+			}
+		case 0xdd: // restart interval
+			if len(seg) < 2 {
+				return nil, errors.New("jpeg: bad restart interval")
+			}
+			restartEvery = int(binary.BigEndian.Uint16(seg))
+		case 0xda: // start of scan
+			if comps == nil {
+				return nil, errors.New("jpeg: scan before frame header")
+			}
+			if len(seg) == 0 {
+				return nil, errors.New("jpeg: bad scan header")
+			}
+			ns := int(seg[0])
+			if ns == 0 || len(seg) < 1+2*ns {
+				return nil, errors.New("jpeg: bad scan header")
+			}
+			scan := make([]int, ns)
+			for i := range scan {
+				id, tables := seg[1+2*i], seg[2+2*i]
+				scan[i] = -1
+				for ci := range comps {
+					if comps[ci].id == id {
+						scan[i] = ci
+						comps[ci].dc, comps[ci].ac = int(tables>>4), int(tables&0xf)
+					}
+				}
+				if scan[i] < 0 || comps[scan[i]].dc >= jpegMaxTables || comps[scan[i]].ac >= jpegMaxTables ||
+					dcTables[comps[scan[i]].dc] == nil || acTables[comps[scan[i]].ac] == nil {
+					return nil, errors.New("jpeg: bad scan header")
+				}
+			}
+			if scan[0] != 0 {
+				// The luma is not in this scan; skip to the next marker.
+				pos = nextJPEGMarker(data, pos)
+				continue
+			}
+			return decodeJPEGLumaScan(data[pos:], comps, scan, quant, dcTables, acTables, width, height, restartEvery)
+		}
+	}
+}
+
+// nextJPEGMarker returns the position of the marker ending the entropy-coded
+// segment starting at pos.
+func nextJPEGMarker(data []byte, pos int) int {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] == 0xff && data[pos+1] != 0 && (data[pos+1] < 0xd0 || data[pos+1] > 0xd7) {
+			return pos
+		}
+	}
+	return len(data)
+}
+
+// decodeJPEGLumaScan reads the DC coefficients of the first component, the
+// luma, from a scan.
+func decodeJPEGLumaScan(data []byte, comps []jpegComponent, scan []int, quant [jpegMaxTables]int32,
+	dcTables, acTables [jpegMaxTables]*jpegHuffman, width, height, restartEvery int,
+) (*image.Gray, error) {
+	hMax, vMax := 1, 1
+	for _, c := range comps {
+		hMax, vMax = max(hMax, c.h), max(vMax, c.v)
+	}
+	y := comps[0]
+	// Blocks covering the luma plane, which may be subsampled itself.
+	bw := ((width*y.h+hMax-1)/hMax + jpegBlock - 1) / jpegBlock
+	bh := ((height*y.v+vMax-1)/vMax + jpegBlock - 1) / jpegBlock
+
+	// An interleaved scan codes MCUs of every component's blocks; a scan of
+	// one component codes its blocks in raster order.
+	mcuX, mcuY := bw, bh
+	if len(scan) > 1 {
+		mcuX = (width + jpegBlock*hMax - 1) / (jpegBlock * hMax)
+		mcuY = (height + jpegBlock*vMax - 1) / (jpegBlock * vMax)
+	}
+
+	out := image.NewGray(image.Rect(0, 0, bw, bh))
+	b := &jpegBits{data: data}
+	preds := make([]int32, len(scan))
+	q := quant[y.quant]
+	block := func(si int) (int32, error) {
+		c := comps[scan[si]]
+		s, err := b.decode(dcTables[c.dc])
+		if err != nil {
+			return 0, err
+		}
+		if s > 16 { //nolint:mnd
+			return 0, errors.New("jpeg: bad DC coefficient")
+		}
+		preds[si] += b.extend(uint(s))
+		for k := 1; k < jpegBlockSize; k++ {
+			rs, err := b.decode(acTables[c.ac])
+			if err != nil {
+				return 0, err
+			}
+			r, s := int(rs>>4), uint(rs&0xf)
+			if s == 0 {
+				if r != 15 { //nolint:mnd
+					break
+				}
+				k += 15 //nolint:mnd
+				continue
+			}
+			k += r
+			b.bits(s)
+		}
+		return preds[si], nil
+	}
+
+	mcus := 0
+	for my := range mcuY {
+		for mx := range mcuX {
+			if restartEvery > 0 && mcus > 0 && mcus%restartEvery == 0 {
+				if err := b.restart(); err != nil {
+					return nil, err
+				}
+				clear(preds)
+			}
+			mcus++
+			for si, ci := range scan {
+				c := comps[ci]
+				hb, vb := c.h, c.v
+				if len(scan) == 1 {
+					hb, vb = 1, 1
+				}
+				for by := range vb {
+					for bx := range hb {
+						dc, err := block(si)
+						if err != nil {
+							return nil, fmt.Errorf("%w: %w", errImageDecode, err)
+						}
+						px, py := mx*hb+bx, my*vb+by
+						if ci != 0 || px >= bw || py >= bh {
+							continue
+						}
+						// The DC coefficient is eight times the block's mean,
+						// less the level shift of 128.
+						out.Pix[py*out.Stride+px] = uint8(min(max(dc*q/jpegBlock+128, 0), 255)) //nolint:gosec,mnd // clamped
+					}
+				}
+			}
+		}
+	}
+	if !b.marker && b.pos >= len(b.data) {
+		return nil, fmt.Errorf("%w: jpeg: truncated scan", errImageDecode)
+	}
+	return out, nil
+}
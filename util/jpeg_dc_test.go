@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestDecodeJPEGLuma(t *testing.T) {
+	// Sizes that are not whole MCUs, in colour (4:2:0) and greyscale.
+	pattern := func(x, y int) uint8 { return uint8((x*7 + y*3 + (x/13)*(y/11)*40) % 256) } //nolint:gosec // test pattern
+	rgba := image.NewRGBA(image.Rect(0, 0, 203, 150))
+	grey := image.NewGray(image.Rect(0, 0, 97, 75))
+	for y := range 150 {
+		for x := range 203 {
+			v := pattern(x, y)
+			rgba.Set(x, y, color.RGBA{v, 255 - v, v / 2, 255})
+			if x < 97 && y < 75 {
+				grey.SetGray(x, y, color.Gray{v})
+			}
+		}
+	}
+	for name, img := range map[string]image.Image{"colour": rgba, "grey": grey} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		luma, err := decodeJPEGLuma(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		full, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decodeJPEGLuma(buf.Bytes()[:buf.Len()*3/4]); err == nil {
+			t.Errorf("%s: decoded a truncated JPEG", name)
+		}
+		b := full.Bounds()
+		if want := image.Rect(0, 0, (b.Dx()+7)/8, (b.Dy()+7)/8); luma.Rect != want {
+			t.Fatalf("%s: luma %v, expected %v", name, luma.Rect, want)
+		}
+		// Each value is the mean luma of its block, which the full decode
+		// gives up to rounding; edge blocks also average their padding.
+		for by := range luma.Rect.Dy() - 1 {
+			for bx := range luma.Rect.Dx() - 1 {
+				sum := 0
+				for y := by * 8; y < by*8+8; y++ {
+					for x := bx * 8; x < bx*8+8; x++ {
+						sum += int(color.GrayModel.Convert(full.At(x, y)).(color.Gray).Y) //nolint:forcetypeassert // GrayModel returns Gray
+					}
+				}
+				if d := int(luma.GrayAt(bx, by).Y) - sum/64; d < -2 || d > 2 {
+					t.Fatalf("%s: block (%d, %d) mean %d, expected %d", name, bx, by, luma.GrayAt(bx, by).Y, sum/64)
+				}
+			}
+		}
+	}
+
+	if _, err := decodeJPEGLuma([]byte("jpeg")); err == nil {
+		t.Error("decoded a non-JPEG")
+	}
+}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	tritongprc "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+// Class labels for detection models come from, in order of preference:
+//
+//  1. the file configured for the model in `labels_files` (model -> path);
+//  2. the Triton model config: a "labels" (or Ultralytics-style "names")
+//     parameter, or the output's label_filename when `triton_model_repository`
+//     points at a local copy of the model repository;
+//  3. the built-in COCO list.
+//
+// Class indices without a label are reported as "unknown_N".
+
+var (
+	labelsMu    sync.Mutex
+	labelsCache = make(map[string][]string) // path -> labels
+)
+
+// ResetLabelsCache forgets labels files read so far, so edits are picked up on
+// the next config reload.
+func ResetLabelsCache() {
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+	labelsCache = make(map[string][]string)
+}
+
+// LoadLabelsFile reads a labels file: one label per line (Triton's
+// label_filename format), or a JSON list or index -> name object.
+func LoadLabelsFile(path string) ([]string, error) {
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+	if labels, ok := labelsCache[path]; ok {
+		return labels, nil
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from the operator's config
+	if err != nil {
+		return nil, fmt.Errorf("labels: %w", err)
+	}
+	labels, err := parseLabels(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("labels: %s: %w", path, err)
+	}
+	labelsCache[path] = labels
+	return labels, nil
+}
+
+// parseLabels accepts a JSON list, a JSON object of index -> name, or plain
+// text with one label per line (or comma separated on a single line).
+func parseLabels(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("no labels")
+	case strings.HasPrefix(s, "["):
+		var list []string
+		if err := json.Unmarshal([]byte(s), &list); err != nil {
+			return nil, err
+		}
+		return list, nil
+	case strings.HasPrefix(s, "{"):
+		var byIndex map[string]string
+		if err := json.Unmarshal([]byte(s), &byIndex); err != nil {
+			return nil, err
+		}
+		return indexedLabels(byIndex)
+	}
+	sep := "\n"
+	if !strings.Contains(s, "\n") {
+		sep = ","
+	}
+	parts := strings.Split(s, sep)
+	labels := make([]string, len(parts))
+	for i, p := range parts {
+		labels[i] = strings.TrimSpace(p)
+	}
+	return labels, nil
+}
+
+func indexedLabels(byIndex map[string]string) ([]string, error) {
+	idx := make([]int, 0, len(byIndex))
+	names := make(map[int]string, len(byIndex))
+	for k, v := range byIndex {
+		i, err := strconv.Atoi(strings.TrimSpace(k))
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid class index %q", k)
+		}
+		idx = append(idx, i)
+		names[i] = v
+	}
+	sort.Ints(idx)
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("no labels")
+	}
+	labels := make([]string, idx[len(idx)-1]+1)
+	for i, name := range names {
+		labels[i] = name
+	}
+	return labels, nil
+}
+
+// ModelLabels returns the labels configured for model in `labels_files`, or
+// nil when there are none.
+func ModelLabels(model string) []string {
+	path := Config.GetStringMapString("labels_files")[strings.ToLower(model)]
+	if path == "" {
+		return nil
+	}
+	labels, err := LoadLabelsFile(path)
+	if err != nil {
+		Logger.Warn().Msgf("%v; using default labels for %s", err, model)
+		return nil
+	}
+	return labels
+}
+
+// tritonConfigLabels extracts labels from a Triton model config, if it has
+// any we can read.
+func tritonConfigLabels(cfg *tritongprc.ModelConfig, outputName string) []string {
+	if cfg == nil {
+		return nil
+	}
+	for _, key := range []string{"labels", "names"} {
+		if p, ok := cfg.GetParameters()[key]; ok {
+			if labels, err := parseLabels(pythonDictToJSON(p.GetStringValue())); err == nil {
+				return labels
+			}
+			Logger.Warn().Msgf("triton: model %s parameter %s is not a label list", cfg.GetName(), key)
+		}
+	}
+	repo := Config.GetString("triton_model_repository")
+	if repo == "" {
+		return nil
+	}
+	for _, out := range cfg.GetOutput() {
+		if out.GetName() != outputName || out.GetLabelFilename() == "" {
+			continue
+		}
+		labels, err := LoadLabelsFile(filepath.Join(repo, cfg.GetName(), out.GetLabelFilename()))
+		if err != nil {
+			Logger.Warn().Msgf("triton: %v", err)
+			return nil
+		}
+		return labels
+	}
+	return nil
+}
+
+// pythonDictToJSON rewrites an Ultralytics-style names dict such as
+// {0: 'person', 1: 'bicycle'} as JSON; other strings pass through.
+func pythonDictToJSON(s string) string {
+	t := strings.TrimSpace(s)
+	if !strings.HasPrefix(t, "{") || strings.Contains(t, `"`) {
+		return s
+	}
+	t = strings.ReplaceAll(t, "'", `"`)
+	var b strings.Builder
+	inString := false
+	for i := 0; i < len(t); i++ {
+		c := t[i]
+		if c == '"' {
+			inString = !inString
+		}
+		// Quote bare integer keys.
+		if !inString && c >= '0' && c <= '9' && i > 0 && (t[i-1] == '{' || t[i-1] == ' ' || t[i-1] == ',') {
+			j := i
+			for j < len(t) && t[j] >= '0' && t[j] <= '9' {
+				j++
+			}
+			b.WriteString(`"` + t[i:j] + `"`)
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// detectionLabels picks the labels for model: the configured file, then the
+// labels found in the Triton model config (info may be nil), then COCO.
+func detectionLabels(model string, info *TritonModelInfo) []string {
+	if labels := ModelLabels(model); labels != nil {
+		return labels
+	}
+	if info != nil && info.Labels != nil {
+		return info.Labels
+	}
+	return cocoClasses
+}
+
+// labelFor names a class index, falling back to "unknown_N".
+func labelFor(labels []string, idx int) string {
+	if idx >= 0 && idx < len(labels) && labels[idx] != "" {
+		return labels[idx]
+	}
+	return fmt.Sprintf("unknown_%d", idx)
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{"Lines", "person\ncat\ndog\npackage\n", []string{"person", "cat", "dog", "package"}},
+		{"Comma separated", "person, cat,dog", []string{"person", "cat", "dog"}},
+		{"JSON list", `["person", "cat"]`, []string{"person", "cat"}},
+		{"JSON object", `{"0": "person", "2": "dog"}`, []string{"person", "", "dog"}},
+		{"Python dict", pythonDictToJSON("{0: 'person', 1: 'cat', 10: 'tv 2'}"), []string{"person", "cat", "", "", "", "", "", "", "", "", "tv 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, err := parseLabels(tt.input)
+			if err != nil {
+				t.Fatalf("parseLabels error: %v", err)
+			}
+			if !reflect.DeepEqual(labels, tt.expected) {
+				t.Errorf("parseLabels = %q, expected %q", labels, tt.expected)
+			}
+		})
+	}
+	if _, err := parseLabels("  "); err == nil {
+		t.Error("expected an error for an empty label list")
+	}
+}
+
+func TestLabelFor(t *testing.T) {
+	labels := []string{"person", ""}
+	if got := labelFor(labels, 0); got != "person" {
+		t.Errorf("labelFor(0) = %q", got)
+	}
+	if got := labelFor(labels, 1); got != "unknown_1" {
+		t.Errorf("labelFor(1) = %q, expected unknown_1", got)
+	}
+	if got := labelFor(labels, 7); got != "unknown_7" {
+		t.Errorf("labelFor(7) = %q, expected unknown_7", got)
+	}
+}
+
+func TestDetectionLabels(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.labels")
+	if err := os.WriteFile(path, []byte("person\ncat\ndog\npackage\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	Config.Set("labels_files", map[string]string{"custom": path})
+	defer Config.Set("labels_files", nil)
+	ResetLabelsCache()
+
+	if got := detectionLabels("custom", nil); len(got) != 4 || got[3] != "package" {
+		t.Errorf("configured file: got %q", got)
+	}
+	info := &TritonModelInfo{Labels: []string{"a", "b"}}
+	if got := detectionLabels("other", info); !reflect.DeepEqual(got, info.Labels) {
+		t.Errorf("model config labels: got %q", got)
+	}
+	if got := detectionLabels("other", nil); len(got) != 80 || got[0] != "person" {
+		t.Errorf("default should be COCO, got %d labels", len(got))
+	}
+
+	// Labels from the Triton model config.
+	cfg := &tritonpb.ModelConfig{
+		Name:       "custom",
+		Parameters: map[string]*tritonpb.ModelParameter{"names": {StringValue: "{0: 'person', 1: 'cat'}"}},
+	}
+	if got := tritonConfigLabels(cfg, "output0"); !reflect.DeepEqual(got, []string{"person", "cat"}) {
+		t.Errorf("names parameter: got %q", got)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "custom"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "custom", "labels.txt"), []byte("box\nbag\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	Config.Set("triton_model_repository", dir)
+	defer Config.Set("triton_model_repository", nil)
+	cfg = &tritonpb.ModelConfig{
+		Name:   "custom",
+		Output: []*tritonpb.ModelOutput{{Name: "output0", LabelFilename: "labels.txt"}},
+	}
+	if got := tritonConfigLabels(cfg, "output0"); !reflect.DeepEqual(got, []string{"box", "bag"}) {
+		t.Errorf("label_filename: got %q", got)
+	}
+}
@@ -41,6 +41,9 @@ type StateProviders struct {
 type instruments struct {
 	detectionDuration    metric.Float64Histogram
 	detectionRequests    metric.Int64Counter
+	detectionImages      metric.Int64Counter
+	detectionBatchSize   metric.Int64Histogram
+	detectionBatchWait   metric.Float64Histogram
 	preprocessDuration   metric.Float64Histogram
 	messagesReceived     metric.Int64Counter
 	messagesPublished    metric.Int64Counter
@@ -53,6 +56,9 @@ type instruments struct {
 	objectConfidence     metric.Float64Histogram
 	personDetections     metric.Int64Counter
 	occupancyTransitions metric.Int64Counter
+	shadowFrames         metric.Int64Counter
+	shadowLatency        metric.Float64Histogram
+	trackDwell           metric.Float64Histogram
 }
 
 var (
@@ -109,6 +115,19 @@ func registerInstruments(meter metric.Meter) error {
 		metric.WithDescription("Object-detection requests")); err != nil {
 		return err
 	}
+	if ins.detectionImages, err = meter.Int64Counter("detection_images_total",
+		metric.WithDescription("Images run through object detection, by single or batched path")); err != nil {
+		return err
+	}
+	if ins.detectionBatchSize, err = meter.Int64Histogram("detection_batch_size",
+		metric.WithDescription("Images per object-detection request"),
+		metric.WithExplicitBucketBoundaries(1, 2, 3, 4, 6, 8, 12, 16, 32)); err != nil {
+		return err
+	}
+	if ins.detectionBatchWait, err = meter.Float64Histogram("detection_batch_wait_seconds",
+		metric.WithDescription("Time images wait for a batch to fill"), metric.WithUnit("s")); err != nil {
+		return err
+	}
 	if ins.preprocessDuration, err = meter.Float64Histogram("image_preprocess_duration_seconds",
 		metric.WithDescription("Image letterbox+tensor preprocessing latency"), metric.WithUnit("s")); err != nil {
 		return err
@@ -157,6 +176,18 @@ func registerInstruments(meter metric.Meter) error {
 		metric.WithDescription("Occupancy state transitions by room and target state")); err != nil {
 		return err
 	}
+	if ins.shadowFrames, err = meter.Int64Counter("shadow_frames_total",
+		metric.WithDescription("Frames sampled for the shadow model, by agreement with the primary")); err != nil {
+		return err
+	}
+	if ins.shadowLatency, err = meter.Float64Histogram("shadow_latency_seconds",
+		metric.WithDescription("Detection latency of the primary and shadow models on the same frames"), metric.WithUnit("s")); err != nil {
+		return err
+	}
+	if ins.trackDwell, err = meter.Float64Histogram("track_dwell_seconds",
+		metric.WithDescription("How long ended camera tracks were followed"), metric.WithUnit("s")); err != nil {
+		return err
+	}
 	instrumentsPtr.Store(ins)
 	return nil
 }
@@ -227,16 +258,34 @@ func boolToInt(b bool) int64 {
 
 // ---- recording helpers (all nil-safe) --------------------------------------
 
-// RecordDetection records object-detection latency and outcome.
-func RecordDetection(model, status string, dur time.Duration) {
+// RecordDetection records object-detection latency and outcome for a request
+// sent to endpoint (the inference server's address).
+func RecordDetection(model, endpoint, status string, dur time.Duration) {
 	ins := instrumentsPtr.Load()
 	if ins == nil {
 		return
 	}
 	ins.detectionDuration.Record(metricsCtx, dur.Seconds(),
-		metric.WithAttributes(attribute.String("model", model), attribute.String("outcome", status)))
+		metric.WithAttributes(attribute.String("model", model), attribute.String("endpoint", endpoint), attribute.String("outcome", status)))
 	ins.detectionRequests.Add(metricsCtx, 1,
-		metric.WithAttributes(attribute.String("model", model), attribute.String("status", status)))
+		metric.WithAttributes(attribute.String("model", model), attribute.String("endpoint", endpoint), attribute.String("status", status)))
+}
+
+// RecordInferenceBatch records one detection request of size images on the
+// "single" or "batched" path, and how long its first image waited for the
+// batch to fill. Comparing detection_images_total rates between the paths
+// shows the throughput gained by batching.
+func RecordInferenceBatch(model, path string, size int, wait time.Duration) {
+	ins := instrumentsPtr.Load()
+	if ins == nil {
+		return
+	}
+	attrs := metric.WithAttributes(attribute.String("model", model), attribute.String("path", path))
+	ins.detectionImages.Add(metricsCtx, int64(size), attrs)
+	ins.detectionBatchSize.Record(metricsCtx, int64(size), attrs)
+	if wait > 0 {
+		ins.detectionBatchWait.Record(metricsCtx, wait.Seconds(), attrs)
+	}
 }
 
 // RecordPreprocess records image preprocessing latency.
@@ -314,3 +363,30 @@ func RecordOccupancyTransition(room, toState string) {
 			metric.WithAttributes(attribute.String("room", room), attribute.String("to_state", toState)))
 	}
 }
+
+// RecordShadow counts a frame sampled for the shadow model by outcome and,
+// for frames both models analysed, records the latency of each.
+func RecordShadow(model, outcome string, primaryDur, shadowDur time.Duration) {
+	ins := instrumentsPtr.Load()
+	if ins == nil {
+		return
+	}
+	ins.shadowFrames.Add(metricsCtx, 1,
+		metric.WithAttributes(attribute.String("model", model), attribute.String("outcome", outcome)))
+	if primaryDur > 0 && shadowDur > 0 {
+		ins.shadowLatency.Record(metricsCtx, primaryDur.Seconds(),
+			metric.WithAttributes(attribute.String("model", model), attribute.String("role", "primary")))
+		ins.shadowLatency.Record(metricsCtx, shadowDur.Seconds(),
+			metric.WithAttributes(attribute.String("model", model), attribute.String("role", "shadow")))
+	}
+}
+
+// RecordTrackDwell records how long a camera followed a track that has ended.
+func RecordTrackDwell(room, label string, dwell time.Duration) {
+	ins := instrumentsPtr.Load()
+	if ins == nil {
+		return
+	}
+	ins.trackDwell.Record(metricsCtx, dwell.Seconds(),
+		metric.WithAttributes(attribute.String("room", room), attribute.String("label", label)))
+}
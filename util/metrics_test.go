@@ -24,10 +24,13 @@ func TestInitMetricsAndExposition(t *testing.T) {
 
 	// Exercise a few recording paths.
 	RecordObject("office", "person", 0.9)
-	RecordDetection("yolo11", "ok", 12*time.Millisecond)
+	RecordDetection("yolo11", "10.0.4.226:8001", "ok", 12*time.Millisecond)
+	RecordInferenceBatch("yolo11", "batched", 3, 5*time.Millisecond)
 	RecordOccupancyTransition("office", "occupied")
 	RecordMessageReceived("pic")
 	RecordPublish("ok", 3*time.Millisecond)
+	RecordShadow("yolo11s", ShadowOnlyShadow, 12*time.Millisecond, 20*time.Millisecond)
+	RecordTrackDwell("office", "person", 45*time.Second)
 
 	req := httptest.NewRequest("GET", "/metrics", nil)
 	w := httptest.NewRecorder()
@@ -40,7 +43,12 @@ func TestInitMetricsAndExposition(t *testing.T) {
 	for _, want := range []string{
 		"objects_detected_total",
 		"detection_requests_total",
+		"detection_images_total",
+		"detection_batch_size",
 		"occupancy_transitions_total",
+		"shadow_frames_total",
+		"shadow_latency_seconds",
+		"track_dwell_seconds",
 		"room_occupied",
 		"channel_queue_depth",
 	} {
@@ -2,6 +2,8 @@ package util
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,6 +19,10 @@ const ( // message types
 	MOTION    = iota
 	OCCUPANCY = iota
 	DOOR      = iota
+	BLE       = iota
+	SENSOR    = iota
+	DEVICE    = iota
+	MODE      = iota
 )
 
 const ( // analysis results
@@ -26,12 +32,26 @@ const ( // analysis results
 	MOTION_STOP  = iota
 	DOOR_OPEN    = iota
 	DOOR_CLOSED  = iota
+	BLE_PRESENT  = iota
+	BLE_ABSENT   = iota
+	SENSOR_ON    = iota
+	SENSOR_OFF   = iota
+	CAM_UNKNOWN  = iota // the detector could not look at the image
 )
 
 type Model struct {
-	Rooms    []Room   `mapstructure:"rooms"`
-	People   []Person `mapstructure:"people"`
-	Location Location `mapstructure:"location"`
+	Rooms      []Room   `mapstructure:"rooms"`
+	Zones      []Zone   `mapstructure:"zones"`
+	People     []Person `mapstructure:"people"`
+	Location   Location `mapstructure:"location"`
+	Mode_topic string   `mapstructure:"mode_topic"`
+}
+
+// Zone is a named group of rooms (e.g. "downstairs"); it is occupied while any
+// of its rooms is.
+type Zone struct {
+	Name  string   `mapstructure:"name"`
+	Rooms []string `mapstructure:"rooms"`
 }
 
 type Location struct {
@@ -45,22 +65,133 @@ func (l Location) GetCoordinates() (latitude float64, longitude float64) {
 }
 
 type Person struct {
-	Location_topic string `mapstructure:"location_topic"`
-	Name           string `mapstructure:"name"`
+	Location_topic string   `mapstructure:"location_topic"`
+	Name           string   `mapstructure:"name"`
+	Room_topic     string   `mapstructure:"room_topic"`
+	Beacon_ids     []string `mapstructure:"beacon_ids"`
 }
 
 type Room struct {
-	Name             string   `mapstructure:"name"`
-	Occupancy_topic  string   `mapstructure:"occupancy_topic"`
-	Motion_topics    []string `mapstructure:"motion_topics"`
-	Pic_topics       []string `mapstructure:"pic_topics"`
-	Door_topics      []string `mapstructure:"door_topics"`
-	Occupancy_period int64    `mapstructure:"occupancy_period"`
+	Name             string         `mapstructure:"name"`
+	Occupancy_topic  string         `mapstructure:"occupancy_topic"`
+	Motion_topics    []string       `mapstructure:"motion_topics"`
+	Pic_topics       []string       `mapstructure:"pic_topics"`
+	Door_topics      []string       `mapstructure:"door_topics"`
+	Ble_topics       []string       `mapstructure:"ble_topics"`
+	Sensor_topics    []SensorTopic  `mapstructure:"sensor_topics"`
+	Lights           []DeviceConfig `mapstructure:"lights"`
+	Sensors          []DeviceConfig `mapstructure:"sensors"`
+	Lighting         LightingConfig `mapstructure:"lighting"`
+	Occupancy_period int64          `mapstructure:"occupancy_period"`
+	Script           string         `mapstructure:"script"`
+	Lying_alert      int64          `mapstructure:"lying_alert"`  // seconds someone may lie in view before an alert; 0 disables
+	Lying_zones      []string       `mapstructure:"lying_zones"`  // image zones, such as a bed, where lying down is normal
+	Lying_aspect     bool           `mapstructure:"lying_aspect"` // without keypoints, take wide person boxes as lying
+	Image_zones      []ImageZone    `mapstructure:"image_zones"`
+	Count_lines      []CountLine    `mapstructure:"count_lines"`
+	Count_topic      string         `mapstructure:"count_topic"` // defaults to <occupancy_topic>/count
+}
+
+// LightingConfig enables occupancy-driven lighting for a room. Lights lists
+// the room lights to drive (all of them when empty). When Lux_sensor and/or
+// Night_only are set, lights only turn on if the named sensor reads below
+// Lux_threshold or it is night at the model location. Lights turn off
+// Off_delay seconds after the room goes vacant. Switching a light by hand
+// suspends automation for the room for Override_period seconds.
+type LightingConfig struct {
+	Enabled         bool     `mapstructure:"enabled"`
+	Lights          []string `mapstructure:"lights"`
+	Lux_sensor      string   `mapstructure:"lux_sensor"`
+	Lux_threshold   float64  `mapstructure:"lux_threshold"`
+	Night_only      bool     `mapstructure:"night_only"`
+	Off_delay       int64    `mapstructure:"off_delay"`
+	Override_period int64    `mapstructure:"override_period"`
+}
+
+// DeviceConfig describes an MQTT-backed light or sensor. State_field selects
+// the state from a JSON payload (dotted path); when empty the raw payload is
+// the state. Command_on/Command_off default to Payload_on/Payload_off.
+type DeviceConfig struct {
+	Name                  string `mapstructure:"name"`
+	State_topic           string `mapstructure:"state_topic"`
+	State_field           string `mapstructure:"state_field"`
+	Command_topic         string `mapstructure:"command_topic"`
+	Availability_topic    string `mapstructure:"availability_topic"`
+	Payload_on            string `mapstructure:"payload_on"`
+	Payload_off           string `mapstructure:"payload_off"`
+	Command_on            string `mapstructure:"command_on"`
+	Command_off           string `mapstructure:"command_off"`
+	Payload_available     string `mapstructure:"payload_available"`
+	Payload_not_available string `mapstructure:"payload_not_available"`
+}
+
+// WithDefaults returns the config with empty payloads filled in.
+func (d DeviceConfig) WithDefaults() DeviceConfig {
+	if d.Payload_on == "" {
+		d.Payload_on = "ON"
+	}
+	if d.Payload_off == "" {
+		d.Payload_off = "OFF"
+	}
+	if d.Command_on == "" {
+		d.Command_on = d.Payload_on
+	}
+	if d.Command_off == "" {
+		d.Command_off = d.Payload_off
+	}
+	if d.Payload_available == "" {
+		d.Payload_available = "online"
+	}
+	if d.Payload_not_available == "" {
+		d.Payload_not_available = "offline"
+	}
+	return d
+}
+
+// Topics returns the state and availability topics the device listens on.
+func (d DeviceConfig) Topics() []string {
+	var out []string
+	if d.State_topic != "" {
+		out = append(out, d.State_topic)
+	}
+	if d.Availability_topic != "" {
+		out = append(out, d.Availability_topic)
+	}
+	return out
+}
+
+// deviceTopics returns the state and availability topics of every light and
+// sensor in the room.
+func (r Room) deviceTopics() []string {
+	var out []string
+	for _, d := range r.Lights {
+		out = append(out, d.Topics()...)
+	}
+	for _, d := range r.Sensors {
+		out = append(out, d.Topics()...)
+	}
+	return out
+}
+
+// SensorTopic is a numeric sensor (CO2 ppm, power draw, sound level...) used
+// as occupancy evidence. A reading at or above Threshold (or below it, when
+// Below is set) makes the sensor active; it stays active until a reading no
+// longer qualifies, and for Hold seconds after that. Field selects a value
+// from a JSON payload using a dotted path; when empty the payload itself must
+// be a number.
+type SensorTopic struct {
+	Topic     string  `mapstructure:"topic"`
+	Field     string  `mapstructure:"field"`
+	Threshold float64 `mapstructure:"threshold"`
+	Hold      int64   `mapstructure:"hold"`
+	Below     bool    `mapstructure:"below"`
 }
 
 type RoomStatus struct {
 	last_occupied int64
 	motion_state  bool
+	ble_present   bool
+	sensor_active bool
 	occupied      bool
 }
 
@@ -85,6 +216,22 @@ func (m *RoomStatus) Motion(state bool) {
 	m.Occupied()
 }
 
+// Presence records whether a known person's beacon is currently placed in the
+// room. Arriving presence also refreshes the occupancy timer.
+func (m *RoomStatus) Presence(state bool) {
+	m.ble_present = state
+	if state {
+		m.Occupied()
+	}
+}
+
+// Sensor records whether any of the room's threshold sensors is active. Like
+// motion, either edge refreshes the occupancy timer.
+func (m *RoomStatus) Sensor(state bool) {
+	m.sensor_active = state
+	m.Occupied()
+}
+
 func (m *Model) UpdateRoomStatus(room string, item RoomStatus) {
 	statusMu.Lock()
 	defer statusMu.Unlock()
@@ -127,6 +274,14 @@ func (m *RoomStatus) GetMotionState() bool {
 	return m.motion_state
 }
 
+func (m *RoomStatus) GetPresenceState() bool {
+	return m.ble_present
+}
+
+func (m *RoomStatus) GetSensorState() bool {
+	return m.sensor_active
+}
+
 func newModelStatus() *ModelStatus {
 	s := ModelStatus{}
 	s.Room_status = make(map[string]RoomStatus)
@@ -153,11 +308,29 @@ func (m Model) FindRoomByTopic(topic string) string {
 				return entry.Name
 			}
 		}
+		for _, bt := range entry.Ble_topics {
+			if topicMatches(bt, topic) {
+				return entry.Name
+			}
+		}
+		for _, st := range entry.Sensor_topics {
+			if st.Topic == topic {
+				return entry.Name
+			}
+		}
+		for _, dt := range entry.deviceTopics() {
+			if dt == topic {
+				return entry.Name
+			}
+		}
 	}
 	return ""
 }
 
 func (m Model) FindTopicType(topic string) int {
+	if m.Mode_topic != "" && m.Mode_topic == topic {
+		return MODE
+	}
 	for _, entry := range m.Rooms {
 		if entry.Occupancy_topic == topic {
 			return OCCUPANCY
@@ -177,10 +350,109 @@ func (m Model) FindTopicType(topic string) int {
 				return DOOR
 			}
 		}
+		for _, st := range entry.Sensor_topics {
+			if st.Topic == topic {
+				return SENSOR
+			}
+		}
+		for _, bt := range entry.Ble_topics {
+			if topicMatches(bt, topic) {
+				return BLE
+			}
+		}
+		for _, dt := range entry.deviceTopics() {
+			if dt == topic {
+				return DEVICE
+			}
+		}
 	}
 	return -1
 }
 
+// topicMatches reports whether topic matches an MQTT subscription filter,
+// honouring the single-level (+) and multi-level (#) wildcards. BLE topics are
+// usually configured as filters (e.g. espresense/devices/+/office) since one
+// node reports many beacons.
+func topicMatches(filter, topic string) bool {
+	if filter == topic {
+		return true
+	}
+	fparts := strings.Split(filter, "/")
+	tparts := strings.Split(topic, "/")
+	for i, f := range fparts {
+		if f == "#" {
+			return i == len(fparts)-1
+		}
+		if i >= len(tparts) {
+			return false
+		}
+		if f != "+" && f != tparts[i] {
+			return false
+		}
+	}
+	return len(fparts) == len(tparts)
+}
+
+// FindSensorTopic returns the threshold sensor config for topic.
+func (m Model) FindSensorTopic(topic string) (SensorTopic, bool) {
+	for _, entry := range m.Rooms {
+		for _, st := range entry.Sensor_topics {
+			if st.Topic == topic {
+				return st, true
+			}
+		}
+	}
+	return SensorTopic{}, false
+}
+
+// FindPersonByBeacon returns the name of the person owning the given BLE
+// beacon id, or "" if the beacon is not mapped.
+func (m Model) FindPersonByBeacon(id string) string {
+	for _, p := range m.People {
+		for _, b := range p.Beacon_ids {
+			if strings.EqualFold(b, id) {
+				return p.Name
+			}
+		}
+	}
+	return ""
+}
+
+// PersonRoomTopic returns the topic a person's current room is published to,
+// defaulting to hab/model/person/<name>/room.
+func (m Model) PersonRoomTopic(name string) string {
+	for _, p := range m.People {
+		if p.Name == name && p.Room_topic != "" {
+			return p.Room_topic
+		}
+	}
+	return "hab/model/person/" + name + "/room"
+}
+
+// FindRoom returns the config for the named room.
+func (m Model) FindRoom(name string) (Room, bool) {
+	for _, entry := range m.Rooms {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return Room{}, false
+}
+
+// ZonesForRoom returns the names of the zones containing room.
+func (m Model) ZonesForRoom(room string) []string {
+	var out []string
+	for _, z := range m.Zones {
+		for _, r := range z.Rooms {
+			if r == room {
+				out = append(out, z.Name)
+				break
+			}
+		}
+	}
+	return out
+}
+
 func (m Model) FindOccupancyTopicByRoom(room string) string {
 	for _, entry := range m.Rooms {
 		if entry.Name == room {
@@ -205,6 +477,26 @@ func (m *Model) BuildModel() error {
 		Logger.Error().Msgf("error unmarshaling model: %v", err)
 		return fmt.Errorf("error")
 	}
+	for _, r := range m.Rooms {
+		for _, z := range r.Image_zones {
+			if err := z.validate(); err != nil {
+				Logger.Error().Msgf("room %s: %v", r.Name, err)
+				return fmt.Errorf("room %s: %w", r.Name, err)
+			}
+		}
+		for _, name := range r.Lying_zones {
+			if !slices.ContainsFunc(r.Image_zones, func(z ImageZone) bool { return z.Name == name }) {
+				Logger.Error().Msgf("room %s: lying_zones names unknown image zone %s", r.Name, name)
+				return fmt.Errorf("room %s: lying_zones names unknown image zone %s", r.Name, name)
+			}
+		}
+		for _, l := range r.Count_lines {
+			if err := l.validate(); err != nil {
+				Logger.Error().Msgf("room %s: %v", r.Name, err)
+				return fmt.Errorf("room %s: %w", r.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -227,6 +519,14 @@ func (m Model) SubscribeTopics() []string {
 		topics = append(topics, room.Motion_topics...)
 		topics = append(topics, room.Pic_topics...)
 		topics = append(topics, room.Door_topics...)
+		topics = append(topics, room.Ble_topics...)
+		for _, st := range room.Sensor_topics {
+			topics = append(topics, st.Topic)
+		}
+		topics = append(topics, room.deviceTopics()...)
+	}
+	if m.Mode_topic != "" {
+		topics = append(topics, m.Mode_topic)
 	}
 	return topics
 }
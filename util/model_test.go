@@ -55,6 +55,9 @@ func TestModel_FindTopicType(t *testing.T) {
 				Motion_topics:   []string{"hab/test/motion"},
 				Pic_topics:      []string{"hab/test/camera"},
 				Door_topics:     []string{"hab/test/door"},
+				Ble_topics:      []string{"espresense/devices/+/test"},
+				Sensor_topics:   []SensorTopic{{Topic: "hab/test/tv_power", Threshold: 40}},
+				Lights:          []DeviceConfig{{Name: "light", State_topic: "z2m/test_light"}},
 			},
 		},
 	}
@@ -68,6 +71,9 @@ func TestModel_FindTopicType(t *testing.T) {
 		{"Motion type", "hab/test/motion", MOTION},
 		{"Picture type", "hab/test/camera", PIC},
 		{"Door type", "hab/test/door", DOOR},
+		{"BLE type", "espresense/devices/beacon1/test", BLE},
+		{"Sensor type", "hab/test/tv_power", SENSOR},
+		{"Device type", "z2m/test_light", DEVICE},
 		{"Unknown type", "hab/unknown", -1},
 	}
 
@@ -81,6 +87,80 @@ func TestModel_FindTopicType(t *testing.T) {
 	}
 }
 
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/b/c", "a/b", false},
+	}
+
+	for _, tt := range tests {
+		if result := topicMatches(tt.filter, tt.topic); result != tt.expected {
+			t.Errorf("topicMatches(%s, %s) = %v, expected %v", tt.filter, tt.topic, result, tt.expected)
+		}
+	}
+}
+
+func TestModel_FindPersonByBeacon(t *testing.T) {
+	model := Model{
+		People: []Person{
+			{Name: "alice", Beacon_ids: []string{"irk:aaaa", "phone:1"}},
+			{Name: "bob", Beacon_ids: []string{"irk:bbbb"}, Room_topic: "custom/bob"},
+		},
+	}
+
+	if p := model.FindPersonByBeacon("phone:1"); p != "alice" {
+		t.Errorf("FindPersonByBeacon(phone:1) = %s, expected alice", p)
+	}
+	if p := model.FindPersonByBeacon("IRK:BBBB"); p != "bob" {
+		t.Errorf("FindPersonByBeacon(IRK:BBBB) = %s, expected bob", p)
+	}
+	if p := model.FindPersonByBeacon("unknown"); p != "" {
+		t.Errorf("FindPersonByBeacon(unknown) = %s, expected empty", p)
+	}
+	if topic := model.PersonRoomTopic("alice"); topic != "hab/model/person/alice/room" {
+		t.Errorf("PersonRoomTopic(alice) = %s", topic)
+	}
+	if topic := model.PersonRoomTopic("bob"); topic != "custom/bob" {
+		t.Errorf("PersonRoomTopic(bob) = %s", topic)
+	}
+}
+
+func TestModel_ZonesAndModeTopic(t *testing.T) {
+	model := Model{
+		Rooms:      []Room{{Name: "kitchen"}, {Name: "office"}},
+		Zones:      []Zone{{Name: "downstairs", Rooms: []string{"kitchen"}}, {Name: "house", Rooms: []string{"kitchen", "office"}}},
+		Mode_topic: "hab/house/mode",
+	}
+
+	if zones := model.ZonesForRoom("kitchen"); len(zones) != 2 {
+		t.Errorf("ZonesForRoom(kitchen) = %v, expected 2 zones", zones)
+	}
+	if zones := model.ZonesForRoom("office"); len(zones) != 1 || zones[0] != "house" {
+		t.Errorf("ZonesForRoom(office) = %v, expected [house]", zones)
+	}
+	if model.FindTopicType("hab/house/mode") != MODE {
+		t.Error("mode topic should be typed MODE")
+	}
+	found := false
+	for _, topic := range model.SubscribeTopics() {
+		if topic == "hab/house/mode" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("SubscribeTopics() should include the mode topic")
+	}
+}
+
 func TestModel_FindOccupancyTopicByRoom(t *testing.T) {
 	model := Model{
 		Rooms: []Room{
@@ -216,6 +296,20 @@ func TestRoomStatus_Methods(t *testing.T) {
 	if status.GetMotionState() != false {
 		t.Error("Motion(false) should set motion_state to false")
 	}
+
+	status.Sensor(true)
+	if !status.GetSensorState() {
+		t.Error("Sensor(true) should set sensor_active to true")
+	}
+	status.Sensor(false)
+	if status.GetSensorState() {
+		t.Error("Sensor(false) should set sensor_active to false")
+	}
+
+	status.Presence(true)
+	if !status.GetPresenceState() {
+		t.Error("Presence(true) should set ble_present to true")
+	}
 }
 
 func TestModel_BuildModel(t *testing.T) {
@@ -320,3 +414,20 @@ func TestModel_UpdateRoomStatus(t *testing.T) {
 		t.Error("Updated room status should have correct occupied state")
 	}
 }
+
+func TestBuildModelLyingZones(t *testing.T) {
+	defer Config.Set("model", nil)
+	room := map[string]interface{}{
+		"name":        "bedroom",
+		"image_zones": []map[string]interface{}{{"name": "bed", "polygon": [][]int{{0, 0}, {10, 0}, {10, 10}}}},
+		"lying_zones": []string{"bed"},
+	}
+	Config.Set("model", map[string]interface{}{"rooms": []map[string]interface{}{room}})
+	if err := (&Model{}).BuildModel(); err != nil {
+		t.Errorf("BuildModel() returned error: %v", err)
+	}
+	room["lying_zones"] = []string{"sofa"}
+	if err := (&Model{}).BuildModel(); err == nil {
+		t.Error("BuildModel() accepted a lying zone that is not an image zone")
+	}
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PayloadValue returns the value selected from an MQTT payload. With an empty
+// field the whole (trimmed) payload is returned; otherwise field is a dotted
+// path into a JSON object (e.g. "state" or "ENERGY.Power"). Numbers and bools
+// are returned in their JSON text form.
+func PayloadValue(data []byte, field string) (string, error) {
+	if field == "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(field, ".") {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("field %s: not an object", field)
+		}
+		if doc, ok = obj[key]; !ok {
+			return "", fmt.Errorf("field %s: %s missing", field, key)
+		}
+	}
+	switch v := doc.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("field %s: not a scalar", field)
+	}
+}
+
+// PayloadNumber returns the numeric value selected from an MQTT payload (see
+// PayloadValue).
+func PayloadNumber(data []byte, field string) (float64, error) {
+	v, err := PayloadValue(data, field)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestPayloadValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		field    string
+		expected string
+	}{
+		{"Raw payload", " ON\n", "", "ON"},
+		{"String field", `{"state": "OFF", "brightness": 120}`, "state", "OFF"},
+		{"Number field", `{"state": "OFF", "brightness": 120}`, "brightness", "120"},
+		{"Bool field", `{"occupancy": true}`, "occupancy", "true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := PayloadValue([]byte(tt.data), tt.field)
+			if err != nil {
+				t.Fatalf("PayloadValue error: %v", err)
+			}
+			if value != tt.expected {
+				t.Errorf("PayloadValue = %q, expected %q", value, tt.expected)
+			}
+		})
+	}
+
+	if _, err := PayloadValue([]byte(`{"a": {"b": 1}}`), "a"); err == nil {
+		t.Error("PayloadValue should reject non-scalar fields")
+	}
+}
+
+func TestPayloadNumber(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		field    string
+		expected float64
+		wantErr  bool
+	}{
+		{"Plain number", "82.5", "", 82.5, false},
+		{"Plain number with whitespace", " 612\n", "", 612, false},
+		{"JSON field", `{"power": 80, "voltage": 120}`, "power", 80, false},
+		{"Nested JSON field", `{"ENERGY": {"Power": 12.5}}`, "ENERGY.Power", 12.5, false},
+		{"Numeric string field", `{"co2": "950"}`, "co2", 950, false},
+		{"Missing field", `{"power": 80}`, "co2", 0, true},
+		{"Not a number", "ON", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := PayloadNumber([]byte(tt.data), tt.field)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PayloadNumber error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && value != tt.expected {
+				t.Errorf("PayloadNumber = %v, expected %v", value, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package util
+
+import "math"
+
+// Pose estimation. YOLO-pose models (yolov8_pose, yolov8_pose_transposed and
+// end2end_pose; see yolo_decode.go) report the 17 COCO keypoints of each
+// person after its box and class scores, as x, y and visibility. Keypoints
+// are mapped back to image pixels with the box and kept on the detection.
+
+// PoseKeypoints is the number of keypoints a pose model reports per person.
+const PoseKeypoints = 17
+
+// COCO keypoint indices.
+const (
+	KeypointNose = iota
+	KeypointLeftEye
+	KeypointRightEye
+	KeypointLeftEar
+	KeypointRightEar
+	KeypointLeftShoulder
+	KeypointRightShoulder
+	KeypointLeftElbow
+	KeypointRightElbow
+	KeypointLeftWrist
+	KeypointRightWrist
+	KeypointLeftHip
+	KeypointRightHip
+	KeypointLeftKnee
+	KeypointRightKnee
+	KeypointLeftAnkle
+	KeypointRightAnkle
+)
+
+// PoseSkeleton lists the keypoint pairs joined by a limb, for drawing.
+var PoseSkeleton = [][2]int{
+	{KeypointLeftAnkle, KeypointLeftKnee}, {KeypointLeftKnee, KeypointLeftHip},
+	{KeypointRightAnkle, KeypointRightKnee}, {KeypointRightKnee, KeypointRightHip},
+	{KeypointLeftHip, KeypointRightHip},
+	{KeypointLeftShoulder, KeypointLeftHip}, {KeypointRightShoulder, KeypointRightHip},
+	{KeypointLeftShoulder, KeypointRightShoulder},
+	{KeypointLeftShoulder, KeypointLeftElbow}, {KeypointRightShoulder, KeypointRightElbow},
+	{KeypointLeftElbow, KeypointLeftWrist}, {KeypointRightElbow, KeypointRightWrist},
+	{KeypointLeftEye, KeypointRightEye}, {KeypointNose, KeypointLeftEye}, {KeypointNose, KeypointRightEye},
+	{KeypointLeftEye, KeypointLeftEar}, {KeypointRightEye, KeypointRightEar},
+	{KeypointLeftEar, KeypointLeftShoulder}, {KeypointRightEar, KeypointRightShoulder},
+}
+
+// Keypoint is one body keypoint in image pixels. Confidence is the model's
+// visibility score.
+type Keypoint struct {
+	X          int     `json:"x"`
+	Y          int     `json:"y"`
+	Confidence float32 `json:"confidence"`
+}
+
+// KeypointVisible is the visibility score above which a keypoint is used.
+const KeypointVisible = 0.5
+
+// lyingAngle is how far from vertical, in degrees, the torso must lean for a
+// person to count as lying; lyingAspect is how much wider than tall a box
+// without usable keypoints must be.
+const (
+	lyingAngle  = 60
+	lyingAspect = 1.3
+)
+
+// IsLying reports whether a person detection looks like someone lying down:
+// the line from mid-shoulders to mid-hips is closer to horizontal than
+// vertical or, without visible shoulders and hips and with aspectFallback
+// set, the box is clearly wider than it is tall.
+func IsLying(d TritonDetection, aspectFallback bool) bool {
+	if d.Label != "person" {
+		return false
+	}
+	if shoulders, ok := midpoint(d.Keypoints, KeypointLeftShoulder, KeypointRightShoulder); ok {
+		if hips, ok := midpoint(d.Keypoints, KeypointLeftHip, KeypointRightHip); ok {
+			dx, dy := hips[0]-shoulders[0], hips[1]-shoulders[1]
+			if dx != 0 || dy != 0 {
+				angle := math.Atan2(math.Abs(dx), math.Abs(dy)) * 180 / math.Pi
+				return angle > lyingAngle
+			}
+		}
+	}
+	if !aspectFallback {
+		return false
+	}
+	w, h := d.XMax-d.XMin, d.YMax-d.YMin
+	return h > 0 && float64(w) > lyingAspect*float64(h)
+}
+
+// midpoint averages the visible keypoints of a left/right pair.
+func midpoint(kpts []Keypoint, a, b int) ([2]float64, bool) {
+	var sum [2]float64
+	n := 0
+	for _, i := range []int{a, b} {
+		if i < len(kpts) && kpts[i].Confidence >= KeypointVisible {
+			sum[0] += float64(kpts[i].X)
+			sum[1] += float64(kpts[i].Y)
+			n++
+		}
+	}
+	if n == 0 {
+		return sum, false
+	}
+	return [2]float64{sum[0] / float64(n), sum[1] / float64(n)}, true
+}
@@ -0,0 +1,98 @@
+package util
+
+import "testing"
+
+func TestDecodeYOLOPose(t *testing.T) {
+	labels := []string{"person"}
+	// One person box, with the nose at (50, 45) and the left shoulder
+	// at (45, 52) hidden.
+	kpts := func(attrsFirst bool) outputTensor {
+		out := denseOutput(attrsFirst, false, 1+poseAttrs, 100)
+		attrs := 5 + poseAttrs
+		set := func(attr int, v float32) {
+			if attrsFirst {
+				out.data[attr*100+3] = v
+			} else {
+				out.data[3*attrs+attr] = v
+			}
+		}
+		set(5, 50)
+		set(6, 45)
+		set(7, 0.9)
+		set(5+3*KeypointLeftShoulder, 45)
+		set(6+3*KeypointLeftShoulder, 52)
+		set(7+3*KeypointLeftShoulder, 0.2)
+		return out
+	}
+	row := make([]float32, 6+poseAttrs)
+	copy(row, []float32{40, 40, 60, 60, 0.8, 0, 50, 45, 0.9})
+	copy(row[6+3*KeypointLeftShoulder:], []float32{45, 52, 0.2})
+	end2end := outputTensor{name: "output0", shape: []int64{1, 1, int64(len(row))}, data: row}
+
+	for _, tt := range []struct {
+		format string
+		output outputTensor
+	}{
+		{YOLOFormatPose, kpts(true)},
+		{YOLOFormatPoseTransposed, kpts(false)},
+		{YOLOFormatEnd2EndPose, end2end},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			outputs := []outputTensor{tt.output}
+			if auto, err := detectYOLOFormat(outputs, len(labels)); err != nil || auto != tt.format {
+				t.Errorf("detectYOLOFormat = %q, %v", auto, err)
+			}
+			dets, err := decodeYOLOOutputs(tt.format, outputs, testLetterbox, labels, 0.5, 0.45)
+			if err != nil || len(dets) != 1 {
+				t.Fatalf("decode = %+v, %v", dets, err)
+			}
+			d := dets[0]
+			if d.Label != "person" || d.XMin != 40 || d.YMax != 60 || len(d.Keypoints) != PoseKeypoints {
+				t.Fatalf("unexpected detection %+v", d)
+			}
+			if k := d.Keypoints[KeypointNose]; k.X != 50 || k.Y != 45 || k.Confidence != 0.9 {
+				t.Errorf("nose %+v", k)
+			}
+			if k := d.Keypoints[KeypointLeftShoulder]; k.X != 45 || k.Y != 52 || k.Confidence != 0.2 {
+				t.Errorf("left shoulder %+v", k)
+			}
+		})
+	}
+
+	// A plain detector with 52 classes keeps its 56 attributes.
+	if auto, _ := detectYOLOFormat([]outputTensor{kpts(true)}, 1+poseAttrs); auto != YOLOFormatV8 {
+		t.Errorf("52-class model detected as %q", auto)
+	}
+}
+
+func TestIsLying(t *testing.T) {
+	pose := func(shoulder, hip Keypoint) []Keypoint {
+		k := make([]Keypoint, PoseKeypoints)
+		k[KeypointLeftShoulder], k[KeypointRightShoulder] = shoulder, shoulder
+		k[KeypointLeftHip], k[KeypointRightHip] = hip, hip
+		return k
+	}
+	tall := TritonDetection{Label: "person", XMin: 0, YMin: 0, XMax: 40, YMax: 100}
+	wide := TritonDetection{Label: "person", XMin: 0, YMin: 0, XMax: 100, YMax: 40}
+	for _, tt := range []struct {
+		name   string
+		d      TritonDetection
+		kpts   []Keypoint
+		aspect bool
+		want   bool
+	}{
+		{"standing box", tall, nil, true, false},
+		{"wide box", wide, nil, true, true},
+		{"wide box without fallback", wide, nil, false, false},
+		{"wide cat", TritonDetection{Label: "cat", XMax: 100, YMax: 40}, nil, true, false},
+		{"upright torso", wide, pose(Keypoint{X: 20, Y: 10, Confidence: 0.9}, Keypoint{X: 25, Y: 50, Confidence: 0.9}), true, false},
+		{"flat torso", tall, pose(Keypoint{X: 10, Y: 30, Confidence: 0.9}, Keypoint{X: 50, Y: 35, Confidence: 0.9}), false, true},
+		{"hidden hips", wide, pose(Keypoint{X: 20, Y: 10, Confidence: 0.9}, Keypoint{X: 25, Y: 50, Confidence: 0.1}), true, true},
+		{"hidden hips without fallback", wide, pose(Keypoint{X: 20, Y: 10, Confidence: 0.9}, Keypoint{X: 25, Y: 50, Confidence: 0.1}), false, false},
+	} {
+		tt.d.Keypoints = tt.kpts
+		if got := IsLying(tt.d, tt.aspect); got != tt.want {
+			t.Errorf("%s: IsLying = %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
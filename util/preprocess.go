@@ -0,0 +1,200 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Image preprocessing for YOLO models: letterbox the decoded JPEG onto a grey
+// canvas of the model's input size, then write the canvas out as an NCHW
+// tensor. Both steps work on raw pixel buffers: the scaler takes the JPEG
+// decoder's YCbCr planes directly, the canvas is filled and read through its
+// Pix slice, and tensor bytes come from per-datatype lookup tables rather than
+// per-pixel float arithmetic. Canvases and tensors are pooled across requests;
+// callers hand tensors back with releaseTensor once the request has been sent.
+
+const letterboxGrey = 114
+
+var (
+	canvasPool sync.Pool // *image.RGBA
+	tensorPool sync.Pool // *[]byte
+)
+
+// pixelFP32 and pixelFP16 map an 8-bit channel value to its encoding
+// normalised to [0, 1].
+var (
+	pixelFP32 = func() (t [256]uint32) {
+		for i := range t {
+			t[i] = math.Float32bits(float32(i) / 255) //nolint:mnd
+		}
+		return t
+	}()
+	pixelFP16 = func() (t [256]uint16) {
+		for i := range t {
+			t[i] = float32ToFloat16(float32(i) / 255) //nolint:mnd
+		}
+		return t
+	}()
+)
+
+// prepareInput builds one image's model input: a letterboxed tensor, or for
+// models that decode on the server the JPEG itself, as one element of a BYTES
+// or UINT8 tensor (see triton_input_modes). The server must letterbox the same
+// way prepareYOLOInput does so boxes map back to the image. The input comes
+// from a pool; pass it to releaseTensor when done with it.
+func prepareInput(jpegData []byte, p yoloParams) ([]byte, letterbox, error) {
+	if !p.jpeg {
+		return prepareYOLOInput(jpegData, p.width, p.height, p.datatype)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(jpegData))
+	if err != nil {
+		return nil, letterbox{}, fmt.Errorf("%w header: %w", errImageDecode, err)
+	}
+	lb := letterbox{origW: cfg.Width, origH: cfg.Height}
+	lb.scale, _, _, lb.padX, lb.padY = letterboxGeometry(cfg.Width, cfg.Height, p.width, p.height)
+	if p.datatype != InputBYTES {
+		input := getTensor(len(jpegData))
+		copy(input, jpegData)
+		return input, lb, nil
+	}
+	// A BYTES element is its length as a little-endian uint32, then the bytes.
+	input := getTensor(4 + len(jpegData))
+	binary.LittleEndian.PutUint32(input, uint32(len(jpegData))) //nolint:gosec // JPEGs are far below 4 GB
+	copy(input[4:], jpegData)
+	return input, lb, nil
+}
+
+// prepareYOLOInput decodes a JPEG, letterboxes it to width×height and returns
+// it as a little-endian NCHW tensor of the given datatype. The tensor comes
+// from a pool; pass it to releaseTensor when done with it.
+func prepareYOLOInput(jpegData []byte, width, height int, datatype string) ([]byte, letterbox, error) {
+	preStart := time.Now()
+	size := inputElementSize(datatype)
+	if size == 0 {
+		return nil, letterbox{}, fmt.Errorf("unsupported input datatype %s", datatype)
+	}
+	imgRaw, _, err := image.Decode(bytes.NewReader(jpegData))
+	if err != nil {
+		return nil, letterbox{}, fmt.Errorf("%w: %w", errImageDecode, err)
+	}
+	lb := letterbox{origW: imgRaw.Bounds().Dx(), origH: imgRaw.Bounds().Dy()}
+
+	// Letterbox resize to keep aspect ratio, pad with grey (114).
+	canvas := getCanvas(width, height)
+	lb.scale, lb.padX, lb.padY = letterboxInto(canvas, imgRaw)
+
+	tensor := getTensor(3 * width * height * size)
+	writeNCHW(tensor, canvas, datatype)
+	canvasPool.Put(canvas)
+
+	RecordPreprocess(time.Since(preStart))
+	return tensor, lb, nil
+}
+
+// letterboxInto draws src scaled and centred onto canvas, overwriting every
+// pixel, and returns the scale factor and padding.
+func letterboxInto(canvas *image.RGBA, src image.Image) (float64, int, int) {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	scale, newW, newH, padX, padY := letterboxGeometry(srcW, srcH, canvas.Rect.Dx(), canvas.Rect.Dy())
+	dst := image.Rect(padX, padY, padX+newW, padY+newH)
+
+	fillGrey(canvas, dst)
+	// The source is opaque, so Src gives the same result as Over and keeps
+	// the scaler on its YCbCr/RGBA fast paths. ApproxBiLinear samples the
+	// nearest 2×2 pixels like OpenCV's INTER_LINEAR, which YOLO models are
+	// trained with, and unlike BiLinear needs no per-call scratch buffer.
+	if newW == srcW && newH == srcH {
+		draw.Draw(canvas, dst, src, src.Bounds().Min, draw.Src)
+	} else {
+		draw.ApproxBiLinear.Scale(canvas, dst, src, src.Bounds(), draw.Src, nil)
+	}
+	return scale, padX, padY
+}
+
+// letterboxGeometry fits srcW×srcH inside targetW×targetH, preserving the
+// aspect ratio and centring it, and returns the scale, scaled size and padding.
+func letterboxGeometry(srcW, srcH, targetW, targetH int) (scale float64, newW, newH, padX, padY int) {
+	scale = math.Min(float64(targetW)/float64(srcW), float64(targetH)/float64(srcH))
+	newW = int(math.Round(float64(srcW) * scale))
+	newH = int(math.Round(float64(srcH) * scale))
+	return scale, newW, newH, (targetW - newW) / 2, (targetH - newH) / 2
+}
+
+// fillGrey paints the canvas outside inner with the letterbox grey, copying
+// one prepared row rather than setting pixels individually.
+func fillGrey(canvas *image.RGBA, inner image.Rectangle) {
+	w := canvas.Rect.Dx()
+	row := canvas.Pix[:w*4]
+	for i := 0; i < len(row); i += 4 {
+		row[i], row[i+1], row[i+2], row[i+3] = letterboxGrey, letterboxGrey, letterboxGrey, 0xff
+	}
+	for y := 1; y < canvas.Rect.Dy(); y++ {
+		line := canvas.Pix[y*canvas.Stride : y*canvas.Stride+w*4]
+		if y < inner.Min.Y || y >= inner.Max.Y {
+			copy(line, row)
+			continue
+		}
+		copy(line[:inner.Min.X*4], row)
+		copy(line[inner.Max.X*4:], row)
+	}
+}
+
+// writeNCHW writes the RGB channels of img, in planar NCHW order, into dst as
+// little-endian values of the given datatype normalised to [0, 1] (UINT8 keeps
+// the 0-255 values). dst must hold 3×w×h elements.
+func writeNCHW(dst []byte, img *image.RGBA, datatype string) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	plane := w * h
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		i := y * w
+		switch datatype {
+		case InputFP32:
+			for x := 0; x < w; x, i = x+1, i+1 {
+				p := row[x*4 : x*4+3]
+				binary.LittleEndian.PutUint32(dst[i*4:], pixelFP32[p[0]])
+				binary.LittleEndian.PutUint32(dst[(plane+i)*4:], pixelFP32[p[1]])
+				binary.LittleEndian.PutUint32(dst[(2*plane+i)*4:], pixelFP32[p[2]])
+			}
+		case InputFP16:
+			for x := 0; x < w; x, i = x+1, i+1 {
+				p := row[x*4 : x*4+3]
+				binary.LittleEndian.PutUint16(dst[i*2:], pixelFP16[p[0]])
+				binary.LittleEndian.PutUint16(dst[(plane+i)*2:], pixelFP16[p[1]])
+				binary.LittleEndian.PutUint16(dst[(2*plane+i)*2:], pixelFP16[p[2]])
+			}
+		case InputUINT8:
+			for x := 0; x < w; x, i = x+1, i+1 {
+				p := row[x*4 : x*4+3]
+				dst[i], dst[plane+i], dst[2*plane+i] = p[0], p[1], p[2]
+			}
+		}
+	}
+}
+
+func getCanvas(w, h int) *image.RGBA {
+	if c, ok := canvasPool.Get().(*image.RGBA); ok && c.Rect.Dx() == w && c.Rect.Dy() == h {
+		return c
+	}
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+func getTensor(n int) []byte {
+	if b, ok := tensorPool.Get().(*[]byte); ok && cap(*b) >= n {
+		return (*b)[:n]
+	}
+	return make([]byte, n)
+}
+
+// releaseTensor returns a tensor from prepareYOLOInput to the pool. It must
+// not be used afterwards.
+func releaseTensor(b []byte) {
+	tensorPool.Put(&b)
+}
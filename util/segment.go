@@ -0,0 +1,121 @@
+package util
+
+import "math"
+
+// Instance segmentation. YOLO-seg models (yolov8_seg and
+// yolov8_seg_transposed; see yolo_decode.go) have two outputs: the usual
+// boxes and class scores followed by M mask coefficients per anchor, and a
+// [1, M, H, W] prototype tensor at a fraction of the input resolution. A
+// detection's mask is the sigmoid of its coefficients combined with the
+// prototypes, cut to its box and mapped back to image pixels.
+
+// Mask is a detection's segmentation mask in image pixels. It covers the
+// detection's box; Runs are the lengths of alternating runs of pixels outside
+// and inside the mask, row by row across the box, starting outside.
+type Mask struct {
+	X      int   `json:"x"`
+	Y      int   `json:"y"`
+	Width  int   `json:"width"`
+	Height int   `json:"height"`
+	Runs   []int `json:"runs"`
+}
+
+// newMask encodes the pixels of the w×h box at (x, y) for which in is true.
+func newMask(x, y, w, h int, in func(x, y int) bool) *Mask {
+	m := &Mask{X: x, Y: y, Width: w, Height: h}
+	inside, run := false, 0
+	for py := y; py < y+h; py++ {
+		for px := x; px < x+w; px++ {
+			if in(px, py) != inside {
+				m.Runs = append(m.Runs, run)
+				inside, run = !inside, 0
+			}
+			run++
+		}
+	}
+	m.Runs = append(m.Runs, run)
+	return m
+}
+
+// Each calls fn with every pixel inside the mask.
+func (m *Mask) Each(fn func(x, y int)) {
+	if m == nil || m.Width <= 0 {
+		return
+	}
+	pos := 0
+	for i, run := range m.Runs {
+		if i%2 == 1 {
+			for p := pos; p < pos+run; p++ {
+				fn(m.X+p%m.Width, m.Y+p/m.Width)
+			}
+		}
+		pos += run
+	}
+}
+
+// Area is the number of pixels inside the mask.
+func (m *Mask) Area() int {
+	if m == nil {
+		return 0
+	}
+	n := 0
+	for i := 1; i < len(m.Runs); i += 2 {
+		n += m.Runs[i]
+	}
+	return n
+}
+
+// segOutputs tells the detection output of a segmentation model, [1, attrs,
+// anchors] or transposed, from its [1, M, H, W] prototypes.
+func segOutputs(outputs []outputTensor) (dets, protos outputTensor, ok bool) {
+	if len(outputs) != 2 {
+		return dets, protos, false
+	}
+	for i, o := range outputs {
+		if len(o.shape) == 4 && len(outputs[1-i].shape) == 3 {
+			return outputs[1-i], o, true
+		}
+	}
+	return dets, protos, false
+}
+
+// decodeMask builds the mask of detection d, whose box had the given mask
+// coefficients, from the prototypes.
+func decodeMask(coeffs []float32, protos outputTensor, lb letterbox, d TritonDetection) *Mask {
+	dims := dropBatch(protos.shape, 3)
+	if len(dims) != 3 || int(dims[0]) != len(coeffs) || dims[1] <= 0 || dims[2] <= 0 {
+		return nil
+	}
+	nm, ph, pw := int(dims[0]), int(dims[1]), int(dims[2])
+	if len(protos.data) < nm*ph*pw || d.XMax <= d.XMin || d.YMax <= d.YMin || lb.scale <= 0 {
+		return nil
+	}
+	// The prototypes span the letterboxed model input.
+	inW := math.Round(float64(lb.origW)*lb.scale) + float64(2*lb.padX)
+	inH := math.Round(float64(lb.origH)*lb.scale) + float64(2*lb.padY)
+	protoX := func(x int) int {
+		return clampInt(int((float64(x)+0.5)*lb.scale+float64(lb.padX))*pw/int(inW), 0, pw-1) //nolint:mnd
+	}
+	protoY := func(y int) int {
+		return clampInt(int((float64(y)+0.5)*lb.scale+float64(lb.padY))*ph/int(inH), 0, ph-1) //nolint:mnd
+	}
+
+	// Work out the mask over the box at prototype resolution, then sample it
+	// for each image pixel.
+	x0, y0 := protoX(d.XMin), protoY(d.YMin)
+	x1, y1 := protoX(d.XMax-1), protoY(d.YMax-1)
+	gw := x1 - x0 + 1
+	grid := make([]bool, gw*(y1-y0+1))
+	for py := y0; py <= y1; py++ {
+		for px := x0; px <= x1; px++ {
+			var logit float32
+			for k, c := range coeffs {
+				logit += c * protos.data[k*ph*pw+py*pw+px]
+			}
+			grid[(py-y0)*gw+px-x0] = logit > 0 // sigmoid > 0.5
+		}
+	}
+	return newMask(d.XMin, d.YMin, d.XMax-d.XMin, d.YMax-d.YMin, func(x, y int) bool {
+		return grid[(protoY(y)-y0)*gw+protoX(x)-x0]
+	})
+}
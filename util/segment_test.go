@@ -0,0 +1,105 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+// segOutput is denseOutput for a one-class model with two mask coefficients,
+// plus prototypes at a quarter of the 100×100 input: the first is 1 left of
+// image x=48 and 0 elsewhere, the second is -1 everywhere.
+func segOutput(attrsFirst bool) []outputTensor {
+	dets := denseOutput(attrsFirst, false, 1+2, 100)
+	set := func(attr int, v float32) {
+		if attrsFirst {
+			dets.data[attr*100+3] = v
+		} else {
+			dets.data[3*7+attr] = v
+		}
+	}
+	set(5, 1)
+	set(6, 0.5)
+	protos := outputTensor{name: "output1", shape: []int64{1, 2, 25, 25}, data: make([]float32, 2*25*25)}
+	for y := range 25 {
+		for x := range 25 {
+			if x < 12 {
+				protos.data[y*25+x] = 1
+			}
+			protos.data[25*25+y*25+x] = -1
+		}
+	}
+	return []outputTensor{protos, dets}
+}
+
+func TestDecodeYOLOSeg(t *testing.T) {
+	labels := []string{"person"}
+	for _, tt := range []struct {
+		format     string
+		attrsFirst bool
+	}{
+		{YOLOFormatSeg, true},
+		{YOLOFormatSegTransposed, false},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			outputs := segOutput(tt.attrsFirst)
+			if auto, err := detectYOLOFormat(outputs, len(labels)); err != nil || auto != tt.format {
+				t.Errorf("detectYOLOFormat = %q, %v", auto, err)
+			}
+			dets, err := decodeYOLOOutputs(YOLOFormatAuto, outputs, testLetterbox, labels, 0.5, 0.45)
+			if err != nil || len(dets) != 1 {
+				t.Fatalf("decode = %+v, %v", dets, err)
+			}
+			d := dets[0]
+			if d.Label != "person" || d.XMin != 40 || d.XMax != 60 || d.Mask == nil {
+				t.Fatalf("unexpected detection %+v", d)
+			}
+			// The left 8 columns of the 20×20 box.
+			if d.Mask.X != 40 || d.Mask.Y != 40 || d.Mask.Width != 20 || d.Mask.Height != 20 || d.Mask.Area() != 8*20 {
+				t.Errorf("mask %+v, area %d", d.Mask, d.Mask.Area())
+			}
+			d.Mask.Each(func(x, y int) {
+				if x < 40 || x >= 48 || y < 40 || y >= 60 {
+					t.Errorf("(%d, %d) in the mask", x, y)
+				}
+			})
+		})
+	}
+
+	if _, err := decodeYOLOOutputs(YOLOFormatSeg, segOutput(true)[1:], testLetterbox, labels, 0.5, 0.45); err == nil {
+		t.Error("decoded a segmentation model without prototypes")
+	}
+}
+
+func TestMaskRuns(t *testing.T) {
+	m := newMask(10, 20, 3, 2, func(x, y int) bool { return x == 10 || y == 21 })
+	if !reflect.DeepEqual(m.Runs, []int{0, 1, 2, 3}) || m.Area() != 4 {
+		t.Errorf("runs %v, area %d", m.Runs, m.Area())
+	}
+	var got [][2]int
+	m.Each(func(x, y int) { got = append(got, [2]int{x, y}) })
+	if want := [][2]int{{10, 20}, {10, 21}, {11, 21}, {12, 21}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pixels %v, expected %v", got, want)
+	}
+	var none *Mask
+	if none.Area() != 0 {
+		t.Error("nil mask has an area")
+	}
+}
+
+func TestResolveTritonSegModel(t *testing.T) {
+	none := modelSettings{explicit: func(string) bool { return false }}
+	meta := yoloMetadata("FP32", 640, 640)
+	meta.Outputs = []*tritonpb.ModelMetadataResponse_TensorMetadata{
+		{Name: "output1", Datatype: "FP32", Shape: []int64{-1, 32, 160, 160}},
+		{Name: "output0", Datatype: "FP32", Shape: []int64{-1, 116, 8400}},
+	}
+	info, err := resolveTritonModel(meta, nil, none)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if !reflect.DeepEqual(info.Outputs, []string{"output0", "output1"}) || info.Format != YOLOFormatSeg || info.Classes != 80 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
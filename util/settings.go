@@ -58,16 +58,69 @@ func SetupConfig() {
 	Config.SetDefault("Frequency", 30)
 	Config.SetDefault("Occupancy_period", 150)
 	Config.SetDefault("inference_concurrency", 4)
+	Config.SetDefault("ble_presence_timeout", 30)
+	Config.SetDefault("lighting_off_delay", 60)
+	Config.SetDefault("lighting_override_period", 1800)
+	Config.SetDefault("rules_dry_run", false)
+	Config.SetDefault("script_timeout_ms", 100)
+	Config.SetDefault("script_max_steps", 100000)
+
+	// Object detection backend: triton_grpc, triton_http or deepstack
+	Config.SetDefault("detector_backend", "triton_grpc")
+	Config.SetDefault("detector_timeout", 15)
+	Config.SetDefault("triton_http_url", "")
+	Config.SetDefault("triton_http_binary", true)
+	Config.SetDefault("deepstack_url", "")
+	Config.SetDefault("inference_batch_size", 1)
+	Config.SetDefault("inference_batch_delay_ms", 10)
+	Config.SetDefault("detector_breaker_threshold", 5)
+	Config.SetDefault("detector_breaker_cooldown", 30)
+	Config.SetDefault("detector_status_topic", "hab/detector/status")
 
 	// Triton gRPC inference defaults
 	Config.SetDefault("triton_url", "10.0.4.226:8001")
+	Config.SetDefault("triton_urls", []string{})
+	Config.SetDefault("triton_health_interval", 10)
 	Config.SetDefault("triton_model", "yolo11")
 	Config.SetDefault("triton_model_version", "")
 	Config.SetDefault("triton_input_width", 640)
 	Config.SetDefault("triton_input_height", 640)
 	Config.SetDefault("triton_input_name", "images")
+	Config.SetDefault("triton_input_datatype", "FP32")
+	Config.SetDefault("triton_input_modes", map[string]string{})
+	Config.SetDefault("camera_models", []map[string]interface{}{})
+	Config.SetDefault("shadow_model", "")
+	Config.SetDefault("shadow_model_version", "")
+	Config.SetDefault("shadow_sample_rate", 0.1)
+	Config.SetDefault("shadow_report_size", 20)
+	Config.SetDefault("cascade_model", "")
+	Config.SetDefault("cascade_model_version", "")
+	Config.SetDefault("cascade_backend", "triton_grpc")
+	Config.SetDefault("cascade_output", "classifier")
+	Config.SetDefault("cascade_labels", []string{"person"})
+	Config.SetDefault("cascade_input_width", 224)
+	Config.SetDefault("cascade_input_height", 224)
+	Config.SetDefault("cascade_crop_padding", 0.1)
+	Config.SetDefault("cascade_max_crops", 8)
+	Config.SetDefault("cascade_stub_label", "unknown")
+	Config.SetDefault("gallery_file", "gallery.json")
+	Config.SetDefault("gallery_match_threshold", 0.6)
+	Config.SetDefault("recognition_timeout", 120)
+	Config.SetDefault("lying_alert_topic", "hab/alert/lying")
+	Config.SetDefault("track_iou_threshold", 0.3)
+	Config.SetDefault("track_min_hits", 2)
+	Config.SetDefault("track_max_age", 90)
+	Config.SetDefault("frame_change_filter", true)
+	Config.SetDefault("frame_change_threshold", 4)
+	Config.SetDefault("frame_change_max_age", 300)
 	Config.SetDefault("triton_output_name", "output0")
 	Config.SetDefault("triton_iou_threshold", 0.45)
+	Config.SetDefault("triton_model_repository", "")
+	Config.SetDefault("triton_shared_memory", false)
+	Config.SetDefault("triton_shared_memory_slots", 0)
+	Config.SetDefault("yolo_output_format", "auto")
+	Config.SetDefault("triton_ensemble_outputs", []string{})
+	Config.SetDefault("labels_files", map[string]string{})
 	Config.SetDefault("min_confidence", 0.5)
 
 	// config file
@@ -0,0 +1,271 @@
+package util
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Shadow model evaluation. With `shadow_model` set, a `shadow_sample_rate`
+// fraction of the images the primary model has looked at are run through the
+// candidate as well (`shadow_model`, `shadow_model_version`). Its detections
+// never reach occupancy: they are only compared with the primary's on whether
+// a person is in the frame (at min_confidence), giving the candidate's person
+// precision and recall against the primary and the latency of both on the
+// same frames. The last `shadow_report_size` frames on which they disagreed
+// are kept for review through /api/shadow. One shadow inference runs at a
+// time; images sampled while it is busy are skipped, so the candidate never
+// takes more than one inference slot from the primary.
+
+const (
+	defaultShadowSampleRate = 0.1
+	defaultShadowReportSize = 20
+)
+
+// Shadow outcomes, as counted in shadow_frames_total.
+const (
+	ShadowBothPerson  = "both_person"
+	ShadowBothEmpty   = "both_empty"
+	ShadowOnlyShadow  = "shadow_only"  // a false positive against the primary
+	ShadowOnlyPrimary = "primary_only" // a false negative against the primary
+	ShadowError       = "error"
+	ShadowBusy        = "busy"
+)
+
+// ShadowFrame is an image on which the shadow model disagreed with the
+// primary.
+type ShadowFrame struct {
+	ID            int64             `json:"id"`
+	Topic         string            `json:"topic"`
+	Room          string            `json:"room"`
+	Timestamp     int64             `json:"timestamp"`
+	PrimaryPerson bool              `json:"primary_person"`
+	ShadowPerson  bool              `json:"shadow_person"`
+	Primary       []TritonDetection `json:"primary"`
+	Shadow        []TritonDetection `json:"shadow"`
+	image         []byte
+}
+
+// ShadowReport summarises the shadow model against the primary since the
+// shadow model was last changed. Precision and recall are nil until they are
+// defined; latencies are means over the frames both models analysed.
+type ShadowReport struct {
+	Model            string        `json:"model"`
+	SampleRate       float64       `json:"sample_rate"`
+	Frames           int64         `json:"frames"`
+	BothPerson       int64         `json:"both_person"`
+	BothEmpty        int64         `json:"both_empty"`
+	ShadowOnly       int64         `json:"shadow_only"`
+	PrimaryOnly      int64         `json:"primary_only"`
+	Errors           int64         `json:"errors"`
+	Busy             int64         `json:"busy"`
+	Precision        *float64      `json:"precision"`
+	Recall           *float64      `json:"recall"`
+	PrimaryLatencyMs float64       `json:"primary_latency_ms"`
+	ShadowLatencyMs  float64       `json:"shadow_latency_ms"`
+	LatencyDeltaMs   float64       `json:"latency_delta_ms"` // shadow minus primary
+	Disagreements    []ShadowFrame `json:"disagreements"`    // newest first
+}
+
+type shadowEvaluator struct {
+	busy chan struct{} // holds a token while a shadow inference runs
+
+	mu             sync.Mutex
+	report         ShadowReport // counts only; the rest is filled in on read
+	primaryLatency time.Duration
+	shadowLatency  time.Duration
+	frames         []ShadowFrame // oldest first
+	nextID         int64
+}
+
+var shadow = &shadowEvaluator{busy: make(chan struct{}, 1)}
+
+// ShadowModel returns the configured shadow model, or "" when shadow
+// evaluation is off.
+func ShadowModel() string {
+	return Config.GetString("shadow_model")
+}
+
+// ShadowSample reports whether an image should also go to the shadow model.
+func ShadowSample() bool {
+	if ShadowModel() == "" {
+		return false
+	}
+	rate := defaultShadowSampleRate
+	if Config.IsSet("shadow_sample_rate") {
+		rate = Config.GetFloat64("shadow_sample_rate")
+	}
+	return rand.Float64() < rate //nolint:gosec // sampling, not security
+}
+
+// RunShadow runs the shadow model on an image from topic, in room, that the
+// primary model took primaryDur to find primary in, and records how the two
+// compare. It returns at once if a shadow inference is already running.
+func RunShadow(topic, room string, night bool, jpegData []byte, primary []TritonDetection, primaryDur time.Duration) {
+	model := ShadowModel()
+	if model == "" {
+		return
+	}
+	select {
+	case shadow.busy <- struct{}{}:
+		defer func() { <-shadow.busy }()
+	default:
+		shadow.record(model, ShadowBusy, nil, 0, 0)
+		RecordShadow(model, ShadowBusy, 0, 0)
+		return
+	}
+
+	// The shadow model runs on the detector behind the breaker so its
+	// failures never take the primary out of service.
+	d := CurrentDetector()
+	if b, ok := d.(*circuitBreaker); ok {
+		d = b.Detector
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DetectorTimeout())
+	defer cancel()
+	ctx = withModel(WithCamera(ctx, topic, night), &CameraModel{Model: model, Version: Config.GetString("shadow_model_version")})
+	start := time.Now()
+	dets, err := d.Detect(ctx, jpegData)
+	shadowDur := time.Since(start)
+	if err != nil {
+		Logger.Debug().Msgf("shadow model %s failed on %s: %v", model, topic, err)
+		shadow.record(model, ShadowError, nil, 0, 0)
+		RecordShadow(model, ShadowError, 0, 0)
+		return
+	}
+
+	primaryPerson, shadowPerson := hasPerson(primary), hasPerson(dets)
+	var outcome string
+	switch {
+	case primaryPerson && shadowPerson:
+		outcome = ShadowBothPerson
+	case primaryPerson:
+		outcome = ShadowOnlyPrimary
+	case shadowPerson:
+		outcome = ShadowOnlyShadow
+	default:
+		outcome = ShadowBothEmpty
+	}
+	var frame *ShadowFrame
+	if primaryPerson != shadowPerson {
+		frame = &ShadowFrame{
+			Topic:         topic,
+			Room:          room,
+			Timestamp:     time.Now().Unix(),
+			PrimaryPerson: primaryPerson,
+			ShadowPerson:  shadowPerson,
+			Primary:       primary,
+			Shadow:        dets,
+			image:         jpegData,
+		}
+	}
+	shadow.record(model, outcome, frame, primaryDur, shadowDur)
+	RecordShadow(model, outcome, primaryDur, shadowDur)
+}
+
+// hasPerson reports whether dets hold a person at min_confidence or above.
+func hasPerson(dets []TritonDetection) bool {
+	minConf := float32(Config.GetFloat64("min_confidence"))
+	if minConf <= 0 {
+		minConf = 0.5
+	}
+	for _, d := range dets {
+		if d.Label == "person" && d.Confidence >= minConf {
+			return true
+		}
+	}
+	return false
+}
+
+// record counts an outcome for model, and the latencies of a compared frame,
+// starting over when the model has changed. It keeps frame if it is not nil.
+func (e *shadowEvaluator) record(model, outcome string, frame *ShadowFrame, primaryDur, shadowDur time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.report.Model != model {
+		e.report = ShadowReport{Model: model}
+		e.primaryLatency, e.shadowLatency = 0, 0
+		e.frames = nil
+	}
+	e.primaryLatency += primaryDur
+	e.shadowLatency += shadowDur
+	switch outcome {
+	case ShadowBothPerson:
+		e.report.BothPerson++
+	case ShadowBothEmpty:
+		e.report.BothEmpty++
+	case ShadowOnlyShadow:
+		e.report.ShadowOnly++
+	case ShadowOnlyPrimary:
+		e.report.PrimaryOnly++
+	case ShadowError:
+		e.report.Errors++
+	case ShadowBusy:
+		e.report.Busy++
+	}
+	if frame == nil {
+		return
+	}
+	size := defaultShadowReportSize
+	if Config.IsSet("shadow_report_size") {
+		size = Config.GetInt("shadow_report_size")
+	}
+	if size <= 0 {
+		return
+	}
+	e.nextID++
+	frame.ID = e.nextID
+	e.frames = append(e.frames, *frame)
+	if n := len(e.frames) - size; n > 0 {
+		e.frames = append(e.frames[:0:0], e.frames[n:]...)
+	}
+}
+
+// CurrentShadowReport returns the shadow model's results so far.
+func CurrentShadowReport() ShadowReport {
+	shadow.mu.Lock()
+	defer shadow.mu.Unlock()
+	r := shadow.report
+	if model := ShadowModel(); r.Model != model {
+		r = ShadowReport{Model: model}
+	} else {
+		r.Frames = r.BothPerson + r.BothEmpty + r.ShadowOnly + r.PrimaryOnly
+		if n := r.BothPerson + r.ShadowOnly; n > 0 {
+			p := float64(r.BothPerson) / float64(n)
+			r.Precision = &p
+		}
+		if n := r.BothPerson + r.PrimaryOnly; n > 0 {
+			rc := float64(r.BothPerson) / float64(n)
+			r.Recall = &rc
+		}
+		if r.Frames > 0 {
+			r.PrimaryLatencyMs = shadow.primaryLatency.Seconds() * 1000 / float64(r.Frames)
+			r.ShadowLatencyMs = shadow.shadowLatency.Seconds() * 1000 / float64(r.Frames)
+			r.LatencyDeltaMs = r.ShadowLatencyMs - r.PrimaryLatencyMs
+		}
+	}
+	r.SampleRate = defaultShadowSampleRate
+	if Config.IsSet("shadow_sample_rate") {
+		r.SampleRate = Config.GetFloat64("shadow_sample_rate")
+	}
+	r.Disagreements = make([]ShadowFrame, 0, len(shadow.frames))
+	if r.Model == shadow.report.Model {
+		for i := len(shadow.frames) - 1; i >= 0; i-- {
+			r.Disagreements = append(r.Disagreements, shadow.frames[i])
+		}
+	}
+	return r
+}
+
+// ShadowFrameByID returns a kept disagreement and its image.
+func ShadowFrameByID(id int64) (ShadowFrame, []byte, bool) {
+	shadow.mu.Lock()
+	defer shadow.mu.Unlock()
+	for _, f := range shadow.frames {
+		if f.ID == id {
+			return f, f.image, true
+		}
+	}
+	return ShadowFrame{}, nil, false
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// modelDetector answers with the detections listed for the model its request
+// asks for.
+type modelDetector struct {
+	dets map[string][]TritonDetection
+	errs map[string]error
+}
+
+func (*modelDetector) Name() string { return "models" }
+
+func (m *modelDetector) Detect(ctx context.Context, _ []byte) ([]TritonDetection, error) {
+	model := ""
+	if cm := cameraModel(ctx); cm != nil {
+		model = cm.Model
+	}
+	return m.dets[model], m.errs[model]
+}
+
+func TestRunShadow(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	Config.Set("shadow_model", "candidate")
+	Config.Set("shadow_report_size", 2)
+	defer Config.Set("shadow_model", nil)
+	defer Config.Set("shadow_report_size", nil)
+	old := CurrentDetector()
+	defer SetDetector(old)
+
+	person := []TritonDetection{{Label: "person", Confidence: 0.9}}
+	faint := []TritonDetection{{Label: "person", Confidence: 0.3}}
+	backend := &modelDetector{dets: map[string][]TritonDetection{"candidate": person}, errs: map[string]error{}}
+	breaker := newCircuitBreaker(backend)
+	SetDetector(breaker)
+
+	// Agreement, then the candidate seeing a person the primary did not
+	// (twice), then missing one.
+	RunShadow("cams/porch", "porch", false, []byte{1}, person, 10*time.Millisecond)
+	RunShadow("cams/porch", "porch", false, []byte{2}, nil, 10*time.Millisecond)
+	RunShadow("cams/yard", "yard", true, []byte{3}, faint, 10*time.Millisecond)
+	backend.dets["candidate"] = nil
+	RunShadow("cams/hall", "hall", false, []byte{4}, person, 10*time.Millisecond)
+
+	r := CurrentShadowReport()
+	if r.Model != "candidate" || r.Frames != 4 || r.BothPerson != 1 || r.ShadowOnly != 2 || r.PrimaryOnly != 1 {
+		t.Fatalf("report %+v", r)
+	}
+	if r.Precision == nil || *r.Precision != 1.0/3 || r.Recall == nil || *r.Recall != 0.5 {
+		t.Errorf("precision %v, recall %v", r.Precision, r.Recall)
+	}
+	if r.PrimaryLatencyMs != 10 {
+		t.Errorf("primary latency %v ms, expected 10", r.PrimaryLatencyMs)
+	}
+	if len(r.Disagreements) != 2 || r.Disagreements[0].Room != "hall" || r.Disagreements[1].Room != "yard" {
+		t.Fatalf("disagreements %+v, expected hall then yard", r.Disagreements)
+	}
+	if f, im, ok := ShadowFrameByID(r.Disagreements[0].ID); !ok || f.PrimaryPerson != true || len(im) != 1 || im[0] != 4 {
+		t.Errorf("frame %+v, image %v, found %v", f, im, ok)
+	}
+
+	// Shadow failures are counted but leave the primary's breaker alone.
+	backend.errs["candidate"] = errors.New("model not loaded")
+	for range 10 {
+		RunShadow("cams/porch", "porch", false, nil, nil, time.Millisecond)
+	}
+	if r := CurrentShadowReport(); r.Errors != 10 || r.Frames != 4 {
+		t.Errorf("after failures: %+v", r)
+	}
+	if h := breaker.Health(); h.State != BreakerClosed || h.ConsecutiveFailures != 0 {
+		t.Errorf("shadow failures reached the breaker: %+v", h)
+	}
+
+	// A new candidate starts from scratch.
+	Config.Set("shadow_model", "candidate2")
+	if r := CurrentShadowReport(); r.Frames != 0 || r.Errors != 0 || len(r.Disagreements) != 0 || r.Precision != nil {
+		t.Errorf("report carried over to a new model: %+v", r)
+	}
+}
+
+func TestShadowSample(t *testing.T) {
+	defer Config.Set("shadow_model", nil)
+	defer Config.Set("shadow_sample_rate", nil)
+	Config.Set("shadow_sample_rate", 1)
+	if ShadowSample() {
+		t.Error("sampled with no shadow model")
+	}
+	Config.Set("shadow_model", "candidate")
+	if !ShadowSample() {
+		t.Error("not sampled at rate 1")
+	}
+	Config.Set("shadow_sample_rate", 0)
+	if ShadowSample() {
+		t.Error("sampled at rate 0")
+	}
+}
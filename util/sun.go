@@ -0,0 +1,73 @@
+package util
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+	secondsPerDay   = 86400
+)
+
+func toJulian(t time.Time) float64 {
+	return float64(t.Unix())/secondsPerDay + julianUnixEpoch
+}
+
+func fromJulian(j float64) time.Time {
+	return time.Unix(int64(math.Round((j-julianUnixEpoch)*secondsPerDay)), 0)
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180 //nolint:mnd
+}
+
+func degrees(rad float64) float64 {
+	return rad * 180 / math.Pi //nolint:mnd
+}
+
+// SunTimes returns sunrise and sunset for the calendar day containing t, using
+// the standard sunrise equation (accurate to a minute or two, which is plenty
+// for lighting decisions). ok is false during polar day or night, in which case
+// polarDay tells which.
+func SunTimes(lat, lon float64, t time.Time) (sunrise, sunset time.Time, ok, polarDay bool) {
+	y, m, d := t.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, t.Location())
+	n := math.Round(toJulian(noon) - julian2000 + 0.0008) //nolint:mnd
+
+	meanNoon := n - lon/360                                //nolint:mnd
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360) //nolint:mnd
+	ma := radians(anomaly)
+	center := 1.9148*math.Sin(ma) + 0.0200*math.Sin(2*ma) + 0.0003*math.Sin(3*ma)        //nolint:mnd
+	ecliptic := radians(math.Mod(anomaly+center+180+102.9372, 360))                      //nolint:mnd
+	transit := julian2000 + meanNoon + 0.0053*math.Sin(ma) - 0.0069*math.Sin(2*ecliptic) //nolint:mnd
+
+	declination := math.Asin(math.Sin(ecliptic) * math.Sin(radians(23.4397))) //nolint:mnd
+	phi := radians(lat)
+	cosHour := (math.Sin(radians(-0.833)) - math.Sin(phi)*math.Sin(declination)) / //nolint:mnd
+		(math.Cos(phi) * math.Cos(declination))
+	if cosHour < -1 {
+		return time.Time{}, time.Time{}, false, true
+	}
+	if cosHour > 1 {
+		return time.Time{}, time.Time{}, false, false
+	}
+	hour := degrees(math.Acos(cosHour))
+	sunrise = fromJulian(transit - hour/360).In(t.Location()) //nolint:mnd
+	sunset = fromJulian(transit + hour/360).In(t.Location())  //nolint:mnd
+	return sunrise, sunset, true, false
+}
+
+// IsNight reports whether t falls between sunset and sunrise at the location.
+// Without configured coordinates it falls back to 19:00-07:00 local time.
+func (l Location) IsNight(t time.Time) bool {
+	if l.Lat == 0 && l.Lon == 0 {
+		return t.Hour() >= 19 || t.Hour() < 7 //nolint:mnd
+	}
+	sunrise, sunset, ok, polarDay := SunTimes(l.Lat, l.Lon, t)
+	if !ok {
+		return !polarDay
+	}
+	return t.Before(sunrise) || t.After(sunset)
+}
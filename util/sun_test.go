@@ -0,0 +1,58 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunTimes(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	// Boston on the summer solstice: sunrise ~05:07, sunset ~20:25 EDT.
+	day := time.Date(2024, 6, 21, 9, 0, 0, 0, ny)
+	sunrise, sunset, ok, _ := SunTimes(42.3601, -71.0589, day)
+	if !ok {
+		t.Fatal("expected sunrise and sunset in Boston")
+	}
+	t.Logf("sunrise %v sunset %v", sunrise, sunset)
+	wantRise := time.Date(2024, 6, 21, 5, 7, 0, 0, ny)
+	wantSet := time.Date(2024, 6, 21, 20, 25, 0, 0, ny)
+	if d := sunrise.Sub(wantRise); d < -5*time.Minute || d > 5*time.Minute {
+		t.Errorf("sunrise = %v, expected about %v", sunrise, wantRise)
+	}
+	if d := sunset.Sub(wantSet); d < -5*time.Minute || d > 5*time.Minute {
+		t.Errorf("sunset = %v, expected about %v", sunset, wantSet)
+	}
+
+	// Polar night in Tromsø mid-winter.
+	if _, _, ok, polarDay := SunTimes(69.6492, 18.9553, time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC)); ok || polarDay {
+		t.Errorf("expected polar night, got ok=%v polarDay=%v", ok, polarDay)
+	}
+}
+
+func TestLocation_IsNight(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	boston := Location{Name: "Boston", Lat: 42.3601, Lon: -71.0589}
+
+	if boston.IsNight(time.Date(2024, 6, 21, 12, 0, 0, 0, ny)) {
+		t.Error("noon should not be night")
+	}
+	if !boston.IsNight(time.Date(2024, 6, 21, 23, 0, 0, 0, ny)) {
+		t.Error("23:00 should be night")
+	}
+	if !boston.IsNight(time.Date(2024, 6, 21, 4, 0, 0, 0, ny)) {
+		t.Error("04:00 should be night")
+	}
+
+	// Without coordinates the fixed 19:00-07:00 window applies.
+	unset := Location{}
+	if unset.IsNight(time.Date(2024, 6, 21, 12, 0, 0, 0, ny)) || !unset.IsNight(time.Date(2024, 6, 21, 20, 0, 0, 0, ny)) {
+		t.Error("unset location should use the fixed night window")
+	}
+}
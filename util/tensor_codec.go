@@ -0,0 +1,102 @@
+package util
+
+import (
+	"math"
+	"strings"
+)
+
+// Model input encodings. FP32 is the default; FP16 halves the request size
+// for models exported with half-precision inputs, and UINT8 sends the raw
+// 0-255 pixel values (a quarter of FP32) to models that normalise
+// internally, such as an ensemble with a preprocessing step. The datatype is
+// taken from the server's model metadata, or `triton_input_datatype`.
+const (
+	InputFP32  = "FP32"
+	InputFP16  = "FP16"
+	InputUINT8 = "UINT8"
+	InputBYTES = "BYTES" // encoded images, for models that decode on the server
+)
+
+// Input modes, per model in `triton_input_modes`: "tensor" sends a letterboxed
+// image tensor, "jpeg" sends the camera's JPEG for the model (typically a DALI
+// or Python preprocessing ensemble) to decode and letterbox itself. Unset, the
+// mode follows the model's input: BYTES, or UINT8 without image dimensions,
+// means jpeg.
+const (
+	InputModeTensor = "tensor"
+	InputModeJPEG   = "jpeg"
+)
+
+// modelInputMode returns the mode configured for model, or "" when unset.
+func modelInputMode(model string) string {
+	return Config.GetStringMapString("triton_input_modes")[strings.ToLower(model)]
+}
+
+// inputElementSize is the size in bytes of one element of an input datatype,
+// or 0 when the datatype is not supported.
+func inputElementSize(datatype string) int {
+	switch datatype {
+	case InputFP32:
+		return 4
+	case InputFP16:
+		return 2
+	case InputUINT8:
+		return 1
+	}
+	return 0
+}
+
+// float32ToFloat16 converts to IEEE 754 half precision, rounding to nearest
+// even. Values too large for a half become infinity.
+func float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000 //nolint:gosec // top half of the bits
+	biased := int((bits >> 23) & 0xff)
+	mant := bits & 0x7fffff
+	if biased == 0xff { // Inf or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	exp := biased - 127 + 15
+	switch {
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		// Subnormal half, or zero when too small.
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp) //nolint:gosec // exp is in [-10, 0]
+		rounded := mant + (1 << (shift - 1)) - 1 + ((mant >> shift) & 1)
+		return sign | uint16(rounded>>shift) //nolint:gosec // at most 0x400
+	}
+	// Round the mantissa to 10 bits; a carry correctly bumps the exponent.
+	h := uint32(exp)<<23 | mant //nolint:gosec // exp is in [1, 30]
+	h += 0xfff + ((h >> 13) & 1)
+	h >>= 13
+	if h >= 0x7c00 {
+		return sign | 0x7c00
+	}
+	return sign | uint16(h) //nolint:gosec // below 0x7c00
+}
+
+// float16ToFloat32 converts an IEEE 754 half to float32.
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch exp {
+	case 0:
+		f := float32(mant) / (1 << 24) // subnormal (or zero)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp-15+127)<<23 | mant<<13)
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		in   float32
+		bits uint16
+	}{
+		{0, 0x0000},
+		{1, 0x3c00},
+		{0.5, 0x3800},
+		{-2, 0xc000},
+		{65504, 0x7bff},                         // largest half
+		{70000, 0x7c00},                         // overflows to +Inf
+		{float32(math.Pow(2, -24)), 0x0001},     // smallest subnormal
+		{float32(math.Pow(2, -26)), 0x0000},     // underflows to zero
+		{1 + float32(math.Pow(2, -11)), 0x3c00}, // tie rounds to even
+		{float32(math.Inf(-1)), 0xfc00},
+	}
+	for _, tt := range tests {
+		if got := float32ToFloat16(tt.in); got != tt.bits {
+			t.Errorf("float32ToFloat16(%g) = %#04x, expected %#04x", tt.in, got, tt.bits)
+		}
+	}
+	if got := float32ToFloat16(float32(math.NaN())); got&0x7c00 != 0x7c00 || got&0x3ff == 0 {
+		t.Errorf("NaN encoded as %#04x", got)
+	}
+
+	// Every pixel value survives the round trip to within half precision.
+	for i := 0; i <= 255; i++ {
+		v := float32(i) / 255
+		if back := float16ToFloat32(float32ToFloat16(v)); math.Abs(float64(back-v)) > 1.0/2048 {
+			t.Errorf("pixel %d: %g round-tripped to %g", i, v, back)
+		}
+	}
+}
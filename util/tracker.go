@@ -0,0 +1,244 @@
+package util
+
+import (
+	"image"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Multi-object tracking, SORT style. Each camera has a Tracker that follows
+// detections from frame to frame: a constant-velocity Kalman filter on each
+// of a track's box centre and size predicts where it will be in the next
+// frame, and detections are matched to the predictions of tracks with the
+// same label by IoU, best first, down to track_iou_threshold (default 0.3).
+// A track is confirmed, and given an ID, once matched in track_min_hits
+// frames (default 2) and ends when unmatched for track_max_age seconds
+// (default 90). With cameras analysed every Frequency seconds, people moving
+// far between frames start new tracks; lingering people keep theirs.
+
+const (
+	defaultTrackIOU     = 0.3
+	defaultTrackMinHits = 2
+	defaultTrackMaxAge  = 90
+
+	// Kalman filter noise, in pixels and seconds.
+	trackMeasureVar  = 16
+	trackVelocityVar = 100
+	trackProcessVar  = 1
+)
+
+// Track is a tracked object as last seen by a camera.
+type Track struct {
+	ID        int64  `json:"id"`
+	Camera    string `json:"camera"`
+	Label     string `json:"label"`
+	XMin      int    `json:"x_min"`
+	YMin      int    `json:"y_min"`
+	XMax      int    `json:"x_max"`
+	YMax      int    `json:"y_max"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+	Dwell     int64  `json:"dwell"` // seconds from first to last seen
+	Hits      int    `json:"hits"`
+}
+
+// TrackMove is how far a confirmed track moved between its last two
+// sightings, by the bottom centre of its box (where a person stands).
+type TrackMove struct {
+	Track
+	From image.Point
+	To   image.Point
+}
+
+var lastTrackID atomic.Int64
+
+// kalman1D is a constant-velocity Kalman filter on one coordinate.
+type kalman1D struct {
+	pos, vel float64
+	p        [2][2]float64
+}
+
+func newKalman1D(pos float64) kalman1D {
+	return kalman1D{pos: pos, p: [2][2]float64{{trackMeasureVar, 0}, {0, trackVelocityVar}}}
+}
+
+func (k *kalman1D) predict(dt float64) {
+	k.pos += k.vel * dt
+	p := k.p
+	k.p[0][0] = p[0][0] + dt*(p[0][1]+p[1][0]) + dt*dt*p[1][1] + trackProcessVar*dt*dt*dt/3 //nolint:mnd
+	k.p[0][1] = p[0][1] + dt*p[1][1] + trackProcessVar*dt*dt/2                              //nolint:mnd
+	k.p[1][0] = p[1][0] + dt*p[1][1] + trackProcessVar*dt*dt/2                              //nolint:mnd
+	k.p[1][1] = p[1][1] + trackProcessVar*dt
+}
+
+func (k *kalman1D) update(z float64) {
+	s := k.p[0][0] + trackMeasureVar
+	k0, k1 := k.p[0][0]/s, k.p[1][0]/s
+	y := z - k.pos
+	k.pos += k0 * y
+	k.vel += k1 * y
+	p := k.p
+	k.p[0][0] = (1 - k0) * p[0][0]
+	k.p[0][1] = (1 - k0) * p[0][1]
+	k.p[1][0] = p[1][0] - k1*p[0][0]
+	k.p[1][1] = p[1][1] - k1*p[0][1]
+}
+
+type track struct {
+	Track
+	kf      [4]kalman1D // centre x, centre y, width, height
+	updated time.Time
+	anchor  image.Point // bottom centre of the last box
+}
+
+func (t *track) predicted() (x1, y1, x2, y2 float32) {
+	cx, cy := t.kf[0].pos, t.kf[1].pos
+	w, h := max(t.kf[2].pos, 1), max(t.kf[3].pos, 1)
+	return float32(cx - w/2), float32(cy - h/2), float32(cx + w/2), float32(cy + h/2) //nolint:mnd
+}
+
+func (t *track) observe(d TritonDetection, now time.Time) {
+	z := boxState(d)
+	for i := range t.kf {
+		t.kf[i].update(z[i])
+	}
+	t.XMin, t.YMin, t.XMax, t.YMax = d.XMin, d.YMin, d.XMax, d.YMax
+	t.anchor = image.Pt((d.XMin+d.XMax)/2, d.YMax) //nolint:mnd
+	t.LastSeen = now.Unix()
+	t.Dwell = t.LastSeen - t.FirstSeen
+	t.Hits++
+}
+
+func boxState(d TritonDetection) [4]float64 {
+	return [4]float64{
+		float64(d.XMin+d.XMax) / 2, float64(d.YMin+d.YMax) / 2, //nolint:mnd
+		float64(d.XMax - d.XMin), float64(d.YMax - d.YMin),
+	}
+}
+
+// Tracker follows the detections of one camera.
+type Tracker struct {
+	mu     sync.Mutex
+	camera string
+	tracks []*track
+}
+
+// NewTracker returns a tracker for the camera on topic.
+func NewTracker(camera string) *Tracker {
+	return &Tracker{camera: camera}
+}
+
+func trackSettings() (iou float32, minHits int, maxAge int64) {
+	iou, minHits, maxAge = defaultTrackIOU, defaultTrackMinHits, defaultTrackMaxAge
+	if Config.IsSet("track_iou_threshold") {
+		iou = float32(Config.GetFloat64("track_iou_threshold"))
+	}
+	if n := Config.GetInt("track_min_hits"); n > 0 {
+		minHits = n
+	}
+	if n := Config.GetInt64("track_max_age"); n > 0 {
+		maxAge = n
+	}
+	return iou, minHits, maxAge
+}
+
+// Update matches a frame's detections to the camera's tracks and returns the
+// detections with the IDs of confirmed tracks filled in, how the confirmed
+// tracks seen again moved, and the confirmed tracks that have ended.
+func (t *Tracker) Update(dets []TritonDetection, now time.Time) ([]TritonDetection, []TrackMove, []Track) {
+	iouThresh, minHits, maxAge := trackSettings()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tr := range t.tracks {
+		dt := now.Sub(tr.updated).Seconds()
+		for i := range tr.kf {
+			tr.kf[i].predict(dt)
+		}
+		tr.updated = now
+	}
+
+	// Greedy matching on IoU, best pair first.
+	type pair struct {
+		track, det int
+		iou        float32
+	}
+	var pairs []pair
+	for ti, tr := range t.tracks {
+		x1, y1, x2, y2 := tr.predicted()
+		for di, d := range dets {
+			if d.Label != tr.Label {
+				continue
+			}
+			v := iou(x1, y1, x2, y2, float32(d.XMin), float32(d.YMin), float32(d.XMax), float32(d.YMax))
+			if v >= iouThresh && v > 0 {
+				pairs = append(pairs, pair{ti, di, v})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].iou > pairs[j].iou })
+	trackMatched := make([]bool, len(t.tracks))
+	detTrack := make([]*track, len(dets))
+	for _, p := range pairs {
+		if trackMatched[p.track] || detTrack[p.det] != nil {
+			continue
+		}
+		trackMatched[p.track] = true
+		detTrack[p.det] = t.tracks[p.track]
+	}
+
+	out := make([]TritonDetection, len(dets))
+	copy(out, dets)
+	var kept []*track
+	var moves []TrackMove
+	var ended []Track
+	for ti, tr := range t.tracks {
+		if !trackMatched[ti] && now.Unix()-tr.LastSeen > maxAge {
+			if tr.ID != 0 {
+				ended = append(ended, tr.Track)
+			}
+			continue
+		}
+		kept = append(kept, tr)
+	}
+	for di, d := range dets {
+		tr := detTrack[di]
+		if tr == nil {
+			z := boxState(d)
+			tr = &track{Track: Track{Camera: t.camera, Label: d.Label, FirstSeen: now.Unix()}, updated: now}
+			for i := range tr.kf {
+				tr.kf[i] = newKalman1D(z[i])
+			}
+			kept = append(kept, tr)
+		}
+		from := tr.anchor
+		tr.observe(d, now)
+		if tr.ID == 0 && tr.Hits >= minHits {
+			tr.ID = lastTrackID.Add(1)
+		}
+		if tr.ID != 0 && tr.Hits > 1 {
+			moves = append(moves, TrackMove{Track: tr.Track, From: from, To: tr.anchor})
+		}
+		out[di].TrackID = tr.ID
+	}
+	t.tracks = kept
+	return out, moves, ended
+}
+
+// Tracks returns the camera's confirmed tracks seen within track_max_age of
+// now, oldest first.
+func (t *Tracker) Tracks(now time.Time) []Track {
+	_, _, maxAge := trackSettings()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Track
+	for _, tr := range t.tracks {
+		if tr.ID != 0 && now.Unix()-tr.LastSeen <= maxAge {
+			out = append(out, tr.Track)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
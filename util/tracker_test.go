@@ -0,0 +1,77 @@
+package util
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker("cams/hall")
+	start := time.Unix(1000, 0)
+	box := func(x int) TritonDetection {
+		return TritonDetection{Label: "person", XMin: x, YMin: 100, XMax: x + 40, YMax: 200}
+	}
+	cat := TritonDetection{Label: "cat", XMin: 400, YMin: 300, XMax: 440, YMax: 340}
+
+	// A person walking steadily keeps one track; the cat gets its own.
+	var id int64
+	for i := range 6 {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		dets, moves, ended := tr.Update([]TritonDetection{box(100 + 15*i), cat}, now)
+		if len(ended) != 0 {
+			t.Fatalf("frame %d: tracks ended: %+v", i, ended)
+		}
+		switch {
+		case i == 0 && dets[0].TrackID != 0:
+			t.Fatal("track confirmed after one frame")
+		case i == 1:
+			id = dets[0].TrackID
+			if id == 0 || dets[1].TrackID == 0 || dets[1].TrackID == id {
+				t.Fatalf("frame 1 IDs %d, %d", dets[0].TrackID, dets[1].TrackID)
+			}
+			// Moves run from the first, unconfirmed, sighting.
+			if len(moves) != 2 || moves[0].ID != id || moves[0].From != image.Pt(120, 200) || moves[0].To != image.Pt(135, 200) {
+				t.Fatalf("frame 1 moves %+v", moves)
+			}
+		case i > 1 && dets[0].TrackID != id:
+			t.Fatalf("frame %d: person track %d, expected %d", i, dets[0].TrackID, id)
+		}
+	}
+	tracks := tr.Tracks(start.Add(50 * time.Second))
+	if len(tracks) != 2 || tracks[0].ID != id || tracks[0].Dwell != 50 || tracks[0].Hits != 6 || tracks[0].Camera != "cams/hall" || tracks[0].XMin != 175 {
+		t.Fatalf("tracks %+v", tracks)
+	}
+
+	// Someone appearing elsewhere starts a new track; the others end once
+	// they have been gone for track_max_age.
+	now := start.Add(60 * time.Second)
+	if dets, _, _ := tr.Update([]TritonDetection{box(600)}, now); dets[0].TrackID != 0 {
+		t.Errorf("new person joined track %d", dets[0].TrackID)
+	}
+	lastSeen := start.Add(50 * time.Second)
+	_, _, ended := tr.Update(nil, lastSeen.Add(defaultTrackMaxAge*time.Second))
+	if len(ended) != 0 {
+		t.Errorf("ended early: %+v", ended)
+	}
+	_, _, ended = tr.Update(nil, lastSeen.Add((defaultTrackMaxAge+1)*time.Second))
+	if len(ended) != 2 || ended[0].ID != id {
+		t.Errorf("ended %+v", ended)
+	}
+	if tracks := tr.Tracks(lastSeen.Add((defaultTrackMaxAge + 1) * time.Second)); len(tracks) != 0 {
+		t.Errorf("tracks left: %+v", tracks)
+	}
+}
+
+func TestKalman1D(t *testing.T) {
+	k := newKalman1D(0)
+	for i := 1; i <= 5; i++ {
+		k.predict(10)
+		k.update(float64(15 * i))
+	}
+	k.predict(10)
+	if math.Abs(k.pos-90) > 3 || math.Abs(k.vel-1.5) > 0.2 {
+		t.Errorf("predicted %.1f at %.2f/s, expected 90 at 1.5/s", k.pos, k.vel)
+	}
+}
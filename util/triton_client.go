@@ -4,16 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
 	"image/jpeg"
 	"math"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	tritongprc "github.com/elijahnyp/home_controller/triton/generated"
-	"golang.org/x/image/draw"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -26,26 +28,53 @@ type TritonDetection struct {
 	YMin       int
 	XMax       int
 	YMax       int
+	Keypoints  []Keypoint       // from pose models
+	Mask       *Mask            // from segmentation models
+	ImageZones []string         // the room's image_zones it is in
+	TrackID    int64            // the camera's tracker's confirmed track, or 0
+	Secondary  *SecondaryResult // from the cascade's secondary model, if any
 }
 
-// TritonClient manages the gRPC connection to a Triton Inference Server.
+// TritonClient manages the gRPC connection to one Triton Inference Server.
 type TritonClient struct {
+	addr   string
 	conn   *grpc.ClientConn
 	client tritongprc.GRPCInferenceServiceClient
+
+	outstanding atomic.Int64 // requests in flight
+	healthy     atomic.Bool
+
+	mu     sync.Mutex
+	models map[modelKey]*modelState
+
+	shm *sharedMemory // input transport; nil sends tensors in the request
+}
+
+// modelKey identifies a model configuration; camera profiles that differ only
+// in their topics share one.
+type modelKey struct {
+	model, version, format string
+	width, height          int
+}
+
+// modelState is what an endpoint has learnt about one model configuration.
+type modelState struct {
+	info   *TritonModelInfo // derived from server metadata; nil until probed
+	probed bool             // metadata RPCs unsupported; use the config as-is
+	err    error            // model incompatible; inference refused
 }
 
-var tritonClient *TritonClient
+var tritonEndpoints *tritonPool
 
-// InitTritonClient creates the gRPC connection to the Triton server.
+// InitTritonClient creates the gRPC connections to the Triton servers.
 // Call this once at startup, and again whenever config changes.
 func InitTritonClient() error {
-	addr := Config.GetString("triton_url")
-	if addr == "" {
-		addr = "10.0.4.226:8001"
-	}
+	addrs := tritonAddrs()
 
-	// A 640×640×3 float32 input is ~4.7 MB; set limits well above that.
-	const maxMsgSize = 32 * 1024 * 1024 // 32 MB
+	// A 640×640×3 float32 input is ~4.7 MB; set limits well above that, per
+	// image in a batch.
+	const perImageMsgSize = 32 * 1024 * 1024 // 32 MB
+	maxMsgSize := perImageMsgSize * max(1, Config.GetInt("inference_batch_size"))
 	opts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithDefaultCallOptions(
@@ -54,26 +83,165 @@ func InitTritonClient() error {
 		),
 	}
 
-	conn, err := grpc.NewClient(addr, opts...)
-	if err != nil {
-		return fmt.Errorf("triton: failed to connect to %s: %w", addr, err)
+	endpoints := make([]*TritonClient, 0, len(addrs))
+	for _, addr := range addrs {
+		conn, err := grpc.NewClient(addr, opts...)
+		if err != nil {
+			for _, tc := range endpoints {
+				tc.close()
+			}
+			return fmt.Errorf("triton: failed to connect to %s: %w", addr, err)
+		}
+		tc := &TritonClient{
+			addr:   addr,
+			conn:   conn,
+			client: tritongprc.NewGRPCInferenceServiceClient(conn),
+		}
+		tc.healthy.Store(true)
+		endpoints = append(endpoints, tc)
 	}
 
-	if tritonClient != nil && tritonClient.conn != nil {
-		if closeErr := tritonClient.conn.Close(); closeErr != nil {
-			Logger.Warn().Err(closeErr).Msg("triton: error closing existing connection")
-		}
+	if tritonEndpoints != nil {
+		tritonEndpoints.close()
 	}
+	tritonEndpoints = newTritonPool(endpoints)
+	Logger.Info().Msgf("Triton gRPC client connected to %s", strings.Join(addrs, ", "))
 
-	tritonClient = &TritonClient{
-		conn:   conn,
-		client: tritongprc.NewGRPCInferenceServiceClient(conn),
+	p := yoloConfig(context.Background())
+	var firstErr error
+	usable := 0
+	for _, tc := range endpoints {
+		info, err := tc.modelInfo(context.Background(), p)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		usable++
+		if Config.GetBool("triton_shared_memory") {
+			shmParams := p
+			shmParams.apply(info)
+			tc.setupSharedMemory(shmParams, info)
+		}
+	}
+	if usable == 0 {
+		return firstErr
 	}
-	Logger.Info().Msgf("Triton gRPC client connected to %s", addr)
 	return nil
 }
 
-// cocoClasses maps COCO class indices to human-readable names (80 classes).
+// tritonAddrs lists the inference endpoints: `triton_urls`, or the single
+// `triton_url` when that is empty.
+func tritonAddrs() []string {
+	var addrs []string
+	for _, addr := range Config.GetStringSlice("triton_urls") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		addr := Config.GetString("triton_url")
+		if addr == "" {
+			addr = "10.0.4.226:8001"
+		}
+		addrs = []string{addr}
+	}
+	return addrs
+}
+
+// close releases the endpoint's shared memory, once the requests using it
+// have finished, and its connection.
+func (tc *TritonClient) close() {
+	if tc.shm != nil {
+		tc.shm.unregister(tc.client)
+	}
+	if err := tc.conn.Close(); err != nil {
+		Logger.Warn().Err(err).Msgf("triton: error closing connection to %s", tc.addr)
+	}
+}
+
+// modelInfo returns the server-derived description of the model p names,
+// probing the server the first time it is reachable. A nil info with a nil
+// error means the server does not offer metadata and the configured tensor
+// settings are used as-is.
+func (tc *TritonClient) modelInfo(ctx context.Context, p yoloParams) (*TritonModelInfo, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	st := tc.state(p)
+	if st.err != nil || st.info != nil || st.probed {
+		return st.info, st.err
+	}
+	info, err := probeTritonModel(ctx, tc.client, p.model, p.version, p.settings)
+	switch {
+	case err == nil:
+		st.info = info
+		Logger.Info().Msgf("Triton model %s: input %s %s %dx%d, outputs %v (%s), max batch %d",
+			info.Model, info.InputName, info.InputDatatype, info.Width, info.Height,
+			info.Outputs, info.Format, info.MaxBatchSize)
+		if labels := detectionLabels(p.model, info); info.Classes > 0 && len(labels) != info.Classes {
+			Logger.Warn().Msgf("Triton model %s has %d classes but %d labels; set labels_files for it", p.model, info.Classes, len(labels))
+		}
+	case errors.Is(err, errTritonMetadataUnavailable):
+		if errors.Is(err, errTritonMetadataUnsupported) {
+			st.probed = true
+			Logger.Warn().Msgf("%v; using configured tensor settings", err)
+		} else {
+			Logger.Warn().Msgf("%v; will retry before the next inference", err)
+		}
+	default:
+		st.err = err
+		Logger.Error().Msgf("%v; inference disabled until the config changes", err)
+	}
+	return st.info, st.err
+}
+
+// state returns the endpoint's state for the model p names; tc.mu must be held.
+func (tc *TritonClient) state(p yoloParams) *modelState {
+	st := tc.models[p.key]
+	if st == nil {
+		if tc.models == nil {
+			tc.models = make(map[modelKey]*modelState)
+		}
+		st = &modelState{}
+		tc.models[p.key] = st
+	}
+	return st
+}
+
+// cachedInfo returns the model description if it has already been read.
+func (tc *TritonClient) cachedInfo(p yoloParams) (*TritonModelInfo, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	st := tc.state(p)
+	return st.info, st.info != nil
+}
+
+// compatible reports whether the endpoint can serve the model p names.
+func (tc *TritonClient) compatible(p yoloParams) bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.state(p).err == nil
+}
+
+// TritonModel returns the description of the default model derived from the
+// servers, if the client has been able to read it.
+func TritonModel() (TritonModelInfo, bool) {
+	tp := tritonEndpoints
+	if tp == nil {
+		return TritonModelInfo{}, false
+	}
+	p := yoloConfig(context.Background())
+	for _, tc := range tp.endpoints {
+		if info, ok := tc.cachedInfo(p); ok {
+			return *info, true
+		}
+	}
+	return TritonModelInfo{}, false
+}
+
+// cocoClasses maps COCO class indices to human-readable names (80 classes). It
+// is the default label list; see labels.go.
 var cocoClasses = []string{
 	"person", "bicycle", "car", "motorcycle", "airplane", "bus", "train",
 	"truck", "boat", "traffic light", "fire hydrant", "stop sign",
@@ -90,231 +258,247 @@ var cocoClasses = []string{
 }
 
 // DetectObjects submits a JPEG image to the Triton Inference Server running
-// YOLO11 and returns the detected objects above the configured confidence
-// threshold.  The caller is responsible for converting the raw JPEG bytes.
+// YOLO11 over gRPC and returns the detected objects above the configured
+// confidence threshold. Most callers should go through CurrentDetector instead,
+// which honours the configured backend.
 func DetectObjects(jpegData []byte) ([]TritonDetection, error) {
-	if tritonClient == nil {
-		return nil, fmt.Errorf("triton client not initialized")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), DetectorTimeout())
+	defer cancel()
+	return tritonGRPCDetector{}.Detect(ctx, jpegData)
+}
 
-	// --- config ----------------------------------------------------------
-	modelName := Config.GetString("triton_model")
-	if modelName == "" {
-		modelName = "yolo11"
-	}
-	modelVersion := Config.GetString("triton_model_version")
-	inputWidth := Config.GetInt("triton_input_width")
-	if inputWidth <= 0 {
-		inputWidth = 640
-	}
-	inputHeight := Config.GetInt("triton_input_height")
-	if inputHeight <= 0 {
-		inputHeight = 640
-	}
-	minConf := float32(Config.GetFloat64("min_confidence"))
-	if minConf <= 0 {
-		minConf = 0.5
-	}
-	iouThresh := float32(Config.GetFloat64("triton_iou_threshold"))
-	if iouThresh <= 0 {
-		iouThresh = 0.45
+// tritonGRPCDetector is the Detector backed by the shared Triton gRPC client.
+type tritonGRPCDetector struct{}
+
+func (tritonGRPCDetector) Name() string { return DetectorTritonGRPC }
+
+func (tritonGRPCDetector) Detect(ctx context.Context, jpegData []byte) ([]TritonDetection, error) {
+	tp, p, info, err := tritonParams(ctx)
+	if err != nil {
+		return nil, err
 	}
-	inputTensorName := Config.GetString("triton_input_name")
-	if inputTensorName == "" {
-		inputTensorName = "images"
+	rawBytes, lb, err := prepareInput(jpegData, p)
+	if err != nil {
+		return nil, fmt.Errorf("triton: %w", err)
 	}
-	outputTensorName := Config.GetString("triton_output_name")
-	if outputTensorName == "" {
-		outputTensorName = "output0"
+	dets, err := tp.infer(ctx, p, info, [][]byte{rawBytes}, []letterbox{lb})
+	releaseTensor(rawBytes)
+	if err != nil {
+		return nil, err
 	}
+	RecordInferenceBatch(p.model, "single", 1, 0)
+	return dets[0], nil
+}
 
-	// --- preprocessing ---------------------------------------------------
-	preStart := time.Now()
-	imgRaw, _, err := image.Decode(bytes.NewReader(jpegData))
+// tritonParams returns the current endpoints and the parameters of the model
+// for the camera in ctx, with the tensor settings derived from the servers
+// where they provide them.
+func tritonParams(ctx context.Context) (*tritonPool, yoloParams, *TritonModelInfo, error) {
+	tp := tritonEndpoints
+	if tp == nil {
+		return nil, yoloParams{}, nil, fmt.Errorf("triton client not initialized")
+	}
+	p := yoloConfig(ctx)
+	info, err := tp.modelInfo(ctx, p)
 	if err != nil {
-		return nil, fmt.Errorf("triton: decode image: %w", err)
+		return nil, yoloParams{}, nil, err
 	}
-	origW := imgRaw.Bounds().Dx()
-	origH := imgRaw.Bounds().Dy()
-
-	// Letterbox resize to keep aspect ratio, pad with grey (114).
-	resized, scale, padX, padY := letterboxResize(imgRaw, inputWidth, inputHeight)
-
-	// Convert to NCHW float32 normalised to [0, 1].
-	rawInput := imageToNCHW(resized, inputWidth, inputHeight)
+	p.apply(info)
+	return tp, p, info, nil
+}
 
-	// Build raw bytes (little-endian float32).
-	rawBytes := make([]byte, len(rawInput)*4)
-	for i, v := range rawInput {
-		binary.LittleEndian.PutUint32(rawBytes[i*4:], math.Float32bits(v))
+// infer sends one ModelInfer request for a batch of preprocessed images to
+// this endpoint and decodes the detections for each, in input order.
+func (tc *TritonClient) infer(ctx context.Context, p yoloParams, info *TritonModelInfo, inputs [][]byte, lbs []letterbox) ([][]TritonDetection, error) {
+	n := len(inputs)
+	input := &tritongprc.ModelInferRequest_InferInputTensor{
+		Name:     p.inputName,
+		Datatype: p.datatype,
+		Shape:    p.inputShape(inputs),
 	}
-	RecordPreprocess(time.Since(preStart))
-
-	// --- gRPC inference request ------------------------------------------
 	req := &tritongprc.ModelInferRequest{
-		ModelName:    modelName,
-		ModelVersion: modelVersion,
-		Inputs: []*tritongprc.ModelInferRequest_InferInputTensor{
-			{
-				Name:     inputTensorName,
-				Datatype: "FP32",
-				Shape:    []int64{1, 3, int64(inputHeight), int64(inputWidth)},
-			},
-		},
-		Outputs: []*tritongprc.ModelInferRequest_InferRequestedOutputTensor{
-			{Name: outputTensorName},
-		},
-		RawInputContents: [][]byte{rawBytes},
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+		ModelName:    p.model,
+		ModelVersion: p.version,
+		Inputs:       []*tritongprc.ModelInferRequest_InferInputTensor{input},
+	}
+	size := 0
+	for _, in := range inputs {
+		size += len(in)
+	}
+	inRequest := true
+	if tc.shm != nil && size <= tc.shm.slotSize {
+		slot, err := tc.shm.acquire(ctx)
+		switch {
+		case errors.Is(err, errSharedMemoryClosed):
+		case err != nil:
+			return nil, fmt.Errorf("triton: %w", err)
+		default:
+			defer tc.shm.release(slot)
+			input.Parameters = tc.shm.write(slot, inputs)
+			inRequest = false
+		}
+	}
+	if inRequest {
+		raw := inputs[0]
+		if n > 1 {
+			raw = bytes.Join(inputs, nil)
+		}
+		req.RawInputContents = [][]byte{raw}
+	}
+	for _, name := range p.outputs {
+		req.Outputs = append(req.Outputs, &tritongprc.ModelInferRequest_InferRequestedOutputTensor{Name: name})
+	}
 
 	inferStart := time.Now()
-	resp, err := tritonClient.client.ModelInfer(ctx, req)
+	resp, err := tc.client.ModelInfer(ctx, req)
 	if err != nil {
-		RecordDetection(modelName, "error", time.Since(inferStart))
+		RecordDetection(p.model, tc.addr, "error", time.Since(inferStart))
 		return nil, fmt.Errorf("triton: ModelInfer RPC: %w", err)
 	}
-	RecordDetection(modelName, "ok", time.Since(inferStart))
+	RecordDetection(p.model, tc.addr, "ok", time.Since(inferStart))
 
-	// --- parse output tensor ---------------------------------------------
-	// YOLO11 exports to ONNX with shape [1, 4+numClasses, numAnchors].
-	// Triton returns raw bytes in RawOutputContents.
-	if len(resp.Outputs) == 0 || len(resp.RawOutputContents) == 0 {
+	// Triton returns raw bytes in RawOutputContents, in the order of Outputs.
+	if len(resp.Outputs) == 0 || len(resp.RawOutputContents) != len(resp.Outputs) {
 		return nil, fmt.Errorf("triton: empty response outputs")
 	}
-
-	outTensor := resp.Outputs[0]
-	rawOut := resp.RawOutputContents[0]
-
-	if len(outTensor.Shape) < 3 {
-		return nil, fmt.Errorf("triton: unexpected output shape rank %d", len(outTensor.Shape))
-	}
-	// shape: [batch, rows, anchors]  – rows = 4 + numClasses
-	numRows := int(outTensor.Shape[1])    // 4 + numClasses
-	numAnchors := int(outTensor.Shape[2]) // e.g. 8400
-	numClasses := numRows - 4
-
-	if numClasses <= 0 {
-		return nil, fmt.Errorf("triton: invalid output shape: rows=%d", numRows)
-	}
-
-	floats, err := rawBytesToFloat32(rawOut)
-	if err != nil {
-		return nil, fmt.Errorf("triton: parse output tensor: %w", err)
-	}
-
-	// --- decode detections -----------------------------------------------
-	var detections []detBox
-	for a := 0; a < numAnchors; a++ {
-		// Each anchor: cx, cy, w, h, cls0 ... cls(n-1)
-		cx := floats[0*numAnchors+a]
-		cy := floats[1*numAnchors+a]
-		w := floats[2*numAnchors+a]
-		h := floats[3*numAnchors+a]
-
-		// Find best class.
-		bestClass := 0
-		bestScore := float32(0)
-		for c := 0; c < numClasses; c++ {
-			score := floats[(4+c)*numAnchors+a]
-			if score > bestScore {
-				bestScore = score
-				bestClass = c
-			}
+	perImage := make([][]outputTensor, n)
+	for i, o := range resp.Outputs {
+		data, err := tensorToFloat32(o.Datatype, resp.RawOutputContents[i])
+		if err != nil {
+			return nil, fmt.Errorf("triton: parse output %s: %w", o.Name, err)
 		}
-
-		if bestScore < minConf {
-			continue
+		split, err := splitBatch(outputTensor{name: o.Name, shape: o.Shape, data: data}, n)
+		if err != nil {
+			return nil, fmt.Errorf("triton: %w", err)
 		}
-
-		// Convert center format → xyxy (still in model-input pixel space).
-		x1 := cx - w/2
-		y1 := cy - h/2
-		x2 := cx + w/2
-		y2 := cy + h/2
-		detections = append(detections, detBox{x1, y1, x2, y2, bestScore, bestClass})
-	}
-
-	// Non-Maximum Suppression.
-	kept := nms(detections, iouThresh)
-
-	// Convert coordinates back to original image space.
-	var results []TritonDetection
-	for _, d := range kept {
-		// Remove letterbox padding, undo scale.
-		ox1 := int(math.Round(float64((d.x1 - float32(padX)) / float32(scale)))) //nolint:mnd
-		oy1 := int(math.Round(float64((d.y1 - float32(padY)) / float32(scale)))) //nolint:mnd
-		ox2 := int(math.Round(float64((d.x2 - float32(padX)) / float32(scale)))) //nolint:mnd
-		oy2 := int(math.Round(float64((d.y2 - float32(padY)) / float32(scale)))) //nolint:mnd
-
-		// Clamp to original image bounds.
-		ox1 = clampInt(ox1, 0, origW)
-		oy1 = clampInt(oy1, 0, origH)
-		ox2 = clampInt(ox2, 0, origW)
-		oy2 = clampInt(oy2, 0, origH)
-
-		label := "unknown"
-		if d.classIdx < len(cocoClasses) {
-			label = cocoClasses[d.classIdx]
+		for j := range split {
+			perImage[j] = append(perImage[j], split[j])
+		}
+	}
+	labels := detectionLabels(p.model, info)
+	results := make([][]TritonDetection, n)
+	for j, outputs := range perImage {
+		if results[j], err = decodeYOLOOutputs(p.format, outputs, lbs[j], labels, p.minConf, p.iou); err != nil {
+			return nil, fmt.Errorf("triton: %w", err)
 		}
-
-		results = append(results, TritonDetection{
-			Label:      label,
-			Confidence: d.conf,
-			XMin:       ox1,
-			YMin:       oy1,
-			XMax:       ox2,
-			YMax:       oy2,
-		})
 	}
-
 	return results, nil
 }
 
-// letterboxResize resizes src to fit inside a targetW×targetH canvas while
-// preserving aspect ratio, padding the borders with grey (114,114,114).
-// Returns the padded image along with the scale factor and (padX, padY) offsets.
-func letterboxResize(src image.Image, targetW, targetH int) (image.Image, float64, int, int) {
-	srcW := src.Bounds().Dx()
-	srcH := src.Bounds().Dy()
-
-	scale := math.Min(float64(targetW)/float64(srcW), float64(targetH)/float64(srcH))
-	newW := int(math.Round(float64(srcW) * scale))
-	newH := int(math.Round(float64(srcH) * scale))
-
-	padX := (targetW - newW) / 2
-	padY := (targetH - newH) / 2
-
-	// Fill with grey.
-	canvas := image.NewRGBA(image.Rect(0, 0, targetW, targetH))
-	for y := 0; y < targetH; y++ {
-		for x := 0; x < targetW; x++ {
-			canvas.SetRGBA(x, y, color.RGBA{R: 114, G: 114, B: 114, A: 255})
-		}
+// yoloParams is the YOLO model configuration shared by the Triton backends.
+type yoloParams struct {
+	model     string
+	version   string
+	inputName string
+	datatype  string // input encoding
+	jpeg      bool   // send the JPEG for the server to decode
+	outputs   []string
+	format    string
+	width     int
+	height    int
+	minConf   float32
+	iou       float32
+	settings  modelSettings // where the above came from
+	key       modelKey      // the configuration, before apply
+}
+
+// apply overrides the configured tensor settings with those derived from the
+// server; info may be nil.
+func (p *yoloParams) apply(info *TritonModelInfo) {
+	if info == nil {
+		return
 	}
+	p.inputName, p.datatype, p.outputs, p.format = info.InputName, info.InputDatatype, info.Outputs, info.Format
+	p.width, p.height = info.Width, info.Height
+	p.jpeg = info.JPEGInput
+}
 
-	// Scale src into the canvas.
-	dst := image.Rect(padX, padY, padX+newW, padY+newH)
-	draw.BiLinear.Scale(canvas, dst, src, src.Bounds(), draw.Over, nil)
+// inputShape is the shape of an input tensor holding the prepared inputs.
+func (p yoloParams) inputShape(inputs [][]byte) []int64 {
+	n := int64(len(inputs))
+	switch {
+	case p.jpeg && p.datatype == InputBYTES:
+		return []int64{n, 1}
+	case p.jpeg:
+		return []int64{n, int64(len(inputs[0]))}
+	}
+	return []int64{n, 3, int64(p.height), int64(p.width)}
+}
 
-	return canvas, scale, padX, padY
+// batchLimit is the largest batch of inputs one request can carry. JPEGs sent
+// as UINT8 differ in length, so they cannot share a tensor.
+func (p yoloParams) batchLimit(info *TritonModelInfo, configured int) int {
+	if p.jpeg && p.datatype != InputBYTES {
+		return 1
+	}
+	return info.batchLimit(configured)
 }
 
-// imageToNCHW converts an image.Image to a CHW float32 slice normalised to
-// [0, 1] in RGB channel order.
-func imageToNCHW(img image.Image, w, h int) []float32 {
-	out := make([]float32, 3*h*w)
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			out[0*h*w+y*w+x] = float32(r>>8) / 255.0 //nolint:mnd
-			out[1*h*w+y*w+x] = float32(g>>8) / 255.0 //nolint:mnd
-			out[2*h*w+y*w+x] = float32(b>>8) / 255.0 //nolint:mnd
+// yoloConfig returns the model parameters for the camera in ctx: its
+// camera_models profile over the global config.
+func yoloConfig(ctx context.Context) yoloParams {
+	s := settingsFor(cameraModel(ctx))
+	p := yoloParams{
+		model:     s.getString("triton_model"),
+		version:   s.getString("triton_model_version"),
+		inputName: s.getString("triton_input_name"),
+		datatype:  s.getString("triton_input_datatype"),
+		format:    s.getString("yolo_output_format"),
+		width:     s.getInt("triton_input_width"),
+		height:    s.getInt("triton_input_height"),
+		minConf:   float32(Config.GetFloat64("min_confidence")),
+		iou:       float32(Config.GetFloat64("triton_iou_threshold")),
+		settings:  s,
+	}
+	if p.model == "" {
+		p.model = "yolo11"
+	}
+	if p.inputName == "" {
+		p.inputName = "images"
+	}
+	if p.datatype == "" {
+		p.datatype = InputFP32
+	}
+	if p.jpeg = modelInputMode(p.model) == InputModeJPEG; p.jpeg && p.datatype != InputUINT8 {
+		p.datatype = InputBYTES
+	}
+	if p.format == "" {
+		p.format = YOLOFormatAuto
+	}
+	if p.format == YOLOFormatEnsemble {
+		p.outputs = Config.GetStringSlice("triton_ensemble_outputs")
+		if len(p.outputs) == 0 {
+			p.outputs = []string{"boxes", "scores", "classes"}
+		}
+	} else {
+		output := Config.GetString("triton_output_name")
+		if output == "" {
+			output = "output0"
 		}
+		p.outputs = []string{output}
 	}
-	return out
+	if p.width <= 0 {
+		p.width = 640
+	}
+	if p.height <= 0 {
+		p.height = 640
+	}
+	if p.minConf <= 0 {
+		p.minConf = 0.5
+	}
+	if p.iou <= 0 {
+		p.iou = 0.45
+	}
+	p.key = modelKey{model: p.model, version: p.version, format: p.format, width: p.width, height: p.height}
+	return p
+}
+
+// letterbox records how an image was fitted into the model input, so boxes can
+// be mapped back to the original image.
+type letterbox struct {
+	scale        float64
+	padX, padY   int
+	origW, origH int
 }
 
 // rawBytesToFloat32 interprets a byte slice as little-endian float32 values.
@@ -352,6 +536,8 @@ type detBox struct {
 	x1, y1, x2, y2 float32
 	conf           float32
 	classIdx       int
+	kpts           []float32 // x,y,visibility triples for pose models
+	coeffs         []float32 // mask coefficients for segmentation models
 }
 
 // nms applies per-class greedy Non-Maximum Suppression.
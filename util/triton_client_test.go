@@ -0,0 +1,471 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+	"golang.org/x/image/draw"
+)
+
+// metadataServer is a Triton mock serving one model. With meta nil the
+// metadata RPCs are left unimplemented, like a minimal KServe server.
+type metadataServer struct {
+	tritonpb.UnimplementedGRPCInferenceServiceServer
+	meta   *tritonpb.ModelMetadataResponse
+	config *tritonpb.ModelConfig
+	infers atomic.Int32
+	batch  atomic.Int64 // largest batch seen
+	input  atomic.Int64 // input bytes in the last request
+}
+
+func (s *metadataServer) ServerReady(context.Context, *tritonpb.ServerReadyRequest) (*tritonpb.ServerReadyResponse, error) {
+	if s.meta == nil {
+		return s.UnimplementedGRPCInferenceServiceServer.ServerReady(context.Background(), nil)
+	}
+	return &tritonpb.ServerReadyResponse{Ready: true}, nil
+}
+
+func (s *metadataServer) ModelReady(_ context.Context, req *tritonpb.ModelReadyRequest) (*tritonpb.ModelReadyResponse, error) {
+	return &tritonpb.ModelReadyResponse{Ready: req.GetName() == s.meta.GetName()}, nil
+}
+
+func (s *metadataServer) ModelMetadata(context.Context, *tritonpb.ModelMetadataRequest) (*tritonpb.ModelMetadataResponse, error) {
+	return s.meta, nil
+}
+
+func (s *metadataServer) ModelConfig(context.Context, *tritonpb.ModelConfigRequest) (*tritonpb.ModelConfigResponse, error) {
+	return &tritonpb.ModelConfigResponse{Config: s.config}, nil
+}
+
+func (s *metadataServer) ModelInfer(_ context.Context, req *tritonpb.ModelInferRequest) (*tritonpb.ModelInferResponse, error) {
+	s.infers.Add(1)
+	n := req.GetInputs()[0].GetShape()[0]
+	if raw := req.GetRawInputContents(); len(raw) > 0 {
+		s.input.Store(int64(len(raw[0])))
+	}
+	if n > s.batch.Load() {
+		s.batch.Store(n)
+	}
+	const anchors = 100
+	floats := yoloOutput(anchors)
+	raw := make([]byte, 0, int(n)*len(floats)*4)
+	for range n {
+		for _, v := range floats {
+			raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(v))
+		}
+	}
+	return &tritonpb.ModelInferResponse{
+		ModelName:         req.GetModelName(),
+		Outputs:           []*tritonpb.ModelInferResponse_InferOutputTensor{{Name: "output0", Datatype: "FP32", Shape: []int64{n, 84, anchors}}},
+		RawOutputContents: [][]byte{raw},
+	}, nil
+}
+
+func startMetadataServer(t *testing.T, s tritonpb.GRPCInferenceServiceServer) {
+	t.Helper()
+	addr, _ := serveTriton(t, s)
+	Config.Set("triton_url", addr)
+	Config.Set("triton_model", "yolo11")
+}
+
+func yoloMetadata(inputType string, h, w int64) *tritonpb.ModelMetadataResponse {
+	return &tritonpb.ModelMetadataResponse{
+		Name:     "yolo11",
+		Versions: []string{"1"},
+		Platform: "onnxruntime_onnx",
+		Inputs:   []*tritonpb.ModelMetadataResponse_TensorMetadata{{Name: "images", Datatype: inputType, Shape: []int64{-1, 3, h, w}}},
+		Outputs:  []*tritonpb.ModelMetadataResponse_TensorMetadata{{Name: "output0", Datatype: "FP32", Shape: []int64{-1, 84, -1}}},
+	}
+}
+
+func TestResolveTritonModel(t *testing.T) {
+	none := modelSettings{explicit: func(string) bool { return false }}
+	all := modelSettings{explicit: func(string) bool { return true }}
+	Config.Set("triton_input_width", 640)
+	Config.Set("triton_input_height", 640)
+	Config.Set("triton_input_name", "images")
+	Config.Set("triton_input_datatype", "FP32")
+	Config.Set("triton_output_name", "output0")
+	defer func() {
+		Config.Set("triton_input_width", nil)
+		Config.Set("triton_input_height", nil)
+	}()
+
+	info, err := resolveTritonModel(yoloMetadata("FP32", 320, 320), &tritonpb.ModelConfig{MaxBatchSize: 8}, none)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if info.InputName != "images" || len(info.Outputs) != 1 || info.Outputs[0] != "output0" || info.Width != 320 || info.Height != 320 || info.MaxBatchSize != 8 {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	info, err = resolveTritonModel(yoloMetadata("FP32", -1, -1), nil, none)
+	if err != nil || info.Width != 640 || info.Height != 640 {
+		t.Errorf("dynamic dims should use config: %+v, %v", info, err)
+	}
+
+	twoOutputs := yoloMetadata("FP32", 640, 640)
+	twoOutputs.Outputs = append(twoOutputs.Outputs, &tritonpb.ModelMetadataResponse_TensorMetadata{Name: "output1", Datatype: "FP32", Shape: []int64{-1, 84, -1}})
+
+	nhwc := &tritonpb.ModelConfig{Input: []*tritonpb.ModelInput{{Name: "images", Format: tritonpb.ModelInput_FORMAT_NHWC}}}
+	badShape := yoloMetadata("FP32", 640, 640)
+	badShape.Inputs[0].Shape = []int64{-1, 640, 640, 3}
+
+	failures := []struct {
+		name     string
+		meta     *tritonpb.ModelMetadataResponse
+		cfg      *tritonpb.ModelConfig
+		settings modelSettings
+		want     string
+	}{
+		{"explicit size mismatch", yoloMetadata("FP32", 320, 320), nil, all, "triton_input_height is 640 but the model expects 320"},
+		{"unsupported input type", yoloMetadata("INT16", 640, 640), nil, none, "datatype INT16"},
+		{"explicit datatype mismatch", yoloMetadata("FP16", 640, 640), nil, all, "triton_input_datatype is FP32"},
+		{"nhwc", yoloMetadata("FP32", 640, 640), nhwc, none, "NHWC"},
+		{"channels last", badShape, nil, none, "3 channels first"},
+		{"ambiguous output", twoOutputs, nil, none, "set triton_output_name"},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveTritonModel(tt.meta, tt.cfg, tt.settings)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	jpegModel := yoloMetadata("BYTES", 0, 0)
+	jpegModel.Inputs[0].Shape = []int64{-1, 1}
+	info, err = resolveTritonModel(jpegModel, nil, none)
+	if err != nil || !info.JPEGInput || !info.DynamicBatch || info.Width != 640 {
+		t.Errorf("BYTES input: %+v, %v", info, err)
+	}
+	rawJPEG := yoloMetadata("UINT8", 0, 0)
+	rawJPEG.Inputs[0].Shape = []int64{-1, -1}
+	if info, err = resolveTritonModel(rawJPEG, nil, none); err != nil || !info.JPEGInput {
+		t.Errorf("1-D UINT8 input: %+v, %v", info, err)
+	}
+	if info, err = resolveTritonModel(yoloMetadata("UINT8", 640, 640), nil, none); err != nil || info.JPEGInput {
+		t.Errorf("UINT8 image tensor: %+v, %v", info, err)
+	}
+	Config.Set("triton_input_modes", map[string]string{"yolo11": "jpeg"})
+	if _, err := resolveTritonModel(yoloMetadata("FP32", 640, 640), nil, none); err == nil || !strings.Contains(err.Error(), "expected BYTES") {
+		t.Errorf("jpeg mode for a tensor model: %v", err)
+	}
+	Config.Set("triton_input_modes", map[string]string{"yolo11": "tensor"})
+	if _, err := resolveTritonModel(jpegModel, nil, none); err == nil || !strings.Contains(err.Error(), "takes encoded images") {
+		t.Errorf("tensor mode for a jpeg model: %v", err)
+	}
+	Config.Set("triton_input_modes", nil)
+
+	Config.Set("triton_output_name", "missing")
+	if _, err := resolveTritonModel(yoloMetadata("FP32", 640, 640), nil, all); err == nil || !strings.Contains(err.Error(), `"missing" not found`) {
+		t.Errorf("expected a missing output error, got %v", err)
+	}
+	Config.Set("triton_output_name", "output0")
+}
+
+func TestInitTritonClientMetadata(t *testing.T) {
+	jpegData := testJPEG(t, 64, 64)
+	Config.Set("min_confidence", 0.5)
+
+	t.Run("derives model", func(t *testing.T) {
+		startMetadataServer(t, &metadataServer{meta: yoloMetadata("FP32", 64, 64)})
+		if err := InitTritonClient(); err != nil {
+			t.Fatalf("InitTritonClient: %v", err)
+		}
+		info, ok := TritonModel()
+		if !ok || info.Width != 64 || info.InputName != "images" {
+			t.Fatalf("TritonModel = %+v, %v", info, ok)
+		}
+		dets, err := DetectObjects(jpegData)
+		if err != nil || len(dets) != 1 {
+			t.Errorf("DetectObjects = %+v, %v", dets, err)
+		}
+	})
+
+	for _, dt := range []string{InputFP16, InputUINT8} {
+		t.Run(dt+" input", func(t *testing.T) {
+			s := &metadataServer{meta: yoloMetadata(dt, 64, 64)}
+			startMetadataServer(t, s)
+			if err := InitTritonClient(); err != nil {
+				t.Fatalf("InitTritonClient: %v", err)
+			}
+			if dets, err := DetectObjects(jpegData); err != nil || len(dets) != 1 {
+				t.Errorf("DetectObjects = %+v, %v", dets, err)
+			}
+			if got, want := s.input.Load(), int64(3*64*64*inputElementSize(dt)); got != want {
+				t.Errorf("sent %d input bytes, expected %d", got, want)
+			}
+		})
+	}
+
+	t.Run("incompatible model", func(t *testing.T) {
+		s := &metadataServer{meta: yoloMetadata("INT16", 64, 64)}
+		startMetadataServer(t, s)
+		if err := InitTritonClient(); err == nil {
+			t.Fatal("expected InitTritonClient to reject the model")
+		}
+		if _, err := DetectObjects(jpegData); err == nil || !strings.Contains(err.Error(), "INT16") {
+			t.Errorf("DetectObjects should refuse with the model error, got %v", err)
+		}
+		if n := s.infers.Load(); n != 0 {
+			t.Errorf("ModelInfer called %d times for an incompatible model", n)
+		}
+	})
+
+	t.Run("metadata unimplemented", func(t *testing.T) {
+		Config.Set("triton_input_width", 64)
+		Config.Set("triton_input_height", 64)
+		defer func() {
+			Config.Set("triton_input_width", nil)
+			Config.Set("triton_input_height", nil)
+		}()
+		startMetadataServer(t, &metadataServer{})
+		if err := InitTritonClient(); err != nil {
+			t.Fatalf("InitTritonClient: %v", err)
+		}
+		if _, ok := TritonModel(); ok {
+			t.Error("no model info expected without metadata")
+		}
+		if dets, err := DetectObjects(jpegData); err != nil || len(dets) != 1 {
+			t.Errorf("DetectObjects = %+v, %v", dets, err)
+		}
+	})
+}
+
+// noisyJPEG encodes a w×h image with varied colours, so scaling and channel
+// order errors show up.
+func noisyJPEG(tb testing.TB, w, h int) []byte {
+	tb.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 7), G: uint8(y * 5), B: uint8((x + y) * 3), A: 255}) //nolint:gosec // wraps on purpose
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// referenceYOLOInput is the straightforward per-pixel preprocessing that
+// prepareYOLOInput replaces, returning values normalised to [0, 1]. The old
+// path scaled with draw.BiLinear.
+func referenceYOLOInput(tb testing.TB, jpegData []byte, width, height int, scaler draw.Scaler) ([]float32, letterbox) {
+	tb.Helper()
+	src, _, err := image.Decode(bytes.NewReader(jpegData))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	scale := math.Min(float64(width)/float64(srcW), float64(height)/float64(srcH))
+	newW := int(math.Round(float64(srcW) * scale))
+	newH := int(math.Round(float64(srcH) * scale))
+	lb := letterbox{scale: scale, padX: (width - newW) / 2, padY: (height - newH) / 2, origW: srcW, origH: srcH}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			canvas.SetRGBA(x, y, color.RGBA{R: 114, G: 114, B: 114, A: 255})
+		}
+	}
+	dst := image.Rect(lb.padX, lb.padY, lb.padX+newW, lb.padY+newH)
+	if newW == srcW && newH == srcH {
+		draw.Draw(canvas, dst, src, src.Bounds().Min, draw.Over)
+	} else {
+		scaler.Scale(canvas, dst, src, src.Bounds(), draw.Over, nil)
+	}
+
+	out := make([]float32, 3*height*width)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := canvas.At(x, y).RGBA()
+			out[0*height*width+y*width+x] = float32(r>>8) / 255.0
+			out[1*height*width+y*width+x] = float32(g>>8) / 255.0
+			out[2*height*width+y*width+x] = float32(b>>8) / 255.0
+		}
+	}
+	return out, lb
+}
+
+func TestPrepareYOLOInput(t *testing.T) {
+	// Wide, tall, exact-size and upscaled sources, run back to back so pooled
+	// canvases and tensors are reused between them.
+	sources := [][2]int{{320, 180}, {90, 160}, {64, 64}, {40, 30}}
+	for _, dt := range []string{InputFP32, InputFP16, InputUINT8} {
+		for _, size := range sources {
+			jpegData := noisyJPEG(t, size[0], size[1])
+			want, wantLB := referenceYOLOInput(t, jpegData, 64, 64, draw.ApproxBiLinear)
+			got, lb, err := prepareYOLOInput(jpegData, 64, 64, dt)
+			if err != nil {
+				t.Fatalf("%s %v: %v", dt, size, err)
+			}
+			if lb != wantLB {
+				t.Errorf("%s %v: letterbox %+v, expected %+v", dt, size, lb, wantLB)
+			}
+			values, err := tensorToFloat32(dt, got)
+			if err != nil || len(values) != len(want) {
+				t.Fatalf("%s %v: %d values, %v", dt, size, len(values), err)
+			}
+			for i, v := range values {
+				expected := want[i]
+				switch dt {
+				case InputFP16:
+					expected = float16ToFloat32(float32ToFloat16(expected))
+				case InputUINT8:
+					expected = float32(math.Round(float64(expected) * 255))
+				}
+				if v != expected {
+					t.Fatalf("%s %v: element %d is %g, expected %g", dt, size, i, v, expected)
+				}
+			}
+			releaseTensor(got)
+		}
+	}
+	if _, _, err := prepareYOLOInput(noisyJPEG(t, 8, 8), 64, 64, "INT16"); err == nil {
+		t.Error("expected an error for an unsupported datatype")
+	}
+}
+
+func BenchmarkPrepareYOLOInput(b *testing.B) {
+	jpegData := noisyJPEG(b, 1920, 1080)
+	for _, dt := range []string{InputFP32, InputFP16, InputUINT8} {
+		b.Run(dt, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				tensor, _, err := prepareYOLOInput(jpegData, 640, 640, dt)
+				if err != nil {
+					b.Fatal(err)
+				}
+				releaseTensor(tensor)
+			}
+		})
+	}
+	// The per-pixel path prepareYOLOInput replaced, for comparison.
+	b.Run("reference", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			referenceYOLOInput(b, jpegData, 640, 640, draw.BiLinear)
+		}
+	})
+}
+
+func BenchmarkLetterbox(b *testing.B) {
+	src, _, err := image.Decode(bytes.NewReader(noisyJPEG(b, 1920, 1080)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, 640, 640))
+	b.ReportAllocs()
+	for b.Loop() {
+		letterboxInto(canvas, src)
+	}
+}
+
+func BenchmarkWriteNCHW(b *testing.B) {
+	src, _, err := image.Decode(bytes.NewReader(noisyJPEG(b, 640, 640)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, 640, 640))
+	letterboxInto(canvas, src)
+	for _, dt := range []string{InputFP32, InputFP16, InputUINT8} {
+		b.Run(dt, func(b *testing.B) {
+			dst := make([]byte, 3*640*640*inputElementSize(dt))
+			b.SetBytes(int64(len(dst)))
+			for b.Loop() {
+				writeNCHW(dst, canvas, dt)
+			}
+		})
+	}
+}
+
+// jpegServer is a Triton mock for a model that decodes JPEGs itself: it
+// checks each BYTES element is a JPEG and records its size.
+type jpegServer struct {
+	*metadataServer
+	mu    sync.Mutex
+	sizes []image.Point
+}
+
+func (s *jpegServer) ModelInfer(ctx context.Context, req *tritonpb.ModelInferRequest) (*tritonpb.ModelInferResponse, error) {
+	in := req.GetInputs()[0]
+	if in.GetDatatype() != InputBYTES {
+		return nil, fmt.Errorf("input datatype %s", in.GetDatatype())
+	}
+	raw := req.GetRawInputContents()[0]
+	for i := int64(0); i < in.GetShape()[0]; i++ {
+		n := binary.LittleEndian.Uint32(raw)
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(raw[4 : 4+n]))
+		if err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.sizes = append(s.sizes, image.Pt(cfg.Width, cfg.Height))
+		s.mu.Unlock()
+		raw = raw[4+n:]
+	}
+	if len(raw) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes", len(raw))
+	}
+	return s.metadataServer.ModelInfer(ctx, req)
+}
+
+func TestJPEGInput(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	Config.Set("triton_input_width", 64)
+	Config.Set("triton_input_height", 64)
+	defer func() {
+		Config.Set("triton_input_width", nil)
+		Config.Set("triton_input_height", nil)
+	}()
+	meta := yoloMetadata("BYTES", 0, 0)
+	meta.Inputs[0].Shape = []int64{-1, 1}
+	s := &jpegServer{metadataServer: &metadataServer{meta: meta}}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+
+	// The model-space box (x 22..42 of 64) maps back through the letterbox
+	// the server is expected to apply.
+	dets, err := DetectObjects(testJPEG(t, 128, 128))
+	if err != nil || len(dets) != 1 || dets[0].XMin != 44 || dets[0].XMax != 84 {
+		t.Fatalf("DetectObjects = %+v, %v", dets, err)
+	}
+	if len(s.sizes) != 1 || s.sizes[0] != image.Pt(128, 128) {
+		t.Errorf("server received %v", s.sizes)
+	}
+
+	b := newBatchingDetector(3, time.Second)
+	defer b.Close()
+	var wg sync.WaitGroup
+	for _, size := range []int{32, 64, 96} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if dets, err := b.Detect(context.Background(), testJPEG(t, size, size)); err != nil || len(dets) != 1 || dets[0].XMax != 42*size/64 {
+				t.Errorf("batched %dpx: %+v, %v", size, dets, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.batch.Load(); n != 3 {
+		t.Errorf("expected the JPEGs in one batch of 3, got %d", n)
+	}
+}
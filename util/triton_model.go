@@ -0,0 +1,332 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	tritongprc "github.com/elijahnyp/home_controller/triton/generated"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TritonModelInfo describes the served detection model as derived from the
+// server's ModelMetadata and ModelConfig, so tensor names, input size and
+// datatypes need not be hand-entered.
+type TritonModelInfo struct {
+	Model         string
+	Version       string
+	Platform      string
+	InputName     string
+	InputDatatype string
+	JPEGInput     bool // the model decodes JPEGs itself
+	Width         int
+	Height        int
+	Outputs       []string // requested outputs, in decoder order
+	Format        string   // resolved yolo_output_format
+	MaxBatchSize  int
+	DynamicBatch  bool     // input has a variable leading batch dimension
+	Classes       int      // from the output shape; 0 when unknown
+	Labels        []string // from the model config; nil when it has none
+}
+
+// errTritonMetadataUnavailable marks probe failures that should not stop
+// startup: the server is unreachable or does not implement the metadata RPCs.
+// errTritonMetadataUnsupported is the subset where retrying will not help.
+var (
+	errTritonMetadataUnavailable = errors.New("triton: model metadata unavailable")
+	errTritonMetadataUnsupported = fmt.Errorf("%w: metadata RPCs not implemented", errTritonMetadataUnavailable)
+)
+
+const tritonProbeTimeout = 5 * time.Second
+
+// probeTritonModel checks that the server and model are ready and derives the
+// model's tensor layout. Errors wrapping errTritonMetadataUnavailable mean the
+// model could not be inspected; any other error means it is incompatible.
+func probeTritonModel(ctx context.Context, client tritongprc.GRPCInferenceServiceClient, model, version string, s modelSettings) (*TritonModelInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, tritonProbeTimeout)
+	defer cancel()
+
+	ready, err := client.ServerReady(ctx, &tritongprc.ServerReadyRequest{})
+	if err != nil {
+		return nil, probeError("ServerReady", err)
+	}
+	if !ready.Ready {
+		return nil, fmt.Errorf("%w: server not ready", errTritonMetadataUnavailable)
+	}
+	modelReady, err := client.ModelReady(ctx, &tritongprc.ModelReadyRequest{Name: model, Version: version})
+	if err != nil {
+		return nil, probeError("ModelReady", err)
+	}
+	if !modelReady.Ready {
+		return nil, fmt.Errorf("%w: model %s (version %q) is not ready", errTritonMetadataUnavailable, model, version)
+	}
+	meta, err := client.ModelMetadata(ctx, &tritongprc.ModelMetadataRequest{Name: model, Version: version})
+	if err != nil {
+		return nil, probeError("ModelMetadata", err)
+	}
+	// ModelConfig only adds the batch size and input format; carry on without it.
+	var cfg *tritongprc.ModelConfig
+	if resp, err := client.ModelConfig(ctx, &tritongprc.ModelConfigRequest{Name: model, Version: version}); err == nil {
+		cfg = resp.GetConfig()
+	} else {
+		Logger.Debug().Msgf("triton: ModelConfig for %s unavailable: %v", model, err)
+	}
+	return resolveTritonModel(meta, cfg, s)
+}
+
+func probeError(rpc string, err error) error {
+	switch status.Code(err) {
+	case codes.Unimplemented:
+		return fmt.Errorf("%w: %s: %v", errTritonMetadataUnsupported, rpc, err)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return fmt.Errorf("%w: %s: %v", errTritonMetadataUnavailable, rpc, err)
+	}
+	return fmt.Errorf("triton: %s: %w", rpc, err)
+}
+
+// resolveTritonModel picks the input and output tensors and validates them
+// against what the YOLO pre- and post-processing supports. Values the
+// operator set explicitly in s must agree with the model.
+func resolveTritonModel(meta *tritongprc.ModelMetadataResponse, cfg *tritongprc.ModelConfig, s modelSettings) (*TritonModelInfo, error) {
+	info := &TritonModelInfo{Model: meta.GetName(), Platform: meta.GetPlatform()}
+	if len(meta.GetVersions()) > 0 {
+		info.Version = meta.GetVersions()[0]
+	}
+	if cfg != nil {
+		info.MaxBatchSize = int(cfg.GetMaxBatchSize())
+	}
+
+	in, err := pickTensor("input", meta.GetInputs(), s)
+	if err != nil {
+		return nil, err
+	}
+	info.InputName = in.GetName()
+	info.InputDatatype = in.GetDatatype()
+	if err := resolveInputMode(info, in.GetShape()); err != nil {
+		return nil, err
+	}
+	if info.JPEGInput {
+		// The server resizes to whatever size it was built for; it is not in
+		// the input shape, so it comes from the config.
+		info.Height, _ = resolveDim("triton_input_height", -1, s) //nolint:errcheck // cannot fail for a dynamic dim
+		info.Width, _ = resolveDim("triton_input_width", -1, s)   //nolint:errcheck // cannot fail for a dynamic dim
+	} else if err := resolveTensorInput(info, in, cfg, s); err != nil {
+		return nil, err
+	}
+
+	outs, err := pickOutputs(meta.GetOutputs(), s)
+	if err != nil {
+		return nil, err
+	}
+	tensors := make([]outputTensor, len(outs))
+	labelOutput := outs[0].GetName()
+	for i, o := range outs {
+		switch o.GetDatatype() {
+		case "FP32", "FP16", "INT32", "INT64":
+		default:
+			return nil, fmt.Errorf("triton: model %s output %s has datatype %s; only FP32, FP16, INT32 and INT64 outputs are supported", info.Model, o.GetName(), o.GetDatatype())
+		}
+		info.Outputs = append(info.Outputs, o.GetName())
+		tensors[i] = outputTensor{name: o.GetName(), shape: o.GetShape()}
+		if ensembleRole(o.GetName()) == "class" {
+			labelOutput = o.GetName()
+		}
+	}
+	info.Labels = tritonConfigLabels(cfg, labelOutput)
+
+	info.Format = s.getString("yolo_output_format")
+	if info.Format == "" || info.Format == YOLOFormatAuto {
+		if info.Format, err = detectYOLOFormat(tensors, len(detectionLabels(info.Model, info))); err != nil {
+			return nil, fmt.Errorf("triton: model %s: %w", info.Model, err)
+		}
+	}
+	if dets, protos, ok := segOutputs(tensors); ok {
+		dims, nm := dropBatch(dets.shape, 2), dropBatch(protos.shape, 3)[0]
+		attrs := dims[1]
+		if info.Format == YOLOFormatSeg {
+			attrs = dims[0]
+		}
+		if attrs > 4+nm && nm > 0 {
+			info.Classes = int(attrs - 4 - nm)
+		}
+	}
+	if len(tensors) == 1 {
+		dims := dropBatch(tensors[0].shape, 2)
+		switch {
+		case len(dims) != 2:
+			return nil, fmt.Errorf("triton: model %s output %s has shape %v; expected rank 2 or 3", info.Model, info.Outputs[0], tensors[0].shape)
+		case info.Format == YOLOFormatV8 && dims[0] > 4:
+			info.Classes = int(dims[0]) - 4
+		case info.Format == YOLOFormatV8Transposed && dims[1] > 4:
+			info.Classes = int(dims[1]) - 4
+		case info.Format == YOLOFormatV5 && dims[1] > 5:
+			info.Classes = int(dims[1]) - 5
+		case info.Format == YOLOFormatV5Transposed && dims[0] > 5:
+			info.Classes = int(dims[0]) - 5
+		case info.Format == YOLOFormatPose && dims[0] > 4+poseAttrs:
+			info.Classes = int(dims[0]) - 4 - poseAttrs
+		case info.Format == YOLOFormatPoseTransposed && dims[1] > 4+poseAttrs:
+			info.Classes = int(dims[1]) - 4 - poseAttrs
+		}
+	}
+	return info, nil
+}
+
+// pickOutputs selects the box/score/class outputs of an ensemble, the
+// detection and prototype outputs of a segmentation model, or else the single
+// detection output.
+func pickOutputs(outputs []*tritongprc.ModelMetadataResponse_TensorMetadata, s modelSettings) ([]*tritongprc.ModelMetadataResponse_TensorMetadata, error) {
+	format := s.getString("yolo_output_format")
+	if format == YOLOFormatSeg || format == YOLOFormatSegTransposed || ((format == "" || format == YOLOFormatAuto) && len(outputs) == 2 && !s.isExplicit("triton_output_name")) {
+		tensors := make([]outputTensor, len(outputs))
+		for i, o := range outputs {
+			tensors[i] = outputTensor{name: o.GetName(), shape: o.GetShape()}
+		}
+		if dets, _, ok := segOutputs(tensors); ok {
+			if dets.name == outputs[0].GetName() {
+				return outputs, nil
+			}
+			return []*tritongprc.ModelMetadataResponse_TensorMetadata{outputs[1], outputs[0]}, nil
+		}
+		if format != "" && format != YOLOFormatAuto {
+			return nil, fmt.Errorf("triton: %s model needs a detection output and a rank 4 prototype output", format)
+		}
+	}
+	if format == YOLOFormatEnsemble || ((format == "" || format == YOLOFormatAuto) && len(outputs) > 1 && !s.isExplicit("triton_output_name")) {
+		var picked []*tritongprc.ModelMetadataResponse_TensorMetadata
+		roles := make(map[string]bool)
+		for _, o := range outputs {
+			if role := ensembleRole(o.GetName()); role != "" {
+				picked = append(picked, o)
+				roles[role] = true
+			}
+		}
+		if roles["box"] && roles["score"] && roles["class"] {
+			return picked, nil
+		}
+		if format == YOLOFormatEnsemble {
+			return nil, fmt.Errorf("triton: ensemble model needs outputs named for boxes, scores and classes")
+		}
+	}
+	out, err := pickTensor("output", outputs, s)
+	if err != nil {
+		return nil, err
+	}
+	return []*tritongprc.ModelMetadataResponse_TensorMetadata{out}, nil
+}
+
+// pickTensor selects the configured tensor (triton_input_name or
+// triton_output_name) or, when the model has just one, that one.
+func pickTensor(kind string, tensors []*tritongprc.ModelMetadataResponse_TensorMetadata, s modelSettings) (*tritongprc.ModelMetadataResponse_TensorMetadata, error) {
+	key := "triton_" + kind + "_name"
+	if s.isExplicit(key) {
+		want := s.getString(key)
+		names := make([]string, 0, len(tensors))
+		for _, t := range tensors {
+			if t.GetName() == want {
+				return t, nil
+			}
+			names = append(names, t.GetName())
+		}
+		return nil, fmt.Errorf("triton: %s %q not found; model has %v", key, want, names)
+	}
+	switch len(tensors) {
+	case 0:
+		return nil, fmt.Errorf("triton: model has no %s tensors", kind)
+	case 1:
+		return tensors[0], nil
+	}
+	return nil, fmt.Errorf("triton: model has %d %s tensors; set %s", len(tensors), kind, key)
+}
+
+// resolveInputMode decides whether the model takes encoded JPEGs (a BYTES
+// input, or UINT8 without image dimensions) and checks that against
+// triton_input_modes.
+func resolveInputMode(info *TritonModelInfo, shape []int64) error {
+	switch info.InputDatatype {
+	case InputBYTES:
+		info.JPEGInput = true
+		info.DynamicBatch = len(shape) == 2 && shape[0] < 0
+	case InputUINT8:
+		info.JPEGInput = len(shape) <= 2
+	}
+	switch mode := modelInputMode(info.Model); {
+	case mode == InputModeJPEG && !info.JPEGInput:
+		return fmt.Errorf("triton: triton_input_modes sets %s to jpeg but input %s is %s %v; expected BYTES or a 1-D UINT8 input", info.Model, info.InputName, info.InputDatatype, shape)
+	case mode == InputModeTensor && info.JPEGInput:
+		return fmt.Errorf("triton: model %s input %s takes encoded images; set triton_input_modes for it to jpeg", info.Model, info.InputName)
+	case mode != "" && mode != InputModeJPEG && mode != InputModeTensor:
+		return fmt.Errorf("triton: unknown triton_input_modes value %q for %s", mode, info.Model)
+	}
+	return nil
+}
+
+// resolveTensorInput checks a model that takes an NCHW image tensor and
+// derives its input size.
+func resolveTensorInput(info *TritonModelInfo, in *tritongprc.ModelMetadataResponse_TensorMetadata, cfg *tritongprc.ModelConfig, s modelSettings) error {
+	var err error
+	if inputElementSize(info.InputDatatype) == 0 {
+		return fmt.Errorf("triton: model %s input %s has datatype %s; only FP32, FP16 and UINT8 inputs are supported", info.Model, info.InputName, info.InputDatatype)
+	}
+	if want := s.getString("triton_input_datatype"); s.isExplicit("triton_input_datatype") && want != info.InputDatatype {
+		return fmt.Errorf("triton: triton_input_datatype is %s but model %s input %s is %s", want, info.Model, info.InputName, info.InputDatatype)
+	}
+	if cfg != nil {
+		for _, ci := range cfg.GetInput() {
+			if ci.GetName() == info.InputName && ci.GetFormat() == tritongprc.ModelInput_FORMAT_NHWC {
+				return fmt.Errorf("triton: model %s input %s is NHWC; only NCHW inputs are supported", info.Model, info.InputName)
+			}
+		}
+	}
+	dims := in.GetShape()
+	if len(dims) == 4 {
+		info.DynamicBatch = dims[0] < 0
+		dims = dims[1:] // batch dimension
+	}
+	if len(dims) != 3 {
+		return fmt.Errorf("triton: model %s input %s has shape %v; expected [3, H, W]", info.Model, info.InputName, in.GetShape())
+	}
+	if dims[0] != 3 {
+		return fmt.Errorf("triton: model %s input %s has shape %v; expected 3 channels first (NCHW)", info.Model, info.InputName, in.GetShape())
+	}
+	if info.Height, err = resolveDim("triton_input_height", dims[1], s); err != nil {
+		return fmt.Errorf("triton: model %s input %s: %w", info.Model, info.InputName, err)
+	}
+	if info.Width, err = resolveDim("triton_input_width", dims[2], s); err != nil {
+		return fmt.Errorf("triton: model %s input %s: %w", info.Model, info.InputName, err)
+	}
+	return nil
+}
+
+// resolveDim takes a fixed model dimension, checking any explicit config value
+// against it, or falls back to the config for dynamic (-1) dimensions.
+func resolveDim(key string, dim int64, s modelSettings) (int, error) {
+	configured := s.getInt(key)
+	if s.isExplicit(key) && dim > 0 && int64(configured) != dim {
+		return 0, fmt.Errorf("%s is %d but the model expects %d", key, configured, dim)
+	}
+	if dim > 0 {
+		return int(dim), nil
+	}
+	if configured <= 0 {
+		configured = 640
+	}
+	return configured, nil
+}
+
+// batchLimit caps a configured batch size at what the model accepts: its
+// max_batch_size when the model config is known, otherwise 1 unless the
+// input's batch dimension is variable.
+func (info *TritonModelInfo) batchLimit(configured int) int {
+	switch {
+	case info == nil:
+		return configured
+	case info.MaxBatchSize > 0:
+		return min(configured, info.MaxBatchSize)
+	case info.DynamicBatch:
+		return configured
+	}
+	return 1
+}
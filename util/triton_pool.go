@@ -0,0 +1,226 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tritongprc "github.com/elijahnyp/home_controller/triton/generated"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Multiple inference endpoints. `triton_urls` lists Triton servers that all
+// serve the configured model; `triton_url` is used when it is empty. Each
+// request goes to the healthy endpoint with the fewest requests in flight,
+// and a request refused as unavailable is retried on the next one, so a GPU
+// box going down costs at most one failed attempt per request in flight. With
+// more than one endpoint they are checked every `triton_health_interval`
+// seconds (ServerReady, and ModelReady for each model the endpoint has been
+// asked to serve); an unhealthy endpoint gets no traffic
+// until it passes a check, unless no healthy endpoint remains.
+
+const defaultTritonHealthInterval = 10 * time.Second
+
+var errNoTritonEndpoint = errors.New("triton: no usable endpoint")
+
+// tritonPool routes inference requests across the configured endpoints.
+type tritonPool struct {
+	endpoints []*TritonClient
+	next      atomic.Uint64 // rotates the starting point among equal endpoints
+	done      chan struct{}
+}
+
+func newTritonPool(endpoints []*TritonClient) *tritonPool {
+	tp := &tritonPool{endpoints: endpoints, done: make(chan struct{})}
+	if len(endpoints) > 1 {
+		interval := time.Duration(Config.GetInt64("triton_health_interval")) * time.Second
+		if interval <= 0 {
+			interval = defaultTritonHealthInterval
+		}
+		go tp.healthLoop(interval)
+	}
+	return tp
+}
+
+// close stops health checks and releases every endpoint.
+func (tp *tritonPool) close() {
+	close(tp.done)
+	for _, tc := range tp.endpoints {
+		tc.close()
+	}
+}
+
+// modelInfo returns the model description from the first endpoint that has
+// read it, probing healthy endpoints that have not. A nil info with a nil
+// error means no endpoint offers metadata yet and the configured tensor
+// settings are used as-is; an error means no endpoint can serve the model.
+func (tp *tritonPool) modelInfo(ctx context.Context, p yoloParams) (*TritonModelInfo, error) {
+	for _, tc := range tp.endpoints {
+		if info, ok := tc.cachedInfo(p); ok {
+			return info, nil
+		}
+	}
+	var firstErr error
+	usable := false
+	anyHealthy := slices.ContainsFunc(tp.endpoints, func(tc *TritonClient) bool { return tc.healthy.Load() })
+	for _, tc := range tp.endpoints {
+		if anyHealthy && !tc.healthy.Load() {
+			continue
+		}
+		info, err := tc.modelInfo(ctx, p)
+		if info != nil {
+			return info, nil
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		usable = true
+	}
+	if usable || firstErr == nil {
+		return nil, nil
+	}
+	return nil, firstErr
+}
+
+// infer sends a request to the best endpoint, failing over to the others
+// while they report themselves unavailable.
+func (tp *tritonPool) infer(ctx context.Context, p yoloParams, info *TritonModelInfo, inputs [][]byte, lbs []letterbox) ([][]TritonDetection, error) {
+	var dets [][]TritonDetection
+	err := tp.do(ctx, func(tc *TritonClient) bool { return tc.compatible(p) }, func(tc *TritonClient) error {
+		var err error
+		dets, err = tc.infer(ctx, p, info, inputs, lbs)
+		return err
+	})
+	return dets, err
+}
+
+// do runs call on the best endpoint that ok accepts (any when ok is nil),
+// then on the next best while they report themselves unavailable.
+func (tp *tritonPool) do(ctx context.Context, ok func(*TritonClient) bool, call func(*TritonClient) error) error {
+	var tried []*TritonClient
+	lastErr := errNoTritonEndpoint
+	for {
+		tc := tp.choose(tried, ok)
+		if tc == nil {
+			return lastErr
+		}
+		tried = append(tried, tc)
+		tc.outstanding.Add(1)
+		err := call(tc)
+		tc.outstanding.Add(-1)
+		if err == nil {
+			tc.setHealth(nil)
+			return nil
+		}
+		if ctx.Err() != nil || status.Code(err) != codes.Unavailable {
+			return err
+		}
+		tc.setHealth(err)
+		lastErr = err
+		if len(tried) < len(tp.endpoints) {
+			Logger.Warn().Msgf("triton: %s unavailable, failing over", tc.addr)
+		}
+	}
+}
+
+// choose returns the endpoint not yet tried that ok accepts with the fewest
+// requests in flight, preferring healthy ones. Ties rotate so idle endpoints
+// share the load.
+func (tp *tritonPool) choose(tried []*TritonClient, ok func(*TritonClient) bool) *TritonClient {
+	n := len(tp.endpoints)
+	start := int(tp.next.Add(1) % uint64(n)) //nolint:gosec // n is small
+	var best *TritonClient
+	var bestHealthy bool
+	var bestLoad int64
+	for i := range n {
+		tc := tp.endpoints[(start+i)%n]
+		if slices.Contains(tried, tc) || (ok != nil && !ok(tc)) {
+			continue
+		}
+		healthy, load := tc.healthy.Load(), tc.outstanding.Load()
+		if best == nil || (healthy && !bestHealthy) || (healthy == bestHealthy && load < bestLoad) {
+			best, bestHealthy, bestLoad = tc, healthy, load
+		}
+	}
+	return best
+}
+
+func (tp *tritonPool) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tp.done:
+			return
+		case <-ticker.C:
+		}
+		for _, tc := range tp.endpoints {
+			tc.setHealth(tc.checkHealth(tc.modelsInUse()))
+		}
+	}
+}
+
+// modelsInUse lists the models the endpoint has been asked to serve and can,
+// each version once.
+func (tc *TritonClient) modelsInUse() []modelKey {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	var keys []modelKey
+	for k, st := range tc.models {
+		k = modelKey{model: k.model, version: k.version}
+		if st.err == nil && !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	slices.SortFunc(keys, func(a, b modelKey) int { return strings.Compare(a.model+"\x00"+a.version, b.model+"\x00"+b.version) })
+	return keys
+}
+
+// checkHealth asks the server whether it and the given models are ready to
+// serve. Servers without the readiness RPCs are taken to be ready.
+func (tc *TritonClient) checkHealth(models []modelKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tritonProbeTimeout)
+	defer cancel()
+	ready, err := tc.client.ServerReady(ctx, &tritongprc.ServerReadyRequest{})
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+	case err != nil:
+		return err
+	case !ready.GetReady():
+		return errors.New("server not ready")
+	}
+	for _, m := range models {
+		modelReady, err := tc.client.ModelReady(ctx, &tritongprc.ModelReadyRequest{Name: m.model, Version: m.version})
+		switch {
+		case status.Code(err) == codes.Unimplemented:
+			return nil
+		case err != nil:
+			return err
+		case !modelReady.GetReady():
+			return fmt.Errorf("model %s not ready", m.model)
+		}
+	}
+	return nil
+}
+
+// setHealth marks the endpoint healthy when err is nil and unhealthy
+// otherwise, logging changes.
+func (tc *TritonClient) setHealth(err error) {
+	healthy := err == nil
+	if tc.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		Logger.Info().Msgf("Triton endpoint %s is healthy", tc.addr)
+	} else {
+		Logger.Warn().Msgf("Triton endpoint %s is unhealthy: %v", tc.addr, err)
+	}
+}
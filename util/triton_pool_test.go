@@ -0,0 +1,160 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+	"google.golang.org/grpc"
+)
+
+// serveTriton starts s on a local port, returning its address and a function
+// that stops it early.
+func serveTriton(t *testing.T, s tritonpb.GRPCInferenceServiceServer) (string, func()) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	tritonpb.RegisterGRPCInferenceServiceServer(srv, s)
+	go func() {
+		_ = srv.Serve(lis) //nolint:errcheck // Serve returns nil on Stop()
+	}()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String(), srv.Stop
+}
+
+func TestTritonPoolFailover(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	Config.Set("triton_model", "yolo11")
+	defer Config.Set("triton_urls", nil)
+
+	a := &metadataServer{meta: yoloMetadata("FP32", 64, 64)}
+	b := &metadataServer{meta: yoloMetadata("FP32", 64, 64)}
+	addrA, stopA := serveTriton(t, a)
+	addrB, _ := serveTriton(t, b)
+	Config.Set("triton_urls", []string{addrA, " ", addrB})
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	if n := len(tritonEndpoints.endpoints); n != 2 {
+		t.Fatalf("%d endpoints, expected 2", n)
+	}
+
+	// Idle endpoints share the load.
+	jpegData := testJPEG(t, 64, 64)
+	for range 4 {
+		if dets, err := DetectObjects(jpegData); err != nil || len(dets) != 1 {
+			t.Fatalf("DetectObjects = %+v, %v", dets, err)
+		}
+	}
+	if a.infers.Load() == 0 || b.infers.Load() == 0 {
+		t.Errorf("requests split %d/%d, expected both endpoints used", a.infers.Load(), b.infers.Load())
+	}
+
+	// With one server gone every request still succeeds on the other.
+	stopA()
+	before := b.infers.Load()
+	for range 4 {
+		if dets, err := DetectObjects(jpegData); err != nil || len(dets) != 1 {
+			t.Fatalf("after failover DetectObjects = %+v, %v", dets, err)
+		}
+	}
+	if got := b.infers.Load() - before; got != 4 {
+		t.Errorf("surviving endpoint served %d requests, expected 4", got)
+	}
+	if tritonEndpoints.endpoints[0].healthy.Load() {
+		t.Error("stopped endpoint still marked healthy")
+	}
+	if err := tritonEndpoints.endpoints[0].checkHealth([]modelKey{{model: "yolo11"}}); err == nil {
+		t.Error("health check passed for a stopped server")
+	}
+}
+
+func TestTritonPoolChoose(t *testing.T) {
+	a, b, c := &TritonClient{addr: "a"}, &TritonClient{addr: "b"}, &TritonClient{addr: "c"}
+	for _, tc := range []*TritonClient{a, b, c} {
+		tc.healthy.Store(true)
+	}
+	tp := &tritonPool{endpoints: []*TritonClient{a, b, c}}
+	p := yoloConfig(context.Background())
+	compatible := func(tc *TritonClient) bool { return tc.compatible(p) }
+
+	a.outstanding.Store(2)
+	b.outstanding.Store(1)
+	c.outstanding.Store(3)
+	if got := tp.choose(nil, compatible); got != b {
+		t.Errorf("picked %s, expected the least busy endpoint b", got.addr)
+	}
+	if got := tp.choose([]*TritonClient{b}, compatible); got != a {
+		t.Errorf("picked %s after b failed, expected a", got.addr)
+	}
+
+	// Unhealthy and incompatible endpoints are passed over while others remain.
+	b.healthy.Store(false)
+	a.state(p).err = errors.New("incompatible")
+	if got := tp.choose(nil, compatible); got != c {
+		t.Errorf("picked %s, expected c", got.addr)
+	}
+	c.healthy.Store(false)
+	if got := tp.choose(nil, compatible); got != b {
+		t.Errorf("picked %s with none healthy, expected the least busy b", got.addr)
+	}
+	if got := tp.choose([]*TritonClient{b, c}, compatible); got != nil {
+		t.Errorf("picked %s with every endpoint tried", got.addr)
+	}
+}
+
+func TestTritonHealthCheck(t *testing.T) {
+	s := &metadataServer{meta: yoloMetadata("FP32", 64, 64)}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	tc := tritonEndpoints.endpoints[0]
+	if err := tc.checkHealth([]modelKey{{model: "yolo11"}}); err != nil {
+		t.Errorf("checkHealth: %v", err)
+	}
+	if err := tc.checkHealth([]modelKey{{model: "missing"}}); err == nil {
+		t.Error("expected an error for a model that is not loaded")
+	}
+
+	// Endpoints are checked for the models they serve, such as a camera's,
+	// rather than the default model.
+	Config.Set("triton_model", "default_model")
+	defer Config.Set("triton_model", nil)
+	tc.mu.Lock()
+	tc.models = nil
+	tc.mu.Unlock()
+	if err := tc.checkHealth(tc.modelsInUse()); err != nil {
+		t.Errorf("checkHealth before serving any model: %v", err)
+	}
+	camera := yoloParams{key: modelKey{model: "yolo11", format: "yolov8", width: 64, height: 64}}
+	night := camera
+	night.key.width = 32
+	tc.mu.Lock()
+	tc.state(camera)
+	tc.state(night)
+	tc.state(yoloParams{key: modelKey{model: "broken"}}).err = errors.New("incompatible")
+	tc.mu.Unlock()
+	if got := tc.modelsInUse(); len(got) != 1 || got[0] != (modelKey{model: "yolo11"}) {
+		t.Errorf("models in use %+v", got)
+	}
+	if err := tc.checkHealth(tc.modelsInUse()); err != nil {
+		t.Errorf("checkHealth for the camera model: %v", err)
+	}
+
+	// Servers without readiness RPCs are assumed ready.
+	addr, _ := serveTriton(t, tritonpb.UnimplementedGRPCInferenceServiceServer{})
+	Config.Set("triton_urls", []string{addr})
+	defer Config.Set("triton_urls", nil)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	if err := tritonEndpoints.endpoints[0].checkHealth([]modelKey{{model: "yolo11"}}); err != nil {
+		t.Errorf("checkHealth without readiness RPCs: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	tritongprc "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+// Triton system shared memory. With `triton_shared_memory` enabled and Triton
+// on the same host (sharing /dev/shm, e.g. a container run with --ipc=host),
+// input tensors are written to a POSIX shared-memory region registered with
+// the server instead of being serialised into each gRPC request. The region
+// is split into `triton_shared_memory_slots` slots (default
+// inference_concurrency), each large enough for one full batch, so concurrent
+// requests never share a buffer. It is only available on Linux; elsewhere, or
+// when the server refuses the region, tensors go in the request as before.
+
+// sharedMemory is a system shared-memory region registered with Triton.
+type sharedMemory struct {
+	name     string // region name known to Triton
+	key      string // POSIX shared-memory key
+	mem      []byte
+	slotSize int
+	free     chan int
+	close    func() error
+
+	mu     sync.Mutex
+	closed bool
+	users  sync.WaitGroup // requests between acquire and release
+}
+
+var shmSeq atomic.Int64
+
+// errSharedMemoryClosed is returned by acquire once the region is being
+// released; the request then sends its tensors in the request instead.
+var errSharedMemoryClosed = errors.New("shared memory released")
+
+// newSharedMemory creates a region of slots×slotSize bytes and registers it
+// with the server.
+func newSharedMemory(ctx context.Context, client tritongprc.GRPCInferenceServiceClient, slotSize, slots int) (*sharedMemory, error) {
+	name := fmt.Sprintf("home_controller_%d_%d", os.Getpid(), shmSeq.Add(1))
+	key := "/" + name
+	mem, closeFn, err := openSharedMemory(key, slotSize*slots)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.SystemSharedMemoryRegister(ctx, &tritongprc.SystemSharedMemoryRegisterRequest{
+		Name:     name,
+		Key:      key,
+		ByteSize: uint64(len(mem)), //nolint:gosec // positive size
+	}); err != nil {
+		if closeErr := closeFn(); closeErr != nil {
+			Logger.Warn().Err(closeErr).Msgf("triton: error releasing shared memory %s", key)
+		}
+		return nil, fmt.Errorf("register shared memory %s: %w", name, err)
+	}
+	s := &sharedMemory{
+		name:     name,
+		key:      key,
+		mem:      mem,
+		slotSize: slotSize,
+		free:     make(chan int, slots),
+		close:    closeFn,
+	}
+	for i := range slots {
+		s.free <- i
+	}
+	return s, nil
+}
+
+// setupSharedMemory switches the client's input transport to shared memory,
+// logging and carrying on without it if that fails.
+func (tc *TritonClient) setupSharedMemory(p yoloParams, info *TritonModelInfo) {
+	batch := p.batchLimit(info, max(1, Config.GetInt("inference_batch_size")))
+	slotSize := batch * 3 * p.width * p.height * max(1, inputElementSize(p.datatype))
+	slots := Config.GetInt("triton_shared_memory_slots")
+	if slots <= 0 {
+		slots = max(1, Config.GetInt("inference_concurrency"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tritonProbeTimeout)
+	defer cancel()
+	shm, err := newSharedMemory(ctx, tc.client, slotSize, slots)
+	if err != nil {
+		Logger.Warn().Msgf("triton: %v; sending tensors in the request", err)
+		return
+	}
+	tc.shm = shm
+	Logger.Info().Msgf("Triton inputs via shared memory %s: %d slots of %d bytes", shm.key, slots, slotSize)
+}
+
+// acquire reserves a slot, waiting for one to come free. The region stays
+// mapped until the slot is released.
+func (s *sharedMemory) acquire(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, errSharedMemoryClosed
+	}
+	s.users.Add(1)
+	s.mu.Unlock()
+	select {
+	case slot := <-s.free:
+		return slot, nil
+	case <-ctx.Done():
+		s.users.Done()
+		return 0, fmt.Errorf("waiting for a shared memory slot: %w", ctx.Err())
+	}
+}
+
+func (s *sharedMemory) release(slot int) {
+	s.free <- slot
+	s.users.Done()
+}
+
+// write copies the inputs, back to back, into a slot and returns the input
+// tensor parameters that point Triton at them.
+func (s *sharedMemory) write(slot int, inputs [][]byte) map[string]*tritongprc.InferParameter {
+	offset := slot * s.slotSize
+	n := offset
+	for _, in := range inputs {
+		n += copy(s.mem[n:offset+s.slotSize], in)
+	}
+	return map[string]*tritongprc.InferParameter{
+		"shared_memory_region":    {ParameterChoice: &tritongprc.InferParameter_StringParam{StringParam: s.name}},
+		"shared_memory_byte_size": {ParameterChoice: &tritongprc.InferParameter_Int64Param{Int64Param: int64(n - offset)}},
+		"shared_memory_offset":    {ParameterChoice: &tritongprc.InferParameter_Int64Param{Int64Param: int64(offset)}},
+	}
+}
+
+// unregister removes the region from the server and releases it once the
+// requests holding slots have finished with it; later requests send their
+// tensors in the request.
+func (s *sharedMemory) unregister(client tritongprc.GRPCInferenceServiceClient) {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.users.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tritonProbeTimeout)
+	defer cancel()
+	if _, err := client.SystemSharedMemoryUnregister(ctx, &tritongprc.SystemSharedMemoryUnregisterRequest{Name: s.name}); err != nil {
+		Logger.Warn().Err(err).Msgf("triton: error unregistering shared memory %s", s.name)
+	}
+	if err := s.close(); err != nil {
+		Logger.Warn().Err(err).Msgf("triton: error releasing shared memory %s", s.key)
+	}
+}
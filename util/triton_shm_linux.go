@@ -0,0 +1,35 @@
+//go:build linux
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// openSharedMemory creates the POSIX shared-memory object key, as shm_open
+// does, and maps size bytes of it. The returned func unmaps and removes it.
+func openSharedMemory(key string, size int) ([]byte, func() error, error) {
+	path := "/dev/shm/" + strings.TrimPrefix(key, "/")
+	// Triton often runs as another user; it only needs to read the inputs.
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644) //nolint:gosec // path is built from our own key
+	if err != nil {
+		return nil, nil, fmt.Errorf("shared memory: %w", err)
+	}
+	defer f.Close() //nolint:errcheck // the mapping outlives the descriptor
+	if err := f.Truncate(int64(size)); err != nil {
+		_ = os.Remove(path)
+		return nil, nil, fmt.Errorf("shared memory: %w", err)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED) //nolint:gosec // fd fits in an int
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, nil, fmt.Errorf("shared memory: mmap %s: %w", path, err)
+	}
+	return mem, func() error {
+		return errors.Join(syscall.Munmap(mem), os.Remove(path))
+	}, nil
+}
@@ -0,0 +1,172 @@
+//go:build linux
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	tritonpb "github.com/elijahnyp/home_controller/triton/generated"
+)
+
+// shmServer is a Triton mock that reads inputs from registered system
+// shared-memory regions, as Triton does.
+type shmServer struct {
+	*metadataServer
+	mu      sync.Mutex
+	regions map[string]string // name -> key
+	read    []int64           // input bytes read from shared memory, per request
+
+	arrived chan struct{} // when set, ModelInfer signals here and waits on hold
+	hold    chan struct{}
+}
+
+func (s *shmServer) SystemSharedMemoryRegister(_ context.Context, req *tritonpb.SystemSharedMemoryRegisterRequest) (*tritonpb.SystemSharedMemoryRegisterResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regions[req.GetName()] = req.GetKey()
+	return &tritonpb.SystemSharedMemoryRegisterResponse{}, nil
+}
+
+func (s *shmServer) SystemSharedMemoryUnregister(_ context.Context, req *tritonpb.SystemSharedMemoryUnregisterRequest) (*tritonpb.SystemSharedMemoryUnregisterResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.regions, req.GetName())
+	return &tritonpb.SystemSharedMemoryUnregisterResponse{}, nil
+}
+
+func (s *shmServer) ModelInfer(ctx context.Context, req *tritonpb.ModelInferRequest) (*tritonpb.ModelInferResponse, error) {
+	if s.arrived != nil {
+		s.arrived <- struct{}{}
+		<-s.hold
+	}
+	params := req.GetInputs()[0].GetParameters()
+	if region := params["shared_memory_region"].GetStringParam(); region != "" {
+		s.mu.Lock()
+		key, ok := s.regions[region]
+		s.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unregistered region %s", region)
+		}
+		data, err := os.ReadFile("/dev/shm" + key)
+		if err != nil {
+			return nil, err
+		}
+		offset := params["shared_memory_offset"].GetInt64Param()
+		size := params["shared_memory_byte_size"].GetInt64Param()
+		if offset+size > int64(len(data)) {
+			return nil, fmt.Errorf("input [%d, %d) outside region of %d bytes", offset, offset+size, len(data))
+		}
+		s.mu.Lock()
+		s.read = append(s.read, size)
+		s.mu.Unlock()
+	}
+	return s.metadataServer.ModelInfer(ctx, req)
+}
+
+func TestTritonSharedMemory(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	Config.Set("triton_shared_memory", true)
+	Config.Set("triton_shared_memory_slots", 2)
+	defer func() {
+		Config.Set("triton_shared_memory", nil)
+		Config.Set("triton_shared_memory_slots", nil)
+	}()
+	s := &shmServer{metadataServer: &metadataServer{meta: yoloMetadata("FP16", 64, 64)}, regions: make(map[string]string)}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	shm := tritonEndpoints.endpoints[0].shm
+	if shm == nil {
+		t.Fatal("shared memory was not set up")
+	}
+	if want := 3 * 64 * 64 * 2; shm.slotSize != want {
+		t.Errorf("slot size %d, expected %d", shm.slotSize, want)
+	}
+
+	jpegData := testJPEG(t, 64, 64)
+	for range 3 {
+		if dets, err := DetectObjects(jpegData); err != nil || len(dets) != 1 {
+			t.Fatalf("DetectObjects = %+v, %v", dets, err)
+		}
+	}
+	if len(s.read) != 3 || s.read[0] != int64(shm.slotSize) {
+		t.Errorf("inputs read from shared memory: %v", s.read)
+	}
+	if n := s.input.Load(); n != 0 {
+		t.Errorf("%d input bytes sent in the request as well", n)
+	}
+
+	// Reconnecting releases the region.
+	Config.Set("triton_shared_memory", false)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	if _, err := os.Stat("/dev/shm" + shm.key); !os.IsNotExist(err) {
+		t.Errorf("shared memory %s not removed: %v", shm.key, err)
+	}
+	if len(s.regions) != 0 {
+		t.Errorf("regions still registered: %v", s.regions)
+	}
+}
+
+func TestTritonSharedMemoryReloadInFlight(t *testing.T) {
+	Config.Set("min_confidence", 0.5)
+	Config.Set("triton_shared_memory", true)
+	defer Config.Set("triton_shared_memory", nil)
+	s := &shmServer{
+		metadataServer: &metadataServer{meta: yoloMetadata("FP16", 64, 64)},
+		regions:        make(map[string]string),
+		arrived:        make(chan struct{}),
+		hold:           make(chan struct{}),
+	}
+	startMetadataServer(t, s)
+	if err := InitTritonClient(); err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	shm := tritonEndpoints.endpoints[0].shm
+	if shm == nil {
+		t.Fatal("shared memory was not set up")
+	}
+
+	// A request holds a slot while the config reloads.
+	detected := make(chan error, 1)
+	go func() {
+		dets, err := DetectObjects(testJPEG(t, 64, 64))
+		if err == nil && len(dets) != 1 {
+			err = fmt.Errorf("detections %+v", dets)
+		}
+		detected <- err
+	}()
+	<-s.arrived
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- InitTritonClient() }()
+	select {
+	case err := <-reloaded:
+		t.Fatalf("reload released shared memory in use: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat("/dev/shm" + shm.key); err != nil {
+		t.Errorf("shared memory released while in use: %v", err)
+	}
+	close(s.hold)
+	if err := <-detected; err != nil {
+		t.Errorf("in-flight request: %v", err)
+	}
+
+	if err := <-reloaded; err != nil {
+		t.Fatalf("InitTritonClient: %v", err)
+	}
+	if _, err := os.Stat("/dev/shm" + shm.key); !os.IsNotExist(err) {
+		t.Errorf("shared memory %s not removed: %v", shm.key, err)
+	}
+	if _, err := shm.acquire(context.Background()); !errors.Is(err, errSharedMemoryClosed) {
+		t.Errorf("acquired a slot of released shared memory: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "errors"
+
+// openSharedMemory is only implemented on Linux.
+func openSharedMemory(string, int) ([]byte, func() error, error) {
+	return nil, nil, errors.New("shared memory: system shared memory is only supported on Linux")
+}
@@ -0,0 +1,432 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// YOLO output decoders. `yolo_output_format` selects one, or "auto" (the
+// default) picks it from the output tensors:
+//
+//	yolov8             [1, 4+C, A]  cx,cy,w,h then class scores (YOLOv8/11)
+//	yolov8_transposed  [1, A, 4+C]
+//	yolov5             [1, A, 5+C]  cx,cy,w,h,objectness then class scores
+//	yolov5_transposed  [1, 5+C, A]
+//	end2end            [1, N, 6]    x1,y1,x2,y2,score,class after NMS (YOLOv10,
+//	                                or exported with nms=True)
+//	ensemble           separate boxes [1,N,4] (x1,y1,x2,y2), scores [1,N] and
+//	                   classes [1,N] outputs, optionally num_dets [1,1]
+//	yolov8_pose        [1, 4+C+51, A]  yolov8 followed by 17 keypoints as
+//	                                   x,y,visibility (YOLOv8/11-pose)
+//	yolov8_pose_transposed  [1, A, 4+C+51]
+//	end2end_pose       [1, N, 57]   end2end followed by 17 keypoints
+//	yolov8_seg         [1, 4+C+M, A] yolov8 followed by M mask coefficients,
+//	                   plus [1, M, H, W] mask prototypes (YOLOv8/11-seg)
+//	yolov8_seg_transposed  [1, A, 4+C+M] plus prototypes
+//
+// Box and keypoint coordinates are in model-input pixels. Auto detection
+// treats the smaller dimension as the attributes; it picks yolov5 when that
+// equals 5 + the number of labels, a pose format for 56 attributes (one class)
+// or [N, 57], end2end for [N, 6] with N <= 1000, a segmentation format for
+// two outputs of which one is rank 4, and ensemble for other models with
+// several outputs.
+const (
+	YOLOFormatAuto         = "auto"
+	YOLOFormatV8           = "yolov8"
+	YOLOFormatV8Transposed = "yolov8_transposed"
+	YOLOFormatV5           = "yolov5"
+	YOLOFormatV5Transposed = "yolov5_transposed"
+	YOLOFormatEnd2End      = "end2end"
+	YOLOFormatEnsemble     = "ensemble"
+
+	YOLOFormatPose           = "yolov8_pose"
+	YOLOFormatPoseTransposed = "yolov8_pose_transposed"
+	YOLOFormatEnd2EndPose    = "end2end_pose"
+	YOLOFormatSeg            = "yolov8_seg"
+	YOLOFormatSegTransposed  = "yolov8_seg_transposed"
+
+	maxEnd2EndDetections = 1000
+	poseAttrs            = 3 * PoseKeypoints
+)
+
+// YOLOFormats lists every yolo_output_format value.
+var YOLOFormats = []string{YOLOFormatAuto, YOLOFormatV8, YOLOFormatV8Transposed, YOLOFormatV5, YOLOFormatV5Transposed,
+	YOLOFormatEnd2End, YOLOFormatEnsemble, YOLOFormatPose, YOLOFormatPoseTransposed, YOLOFormatEnd2EndPose,
+	YOLOFormatSeg, YOLOFormatSegTransposed}
+
+// outputTensor is one decoded model output.
+type outputTensor struct {
+	name  string
+	shape []int64
+	data  []float32
+}
+
+// tensorToFloat32 converts raw little-endian tensor contents to float32.
+func tensorToFloat32(datatype string, raw []byte) ([]float32, error) {
+	switch datatype {
+	case "", "FP32":
+		return rawBytesToFloat32(raw)
+	case "INT32":
+		if len(raw)%4 != 0 {
+			return nil, fmt.Errorf("INT32 tensor has %d bytes", len(raw))
+		}
+		f := make([]float32, len(raw)/4)
+		for i := range f {
+			f[i] = float32(int32(binary.LittleEndian.Uint32(raw[i*4:]))) //nolint:gosec // two's complement reinterpretation
+		}
+		return f, nil
+	case "INT64":
+		if len(raw)%8 != 0 {
+			return nil, fmt.Errorf("INT64 tensor has %d bytes", len(raw))
+		}
+		f := make([]float32, len(raw)/8)
+		for i := range f {
+			f[i] = float32(int64(binary.LittleEndian.Uint64(raw[i*8:]))) //nolint:gosec // two's complement reinterpretation
+		}
+		return f, nil
+	case "FP16":
+		if len(raw)%2 != 0 {
+			return nil, fmt.Errorf("FP16 tensor has %d bytes", len(raw))
+		}
+		f := make([]float32, len(raw)/2)
+		for i := range f {
+			f[i] = float16ToFloat32(binary.LittleEndian.Uint16(raw[i*2:]))
+		}
+		return f, nil
+	case "UINT8":
+		f := make([]float32, len(raw))
+		for i, b := range raw {
+			f[i] = float32(b)
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("unsupported tensor datatype %s", datatype)
+}
+
+// dropBatch removes a leading batch dimension of 1 (or -1 in metadata).
+func dropBatch(shape []int64, rank int) []int64 {
+	if len(shape) == rank+1 && shape[0] <= 1 {
+		return shape[1:]
+	}
+	return shape
+}
+
+// ensembleRole classifies an output name as one of the
+// separate box/score/class/num outputs of an ensemble, or "".
+func ensembleRole(name string) string {
+	n := strings.ToLower(name)
+	for _, role := range []string{"box", "score", "class", "num"} {
+		if strings.Contains(n, role) {
+			return role
+		}
+	}
+	return ""
+}
+
+// detectYOLOFormat resolves "auto" from the output shapes. Shapes may hold -1
+// for dynamic dimensions, in which case detection is left to inference time
+// and "auto" is returned.
+func detectYOLOFormat(outputs []outputTensor, numLabels int) (string, error) {
+	if len(outputs) == 0 {
+		return "", fmt.Errorf("model has no outputs")
+	}
+	if dets, protos, ok := segOutputs(outputs); ok {
+		return detectSegFormat(dets, protos)
+	}
+	if len(outputs) > 1 {
+		roles := make(map[string]bool)
+		for _, o := range outputs {
+			roles[ensembleRole(o.name)] = true
+		}
+		if roles["box"] && roles["score"] && roles["class"] {
+			return YOLOFormatEnsemble, nil
+		}
+		return "", fmt.Errorf("cannot tell boxes, scores and classes apart in %d outputs", len(outputs))
+	}
+	dims := dropBatch(outputs[0].shape, 2)
+	if len(dims) != 2 {
+		return "", fmt.Errorf("output %s has shape %v; expected rank 2 or 3", outputs[0].name, outputs[0].shape)
+	}
+	if dims[0] <= 0 || dims[1] <= 0 {
+		return YOLOFormatAuto, nil
+	}
+	if dims[1] == 6 && dims[0] <= maxEnd2EndDetections {
+		return YOLOFormatEnd2End, nil
+	}
+	if dims[1] == 6+poseAttrs && dims[0] <= maxEnd2EndDetections {
+		return YOLOFormatEnd2EndPose, nil
+	}
+	attrsFirst := dims[0] < dims[1]
+	attrs := dims[1]
+	if attrsFirst {
+		attrs = dims[0]
+	}
+	if attrs < 5 {
+		return "", fmt.Errorf("output %s has shape %v; too few attributes for a detector", outputs[0].name, outputs[0].shape)
+	}
+	v5 := numLabels > 0 && attrs == int64(5+numLabels)
+	pose := attrs == 5+poseAttrs && numLabels != 1+poseAttrs
+	switch {
+	case attrsFirst && pose:
+		return YOLOFormatPose, nil
+	case pose:
+		return YOLOFormatPoseTransposed, nil
+	case attrsFirst && v5:
+		return YOLOFormatV5Transposed, nil
+	case attrsFirst:
+		return YOLOFormatV8, nil
+	case v5:
+		return YOLOFormatV5, nil
+	}
+	return YOLOFormatV8Transposed, nil
+}
+
+// detectSegFormat picks the segmentation layout from which dimension of the
+// detection output is the attributes, as for yolov8.
+func detectSegFormat(dets, protos outputTensor) (string, error) {
+	dims := dropBatch(dets.shape, 2)
+	nm := dropBatch(protos.shape, 3)[0]
+	if dims[0] <= 0 || dims[1] <= 0 || nm <= 0 {
+		return YOLOFormatAuto, nil
+	}
+	attrs := min(dims[0], dims[1])
+	if attrs <= 4+nm {
+		return "", fmt.Errorf("output %s has shape %v; too few attributes for %d mask coefficients", dets.name, dets.shape, nm)
+	}
+	if dims[0] < dims[1] {
+		return YOLOFormatSeg, nil
+	}
+	return YOLOFormatSegTransposed, nil
+}
+
+// decodeYOLOOutputs decodes the outputs with the given format into labelled
+// detections in original image coordinates.
+func decodeYOLOOutputs(format string, outputs []outputTensor, lb letterbox, labels []string, minConf, iouThresh float32) ([]TritonDetection, error) {
+	if format == "" || format == YOLOFormatAuto {
+		var err error
+		if format, err = detectYOLOFormat(outputs, len(labels)); err != nil {
+			return nil, err
+		}
+		if format == YOLOFormatAuto {
+			return nil, fmt.Errorf("cannot detect output format from shape %v; set yolo_output_format", outputs[0].shape)
+		}
+	}
+
+	var boxes []detBox
+	var err error
+	switch format {
+	case YOLOFormatV8:
+		boxes, err = decodeDense(outputs[0], true, false, 0, 0, minConf)
+	case YOLOFormatV8Transposed:
+		boxes, err = decodeDense(outputs[0], false, false, 0, 0, minConf)
+	case YOLOFormatV5:
+		boxes, err = decodeDense(outputs[0], false, true, 0, 0, minConf)
+	case YOLOFormatV5Transposed:
+		boxes, err = decodeDense(outputs[0], true, true, 0, 0, minConf)
+	case YOLOFormatPose:
+		boxes, err = decodeDense(outputs[0], true, false, PoseKeypoints, 0, minConf)
+	case YOLOFormatPoseTransposed:
+		boxes, err = decodeDense(outputs[0], false, false, PoseKeypoints, 0, minConf)
+	case YOLOFormatSeg, YOLOFormatSegTransposed:
+		dets, protos, ok := segOutputs(outputs)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a detection output and a rank 4 prototype output, got %d outputs", format, len(outputs))
+		}
+		if boxes, err = decodeDense(dets, format == YOLOFormatSeg, false, 0, int(dropBatch(protos.shape, 3)[0]), minConf); err != nil {
+			return nil, err
+		}
+		boxes = nms(boxes, iouThresh)
+		results := toDetections(boxes, lb, labels)
+		for i := range results {
+			results[i].Mask = decodeMask(boxes[i].coeffs, protos, lb, results[i])
+		}
+		return results, nil
+	case YOLOFormatEnd2End:
+		boxes, err = decodeEnd2End(outputs[0], 0, minConf)
+	case YOLOFormatEnd2EndPose:
+		boxes, err = decodeEnd2End(outputs[0], PoseKeypoints, minConf)
+	case YOLOFormatEnsemble:
+		boxes, err = decodeEnsemble(outputs, minConf)
+	default:
+		return nil, fmt.Errorf("unknown yolo_output_format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// End-to-end models and ensembles have already run NMS.
+	if format != YOLOFormatEnd2End && format != YOLOFormatEnd2EndPose && format != YOLOFormatEnsemble {
+		boxes = nms(boxes, iouThresh)
+	}
+	return toDetections(boxes, lb, labels), nil
+}
+
+// decodeDense decodes per-anchor predictions. attrsFirst selects the
+// [attrs, anchors] layout over [anchors, attrs]; objectness selects YOLOv5
+// style rows with an objectness score ahead of the class scores; keypoints is
+// the number of x,y,visibility triples and coeffs the number of mask
+// coefficients after the class scores.
+func decodeDense(out outputTensor, attrsFirst, objectness bool, keypoints, coeffs int, minConf float32) ([]detBox, error) {
+	dims := dropBatch(out.shape, 2)
+	if len(dims) != 2 {
+		return nil, fmt.Errorf("output %s has shape %v; expected rank 2 or 3", out.name, out.shape)
+	}
+	numAttrs, numAnchors := int(dims[1]), int(dims[0])
+	if attrsFirst {
+		numAttrs, numAnchors = numAnchors, numAttrs
+	}
+	first := 4 // first class score
+	if objectness {
+		first = 5
+	}
+	tail := 3*keypoints + coeffs
+	numClasses := numAttrs - first - tail
+	if numClasses <= 0 {
+		return nil, fmt.Errorf("output %s has shape %v; expected at least %d attributes", out.name, out.shape, first+tail+1)
+	}
+	if len(out.data) < numAttrs*numAnchors {
+		return nil, fmt.Errorf("output %s has %d values, shape %v needs %d", out.name, len(out.data), out.shape, numAttrs*numAnchors)
+	}
+	at := func(attr, a int) float32 {
+		if attrsFirst {
+			return out.data[attr*numAnchors+a]
+		}
+		return out.data[a*numAttrs+attr]
+	}
+
+	var boxes []detBox
+	for a := 0; a < numAnchors; a++ {
+		obj := float32(1)
+		if objectness {
+			if obj = at(4, a); obj < minConf {
+				continue
+			}
+		}
+		// Find best class.
+		bestClass := 0
+		bestScore := float32(0)
+		for c := 0; c < numClasses; c++ {
+			if score := at(first+c, a); score > bestScore {
+				bestScore = score
+				bestClass = c
+			}
+		}
+		bestScore *= obj
+		if bestScore < minConf {
+			continue
+		}
+		// Convert center format → xyxy (still in model-input pixel space).
+		cx, cy, w, h := at(0, a), at(1, a), at(2, a), at(3, a)
+		box := detBox{x1: cx - w/2, y1: cy - h/2, x2: cx + w/2, y2: cy + h/2, conf: bestScore, classIdx: bestClass}
+		if keypoints > 0 {
+			box.kpts = make([]float32, 3*keypoints)
+			for k := range box.kpts {
+				box.kpts[k] = at(first+numClasses+k, a)
+			}
+		}
+		if coeffs > 0 {
+			box.coeffs = make([]float32, coeffs)
+			for k := range box.coeffs {
+				box.coeffs[k] = at(first+numClasses+3*keypoints+k, a)
+			}
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
+
+// decodeEnd2End decodes rows of x1,y1,x2,y2,score,class, each followed by
+// keypoints x,y,visibility triples.
+func decodeEnd2End(out outputTensor, keypoints int, minConf float32) ([]detBox, error) {
+	width := 6 + 3*keypoints
+	dims := dropBatch(out.shape, 2)
+	if len(dims) != 2 || dims[1] != int64(width) {
+		return nil, fmt.Errorf("output %s has shape %v; expected [N, %d]", out.name, out.shape, width)
+	}
+	n := int(dims[0])
+	if len(out.data) < n*width {
+		return nil, fmt.Errorf("output %s has %d values, shape %v needs %d", out.name, len(out.data), out.shape, n*width)
+	}
+	var boxes []detBox
+	for i := 0; i < n; i++ {
+		row := out.data[i*width : (i+1)*width]
+		if row[4] < minConf {
+			continue
+		}
+		box := detBox{x1: row[0], y1: row[1], x2: row[2], y2: row[3], conf: row[4], classIdx: int(row[5])}
+		if keypoints > 0 {
+			box.kpts = row[6:]
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
+
+func decodeEnsemble(outputs []outputTensor, minConf float32) ([]detBox, error) {
+	var boxes, scores, classes *outputTensor
+	count := -1
+	for i := range outputs {
+		switch ensembleRole(outputs[i].name) {
+		case "box":
+			boxes = &outputs[i]
+		case "score":
+			scores = &outputs[i]
+		case "class":
+			classes = &outputs[i]
+		case "num":
+			if len(outputs[i].data) > 0 {
+				count = int(outputs[i].data[0])
+			}
+		}
+	}
+	if boxes == nil || scores == nil || classes == nil {
+		return nil, fmt.Errorf("ensemble needs boxes, scores and classes outputs")
+	}
+	n := len(scores.data)
+	if len(classes.data) < n || len(boxes.data) < n*4 {
+		return nil, fmt.Errorf("ensemble outputs disagree: %d boxes, %d scores, %d classes", len(boxes.data)/4, n, len(classes.data))
+	}
+	if count >= 0 && count < n {
+		n = count
+	}
+	var out []detBox
+	for i := 0; i < n; i++ {
+		if scores.data[i] < minConf {
+			continue
+		}
+		b := boxes.data[i*4 : i*4+4]
+		out = append(out, detBox{x1: b[0], y1: b[1], x2: b[2], y2: b[3], conf: scores.data[i], classIdx: int(classes.data[i])})
+	}
+	return out, nil
+}
+
+// toDetections maps boxes from model-input space back to the original image.
+func toDetections(boxes []detBox, lb letterbox, labels []string) []TritonDetection {
+	var results []TritonDetection
+	for _, d := range boxes {
+		// Remove letterbox padding, undo scale.
+		ox1 := int(math.Round(float64((d.x1 - float32(lb.padX)) / float32(lb.scale)))) //nolint:mnd
+		oy1 := int(math.Round(float64((d.y1 - float32(lb.padY)) / float32(lb.scale)))) //nolint:mnd
+		ox2 := int(math.Round(float64((d.x2 - float32(lb.padX)) / float32(lb.scale)))) //nolint:mnd
+		oy2 := int(math.Round(float64((d.y2 - float32(lb.padY)) / float32(lb.scale)))) //nolint:mnd
+
+		det := TritonDetection{
+			Label:      labelFor(labels, d.classIdx),
+			Confidence: d.conf,
+			XMin:       clampInt(ox1, 0, lb.origW),
+			YMin:       clampInt(oy1, 0, lb.origH),
+			XMax:       clampInt(ox2, 0, lb.origW),
+			YMax:       clampInt(oy2, 0, lb.origH),
+		}
+		for k := 0; k+2 < len(d.kpts); k += 3 {
+			det.Keypoints = append(det.Keypoints, Keypoint{
+				X:          clampInt(int(math.Round(float64((d.kpts[k]-float32(lb.padX))/float32(lb.scale)))), 0, lb.origW),
+				Y:          clampInt(int(math.Round(float64((d.kpts[k+1]-float32(lb.padY))/float32(lb.scale)))), 0, lb.origH),
+				Confidence: d.kpts[k+2],
+			})
+		}
+		results = append(results, det)
+	}
+	return results
+}
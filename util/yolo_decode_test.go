@@ -0,0 +1,138 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// identity letterbox: model input and image are both 100×100.
+var testLetterbox = letterbox{scale: 1, origW: 100, origH: 100}
+
+// denseOutput builds a single-detection tensor for a person centred at
+// (50, 50), 20 px square, in the requested layout.
+func denseOutput(attrsFirst, objectness bool, classes, anchors int) outputTensor {
+	first := 4
+	if objectness {
+		first = 5
+	}
+	attrs := first + classes
+	data := make([]float32, attrs*anchors)
+	set := func(attr, a int, v float32) {
+		if attrsFirst {
+			data[attr*anchors+a] = v
+		} else {
+			data[a*attrs+attr] = v
+		}
+	}
+	set(0, 3, 50)
+	set(1, 3, 50)
+	set(2, 3, 20)
+	set(3, 3, 20)
+	if objectness {
+		set(4, 3, 0.9)
+	}
+	set(first, 3, 0.8)
+	shape := []int64{1, int64(anchors), int64(attrs)}
+	if attrsFirst {
+		shape = []int64{1, int64(attrs), int64(anchors)}
+	}
+	return outputTensor{name: "output0", shape: shape, data: data}
+}
+
+func TestDecodeYOLOFormats(t *testing.T) {
+	labels := []string{"person", "cat", "dog"}
+	end2end := outputTensor{name: "output0", shape: []int64{1, 2, 6}, data: []float32{
+		40, 40, 60, 60, 0.8, 0,
+		0, 0, 10, 10, 0.1, 1,
+	}}
+	ensemble := []outputTensor{
+		{name: "num_dets", shape: []int64{1, 1}, data: []float32{1}},
+		{name: "det_boxes", shape: []int64{1, 2, 4}, data: []float32{40, 40, 60, 60, 1, 1, 5, 5}},
+		{name: "det_scores", shape: []int64{1, 2}, data: []float32{0.8, 0.9}},
+		{name: "det_classes", shape: []int64{1, 2}, data: []float32{0, 1}},
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		outputs []outputTensor
+		auto    string
+	}{
+		{"yolov8", YOLOFormatV8, []outputTensor{denseOutput(true, false, 3, 50)}, YOLOFormatV8},
+		{"yolov8 transposed", YOLOFormatV8Transposed, []outputTensor{denseOutput(false, false, 3, 50)}, YOLOFormatV8Transposed},
+		{"yolov5", YOLOFormatV5, []outputTensor{denseOutput(false, true, 3, 50)}, YOLOFormatV5},
+		{"yolov5 transposed", YOLOFormatV5Transposed, []outputTensor{denseOutput(true, true, 3, 50)}, YOLOFormatV5Transposed},
+		{"end2end", YOLOFormatEnd2End, []outputTensor{end2end}, YOLOFormatEnd2End},
+		{"ensemble", YOLOFormatEnsemble, ensemble, YOLOFormatEnsemble},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auto, err := detectYOLOFormat(tt.outputs, len(labels))
+			if err != nil || auto != tt.auto {
+				t.Errorf("detectYOLOFormat = %q, %v; expected %q", auto, err, tt.auto)
+			}
+			for _, format := range []string{tt.format, YOLOFormatAuto} {
+				dets, err := decodeYOLOOutputs(format, tt.outputs, testLetterbox, labels, 0.5, 0.45)
+				if err != nil {
+					t.Fatalf("%s: decode: %v", format, err)
+				}
+				if len(dets) != 1 {
+					t.Fatalf("%s: expected 1 detection, got %+v", format, dets)
+				}
+				d := dets[0]
+				if d.Label != "person" || d.XMin != 40 || d.YMin != 40 || d.XMax != 60 || d.YMax != 60 {
+					t.Errorf("%s: unexpected detection %+v", format, d)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeYOLOv5Objectness(t *testing.T) {
+	out := denseOutput(false, true, 3, 50)
+	out.data[3*8+4] = 0.5 // objectness 0.5 × class 0.8 = 0.4
+	dets, err := decodeYOLOOutputs(YOLOFormatV5, []outputTensor{out}, testLetterbox, nil, 0.5, 0.45)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dets) != 0 {
+		t.Errorf("objectness should scale the score below threshold, got %+v", dets)
+	}
+}
+
+func TestDetectYOLOFormatErrors(t *testing.T) {
+	if f, err := detectYOLOFormat([]outputTensor{{name: "output0", shape: []int64{-1, 84, -1}}}, 80); err != nil || f != YOLOFormatAuto {
+		t.Errorf("dynamic shape: got %q, %v; expected auto", f, err)
+	}
+	if _, err := detectYOLOFormat([]outputTensor{{name: "a"}, {name: "b"}}, 80); err == nil {
+		t.Error("expected an error for unnamed multiple outputs")
+	}
+	if _, err := decodeYOLOOutputs("yolov9", []outputTensor{denseOutput(true, false, 3, 50)}, testLetterbox, nil, 0.5, 0.45); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestTensorToFloat32(t *testing.T) {
+	raw := make([]byte, 8)
+	binary.LittleEndian.PutUint32(raw, 7)
+	binary.LittleEndian.PutUint32(raw[4:], 0xFFFFFFFF)
+	f, err := tensorToFloat32("INT32", raw)
+	if err != nil || len(f) != 2 || f[0] != 7 || f[1] != -1 {
+		t.Errorf("INT32: %v, %v", f, err)
+	}
+	f, err = tensorToFloat32("INT64", raw)
+	if err != nil || len(f) != 1 || f[0] != -4294967289 {
+		t.Errorf("INT64: %v, %v", f, err)
+	}
+	f, err = tensorToFloat32("FP16", []byte{0x00, 0x3c, 0x00, 0xb8})
+	if err != nil || len(f) != 2 || f[0] != 1 || f[1] != -0.5 {
+		t.Errorf("FP16: %v, %v", f, err)
+	}
+	f, err = tensorToFloat32("UINT8", []byte{0, 128, 255})
+	if err != nil || len(f) != 3 || f[1] != 128 || f[2] != 255 {
+		t.Errorf("UINT8: %v, %v", f, err)
+	}
+	if _, err := tensorToFloat32("STRING", raw); err == nil {
+		t.Error("expected an error for STRING")
+	}
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image/jpeg"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -53,23 +61,28 @@ type SystemStatus struct {
 	OccupiedRooms  int               `json:"occupied_rooms"`
 	ActiveMotion   int               `json:"active_motion"`
 	TotalCameras   int               `json:"total_cameras"`
+	Detector       DetectorHealth    `json:"detector"`
 }
 
 // WebRoomStatus represents room status for web interface
 type WebRoomStatus struct {
-	Name            string `json:"name"`
-	Occupied        bool   `json:"occupied"`
-	Motion          bool   `json:"motion"`
-	LastUpdate      int64  `json:"last_update"`
-	OccupancyPeriod int    `json:"occupancy_period"`
+	Name            string   `json:"name"`
+	People          []string `json:"people"`
+	Occupied        bool     `json:"occupied"`
+	Motion          bool     `json:"motion"`
+	LastUpdate      int64    `json:"last_update"`
+	OccupancyPeriod int      `json:"occupancy_period"`
 }
 
 // DetectionResult represents an AI detection result
 type DetectionResult struct {
-	RoomName   string  `json:"room_name"`
-	Label      string  `json:"label"`
-	Confidence float32 `json:"confidence"`
-	Timestamp  int64   `json:"timestamp"`
+	RoomName   string           `json:"room_name"`
+	Label      string           `json:"label"`
+	Confidence float32          `json:"confidence"`
+	Timestamp  int64            `json:"timestamp"`
+	ImageZones []string         `json:"image_zones,omitempty"`
+	TrackID    int64            `json:"track_id,omitempty"`
+	Secondary  *SecondaryResult `json:"secondary,omitempty"`
 }
 
 // ActivityItem represents a system activity
@@ -85,10 +98,25 @@ type RoomDetail struct {
 	Name       string            `json:"name"`
 	Images     []RoomImage       `json:"images"`
 	Detections []DetectionResult `json:"detections"`
+	Tracks     []Track           `json:"tracks"`
+	Count      int               `json:"people_count"`
+	People     []string          `json:"people"`
+	Devices    []DeviceStatus    `json:"devices"`
+	Override   int64             `json:"lighting_override_until"`
 	Occupied   bool              `json:"occupied"`
 	Motion     bool              `json:"motion"`
 }
 
+// DeviceStatus represents the mirrored state of an MQTT-backed light or sensor
+type DeviceStatus struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	State      string `json:"state"`
+	Status     uint   `json:"status"`
+	LastUpdate int64  `json:"last_update"`
+	Available  bool   `json:"available"`
+}
+
 // RoomImage represents a camera image from a room
 type RoomImage struct {
 	Topic     string `json:"topic"`
@@ -236,8 +264,9 @@ func APISystemStatus(w http.ResponseWriter, r *http.Request) {
 		ActiveMotion:   0,
 		TotalCameras:   0,
 		RoomStatuses:   []WebRoomStatus{},
-		RecentActivity: []ActivityItem{},
+		RecentActivity: RecentActivity(),
 		Detections:     []DetectionResult{},
+		Detector:       CurrentDetectorHealth(),
 	}
 
 	// Calculate stats and room statuses
@@ -266,6 +295,7 @@ func APISystemStatus(w http.ResponseWriter, r *http.Request) {
 
 		status.RoomStatuses = append(status.RoomStatuses, WebRoomStatus{
 			Name:            room.Name,
+			People:          PeopleInRoom(room.Name),
 			Occupied:        occupied,
 			Motion:          motion,
 			LastUpdate:      lastUpdate,
@@ -295,6 +325,34 @@ func APIRoomDetail(w http.ResponseWriter, r *http.Request) {
 		Motion:     false,
 		Images:     []RoomImage{},
 		Detections: []DetectionResult{},
+		Tracks:     ActiveTracks(roomName),
+		Count:      PeopleCount(roomName),
+		People:     PeopleInRoom(roomName),
+		Devices:    []DeviceStatus{},
+		Override:   lighting.OverrideUntil(roomName),
+	}
+
+	if devRoom, ok := CurrentDevices().Room(roomName); ok {
+		for _, l := range devRoom.Lights() {
+			detail.Devices = append(detail.Devices, DeviceStatus{
+				Name:       l.Name(),
+				Kind:       "light",
+				State:      l.Raw(),
+				Status:     l.Status(),
+				LastUpdate: l.LastUpdate(),
+				Available:  l.Available(),
+			})
+		}
+		for _, s := range devRoom.Sensors() {
+			detail.Devices = append(detail.Devices, DeviceStatus{
+				Name:       s.Name(),
+				Kind:       "sensor",
+				State:      s.Raw(),
+				Status:     s.Status(),
+				LastUpdate: s.LastUpdate(),
+				Available:  s.Available(),
+			})
+		}
 	}
 
 	// Find the room to get its pic topics
@@ -330,6 +388,9 @@ func APIRoomDetail(w http.ResponseWriter, r *http.Request) {
 							Label:      pred.Label,
 							Confidence: pred.Confidence,
 							Timestamp:  cacheItem.results.Timestamp,
+							ImageZones: pred.ImageZones,
+							TrackID:    pred.Track_id,
+							Secondary:  pred.Secondary,
 						})
 					}
 				}
@@ -344,6 +405,164 @@ func APIRoomDetail(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// APIPresence returns the BLE-derived room of every tracked person as JSON
+func APIPresence(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetPresence()); err != nil {
+		Logger.Error().Err(err).Msg("Error encoding presence")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// APIShadow returns the shadow model report as JSON. With ?frame=<id> it
+// returns that disagreeing frame as a JPEG marked with the shadow model's
+// boxes, or the primary's with &boxes=primary.
+func APIShadow(w http.ResponseWriter, r *http.Request) {
+	frameID := r.URL.Query().Get("frame")
+	if frameID == "" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(CurrentShadowReport()); err != nil {
+			Logger.Error().Err(err).Msg("Error encoding shadow report")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(frameID, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid frame id", http.StatusBadRequest)
+		return
+	}
+	frame, im, ok := ShadowFrameByID(id)
+	if !ok {
+		http.Error(w, "Unknown frame", http.StatusNotFound)
+		return
+	}
+	dets := frame.Shadow
+	if r.URL.Query().Get("boxes") == "primary" {
+		dets = frame.Primary
+	}
+	var spec []MarkupSpec
+	for _, d := range dets {
+		spec = append(spec, MarkupSpec{d.Label, point{d.XMin, d.YMin}, point{d.XMax, d.YMax}, d.Confidence, d.Keypoints, d.Mask})
+	}
+	imgsource, err := jpeg.Decode(bytes.NewReader(im))
+	if err != nil {
+		http.Error(w, "Error decoding image", http.StatusInternalServerError)
+		return
+	}
+	imgWriter := bytes.NewBuffer(nil)
+	if err := jpeg.Encode(imgWriter, MarkupImage(imgsource, spec), nil); err != nil {
+		http.Error(w, "Error encoding image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	if _, err := w.Write(imgWriter.Bytes()); err != nil {
+		Logger.Error().Msgf("Error writing image response: %v", err)
+	}
+}
+
+// galleryUploadLimit bounds an enrollment photo.
+const galleryUploadLimit = 10 << 20
+
+// GalleryStatus lists the people who can be enrolled, with how many reference
+// images each has, and the enrolled references.
+type GalleryStatus struct {
+	People  map[string]int `json:"people"`
+	Entries []GalleryEntry `json:"entries"`
+}
+
+// APIGallery manages known-person enrollment. GET lists the gallery; POST
+// ?person=<name> enrolls the JPEG in the body (or in the "image" field of a
+// multipart form); DELETE ?id=<id> or ?person=<name> removes references.
+func APIGallery(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status := GalleryStatus{People: make(map[string]int), Entries: GalleryEntries()}
+		for _, p := range CurrentModel().People {
+			status.People[p.Name] = 0
+		}
+		for _, e := range status.Entries {
+			status.People[e.Person]++
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			Logger.Error().Err(err).Msg("Error encoding gallery")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		person := r.URL.Query().Get("person")
+		if !slices.ContainsFunc(CurrentModel().People, func(p Person) bool { return p.Name == person }) {
+			http.Error(w, "Unknown person", http.StatusBadRequest)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, galleryUploadLimit)
+		var body io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			file, _, err := r.FormFile("image")
+			if err != nil {
+				http.Error(w, "Image required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			body = file
+		}
+		data, err := io.ReadAll(body)
+		if err != nil || len(data) == 0 {
+			http.Error(w, "Image required", http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), DetectorTimeout())
+		defer cancel()
+		entry, err := EnrollPerson(ctx, person, data)
+		switch {
+		case errors.Is(err, ErrGalleryNoModel), errors.Is(err, ErrGalleryEmbedding):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			Logger.Warn().Msgf("Enrolling %s: %v", person, err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		AddActivity("gallery", "", fmt.Sprintf("Enrolled a reference image for %s", person))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(entry); err != nil {
+			Logger.Error().Err(err).Msg("Error encoding gallery entry")
+		}
+
+	case http.MethodDelete:
+		var id int64
+		if s := r.URL.Query().Get("id"); s != "" {
+			var err error
+			if id, err = strconv.ParseInt(s, 10, 64); err != nil || id == 0 {
+				http.Error(w, "Invalid id", http.StatusBadRequest)
+				return
+			}
+		}
+		person := r.URL.Query().Get("person")
+		if id == 0 && person == "" {
+			http.Error(w, "id or person required", http.StatusBadRequest)
+			return
+		}
+		removed, err := RemoveGalleryEntries(id, person)
+		if err != nil {
+			Logger.Error().Msgf("Updating gallery: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if removed == 0 {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // RoomDetailHandler serves the room detail page
 func RoomDetailHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "web/static/room.html")